@@ -0,0 +1,61 @@
+package main
+
+import (
+    "encoding/binary"
+    "runtime"
+    "time"
+)
+
+// tuneSamples is the number of values tuneParallelism times each worker
+// count on: the smallest batch that batchLeaves parallelizes.
+const tuneSamples = parallelReduceMin
+
+// tuneParallelism times converting tuneSamples values into leaves, as
+// batchLeaves does with this tree's options, with 1, 2, 4, ... workers up to
+// runtime.NumCPU() and returns the fastest count. Each count runs twice and
+// keeps its better time, which evens out the first run warming caches. If
+// the tree cannot convert the sample values, as with WideLeaves, it returns
+// 1.
+func (tree *MerkleTree) tuneParallelism() int {
+    keys := make([][]byte, tuneSamples)
+    values := make([][]byte, tuneSamples)
+    for i := range values {
+        keys[i] = binary.BigEndian.AppendUint64(nil, uint64(i))
+        values[i] = make([]byte, fpSize-1)
+        binary.LittleEndian.PutUint64(values[i], uint64(i)*0x9e3779b97f4a7c15)
+    }
+    if _, _, err := tree.leaf(keys[0], values[0]); err != nil {
+        return 1
+    }
+
+    cpus := runtime.NumCPU()
+    candidates := []int{1}
+    for n := 2; n < cpus; n *= 2 {
+        candidates = append(candidates, n)
+    }
+    if cpus > 1 {
+        candidates = append(candidates, cpus)
+    }
+
+    best, bestTime := 1, time.Duration(-1)
+    for _, workers := range candidates {
+        for run := 0; run < 2; run++ {
+            start := time.Now()
+            runShares(tuneSamples, workers, func(lo, hi int) {
+                for i := lo; i < hi; i++ {
+                    tree.leaf(keys[i], values[i])
+                }
+            })
+            if elapsed := time.Since(start); bestTime < 0 || elapsed < bestTime {
+                best, bestTime = workers, elapsed
+            }
+        }
+    }
+    return best
+}
+
+// Parallelism returns the number of goroutines AddBatch spreads work over:
+// the one set WithParallelism or chosen WithAutoParallelism, or GOMAXPROCS.
+func (tree *MerkleTree) Parallelism() int {
+    return tree.parallelism()
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+    "runtime"
+    "testing"
+)
+
+func TestAutoParallelismWithinCPUs(t *testing.T) {
+    for name, opts := range map[string][]Option{
+        "Reduced":    {WithAutoParallelism()},
+        "Hashed":     {WithAutoParallelism(), WithStoreHashedValues()},
+        "WideLeaves": {WithAutoParallelism(), WithWideLeaves(2)},
+    } {
+        tree := newTestTree(t, opts...)
+        if n := tree.Parallelism(); n < 1 || n > runtime.NumCPU() {
+            t.Errorf("%s: autotuner picked %d workers, want 1 to %d", name, n, runtime.NumCPU())
+        }
+    }
+
+    tree := newTestTree(t, WithAutoParallelism(), WithParallelism(3))
+    if n := tree.Parallelism(); n != 3 {
+        t.Errorf("explicit parallelism of 3 became %d", n)
+    }
+}
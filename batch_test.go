@@ -0,0 +1,172 @@
+package main
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+    "math/rand"
+    "runtime"
+    "testing"
+)
+
+// randomValues returns n keys and fpSize-byte values, most of them above
+// the field modulus so that reducing them does real work.
+func randomValues(n int) (keys, values [][]byte) {
+    rng := rand.New(rand.NewSource(1))
+    keys = make([][]byte, n)
+    values = make([][]byte, n)
+    for i := range values {
+        keys[i] = []byte(fmt.Sprintf("key-%d", i))
+        values[i] = make([]byte, fpSize)
+        rng.Read(values[i])
+    }
+    return keys, values
+}
+
+func TestBatchLeavesParallelMatchesSerial(t *testing.T) {
+    keys, values := randomValues(2*parallelReduceMin + 17)
+
+    serial := newTestTree(t, WithNoPersistence(), WithParallelism(1))
+    parallel := newTestTree(t, WithNoPersistence(), WithParallelism(7))
+
+    wantStored, wantLeaves, err := serial.batchLeaves(keys, values)
+    if err != nil {
+        t.Fatal(err)
+    }
+    gotStored, gotLeaves, err := parallel.batchLeaves(keys, values)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for i := range values {
+        if !bytes.Equal(gotStored[i], wantStored[i]) || !bytes.Equal(gotLeaves[i], wantLeaves[i]) {
+            t.Fatalf("value %d: parallel reduction differs from serial", i)
+        }
+    }
+
+    if err := serial.AddBatch(keys, values); err != nil {
+        t.Fatal(err)
+    }
+    if err := parallel.AddBatch(keys, values); err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(parallel.Root(), serial.Root()) {
+        t.Errorf("root %x with 7 workers, %x with 1", parallel.Root(), serial.Root())
+    }
+}
+
+func TestBatchLeavesParallelReportsFirstError(t *testing.T) {
+    keys, values := randomValues(2 * parallelReduceMin)
+    // Two oversized values, in different shares: the lower one is reported.
+    values[len(values)-1] = make([]byte, fpSize+2)
+    values[3] = make([]byte, fpSize+1)
+
+    tree := newTestTree(t, WithNoPersistence(), WithParallelism(4))
+    _, _, err := tree.batchLeaves(keys, values)
+    if !errors.Is(err, ErrValueTooLarge) {
+        t.Fatalf("err = %v, want ErrValueTooLarge", err)
+    }
+    if want := fmt.Sprintf("value is %d bytes", fpSize+1); !bytes.Contains([]byte(err.Error()), []byte(want)) {
+        t.Errorf("err = %v, want the error of value 3", err)
+    }
+}
+
+// BenchmarkBatchLeaves times turning a batch of 1M values into leaves, as
+// AddBatch does before the C tree is built, serially and over GOMAXPROCS
+// workers.
+func BenchmarkBatchLeaves(b *testing.B) {
+    keys, values := randomValues(1 << 20)
+
+    counts := []int{1}
+    if n := runtime.GOMAXPROCS(0); n > 1 {
+        counts = append(counts, n)
+    }
+    for _, workers := range counts {
+        b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+            tree := newTestTree(b, WithNoPersistence(), WithParallelism(workers))
+            b.ResetTimer()
+            for i := 0; i < b.N; i++ {
+                if _, _, err := tree.batchLeaves(keys, values); err != nil {
+                    b.Fatal(err)
+                }
+            }
+        })
+    }
+}
+
+// TestAddBatchUndoneOnChunkFailure fails the commit of the second of three
+// chunks. The chunk committed before it must be undone as well, in memory
+// and in the database.
+func TestAddBatchUndoneOnChunkFailure(t *testing.T) {
+    database := &failDB{Database: newTestDB(t)}
+    keys, values := testLeaves(2*batchWriteChunk + 10)
+
+    tree := NewMerkleTree(database)
+    defer tree.Close()
+    addAll(t, tree, keys[:2], values[:2])
+    before := tree.Root()
+
+    // The WAL entries and the first chunk commit; the second chunk fails.
+    database.skip, database.fail = 2, 1
+    var done int
+    err := tree.AddBatchWithProgress(keys[2:], values[2:], func(n, _ int) { done = n })
+    if !errors.Is(err, errInjected) {
+        t.Fatalf("batch with a failing chunk = %v, want the injected error", err)
+    }
+    if done != batchWriteChunk {
+        t.Fatalf("%d leaves reported before the failure, want %d", done, batchWriteChunk)
+    }
+    if !bytes.Equal(tree.Root(), before) {
+        t.Fatalf("root %x after a failed batch, want %x", tree.Root(), before)
+    }
+    if _, err := tree.Get(keys[2]); !errors.Is(err, ErrKeyNotFound) {
+        t.Fatalf("get of a key from the undone chunk = %v, want ErrKeyNotFound", err)
+    }
+    if err := tree.CheckConsistency(); err != nil {
+        t.Fatal(err)
+    }
+
+    loaded, err := LoadMerkleTreeStrict(database)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer loaded.Close()
+    if !bytes.Equal(loaded.Root(), before) {
+        t.Fatalf("loaded root %x, want %x", loaded.Root(), before)
+    }
+    if _, err := loaded.Get(keys[2]); !errors.Is(err, ErrKeyNotFound) {
+        t.Fatalf("loaded get of a key from the undone chunk = %v, want ErrKeyNotFound", err)
+    }
+
+    if err := tree.AddBatch(keys[2:], values[2:]); err != nil {
+        t.Fatalf("retrying the batch: %v", err)
+    }
+}
+
+func TestAddPairsMatchesAddBatch(t *testing.T) {
+    keys, values := testLeaves(37)
+    pairs := make([][2][]byte, len(keys))
+    for i := range keys {
+        pairs[i] = [2][]byte{keys[i], values[i]}
+    }
+
+    batch := newTestTree(t)
+    if err := batch.AddBatch(keys, values); err != nil {
+        t.Fatal(err)
+    }
+    paired := newTestTree(t)
+    if err := paired.AddPairs(pairs); err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(paired.Root(), batch.Root()) {
+        t.Fatalf("AddPairs root %x, AddBatch root %x", paired.Root(), batch.Root())
+    }
+    for i, key := range keys {
+        got, err := paired.Get(key)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !bytes.Equal(got, values[i]) {
+            t.Fatalf("%s holds %x, want %x", key, got, values[i])
+        }
+    }
+}
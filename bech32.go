@@ -0,0 +1,35 @@
+package main
+
+import (
+    "fmt"
+
+    "github.com/btcsuite/btcutil/bech32"
+)
+
+// RootBech32 returns the root, as returned by Root, encoded as bech32 with
+// the human-readable part hrp, such as "pmroot". The checksum catches
+// mistyped characters when roots are copied by hand.
+func (tree *MerkleTree) RootBech32(hrp string) (string, error) {
+    data, err := bech32.ConvertBits(tree.Root(), 8, 5, true)
+    if err != nil {
+        return "", err
+    }
+    return bech32.Encode(hrp, data)
+}
+
+// ParseRootBech32 decodes a root encoded by RootBech32, whatever its
+// human-readable part.
+func ParseRootBech32(s string) ([]byte, error) {
+    _, data, err := bech32.Decode(s)
+    if err != nil {
+        return nil, err
+    }
+    root, err := bech32.ConvertBits(data, 5, 8, false)
+    if err != nil {
+        return nil, err
+    }
+    if len(root) != fpSize {
+        return nil, fmt.Errorf("bech32 root is %d bytes, want %d", len(root), fpSize)
+    }
+    return root, nil
+}
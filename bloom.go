@@ -0,0 +1,72 @@
+package main
+
+import (
+    "encoding/binary"
+    "hash/fnv"
+    "math"
+)
+
+// BloomFilter is a fixed-size Bloom filter over byte strings. MayContain
+// never returns false for an added key, and returns true for a key that was
+// not added with roughly the false-positive rate the filter was sized for.
+type BloomFilter struct {
+    bits   []uint64
+    hashes int
+}
+
+// NewBloomFilter returns a filter sized to hold n keys with false-positive
+// rate p.
+func NewBloomFilter(n int, p float64) *BloomFilter {
+    if n < 1 {
+        n = 1
+    }
+    if p <= 0 || p >= 1 {
+        p = defaultBloomFalsePositiveRate
+    }
+
+    // m = -n ln p / (ln 2)^2 bits and k = (m/n) ln 2 hash functions
+    // minimise the false-positive rate for n keys.
+    m := math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2))
+    k := int(math.Round(m / float64(n) * math.Ln2))
+    if k < 1 {
+        k = 1
+    }
+    return &BloomFilter{
+        bits:   make([]uint64, (int(m)+63)/64),
+        hashes: k,
+    }
+}
+
+// positions derives the filter's bit positions for key from two 64-bit
+// halves of its FNV-1a hash, as h1 + i*h2 (Kirsch-Mitzenmacher).
+func (f *BloomFilter) positions(key []byte, fn func(pos uint64) bool) bool {
+    h := fnv.New128a()
+    h.Write(key)
+    sum := h.Sum(nil)
+    h1 := binary.BigEndian.Uint64(sum[:8])
+    h2 := binary.BigEndian.Uint64(sum[8:]) | 1
+
+    size := uint64(len(f.bits)) * 64
+    for i := 0; i < f.hashes; i++ {
+        if !fn((h1 + uint64(i)*h2) % size) {
+            return false
+        }
+    }
+    return true
+}
+
+// Add inserts key into the filter.
+func (f *BloomFilter) Add(key []byte) {
+    f.positions(key, func(pos uint64) bool {
+        f.bits[pos/64] |= 1 << (pos % 64)
+        return true
+    })
+}
+
+// MayContain reports whether key may have been added. False means it
+// definitely was not.
+func (f *BloomFilter) MayContain(key []byte) bool {
+    return f.positions(key, func(pos uint64) bool {
+        return f.bits[pos/64]&(1<<(pos%64)) != 0
+    })
+}
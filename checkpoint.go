@@ -0,0 +1,260 @@
+package main
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "fmt"
+
+    "go.vocdoni.io/dvote/db"
+)
+
+// keyCheckpoint holds the blob written by Checkpoint.
+var keyCheckpoint = []byte("tree/checkpoint")
+
+// Checkpoint blob layout, all integers uvarint:
+//
+//     slots
+//     per slot: len(key)+1 | key | len(value) | value   (0 for a deleted slot)
+//     root (fpSize bytes)
+//
+// The key index is implied by the slot each key is written in.
+
+// Checkpoint serializes the full tree state, stores it in the database under
+// a single key and returns it.
+func (tree *MerkleTree) Checkpoint() ([]byte, error) {
+    blob, err := tree.checkpointBlob()
+    if err != nil {
+        return nil, err
+    }
+
+    err = tree.writeTx(func(txn db.WriteTx) error {
+        return txn.Set(keyCheckpoint, blob)
+    })
+    if err != nil {
+        return nil, err
+    }
+    return blob, nil
+}
+
+// checkpointBlob serializes the full tree state in the checkpoint layout.
+func (tree *MerkleTree) checkpointBlob() ([]byte, error) {
+    keys := make([][]byte, tree.currentIdx)
+    err := tree.eachIndex(func(key []byte, idx int) bool {
+        keys[idx] = key
+        return true
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    blob := binary.AppendUvarint(nil, uint64(tree.currentIdx))
+    for i, key := range keys {
+        if key == nil {
+            blob = binary.AppendUvarint(blob, 0)
+            continue
+        }
+        blob = binary.AppendUvarint(blob, uint64(len(key))+1)
+        blob = append(blob, key...)
+        blob = binary.AppendUvarint(blob, uint64(len(tree.values[i])))
+        blob = append(blob, tree.values[i]...)
+    }
+    return append(blob, tree.root()...), nil
+}
+
+// RestoreCheckpoint resets the tree to the state saved by the last
+// Checkpoint. The blob is decoded and its root checked before anything is
+// changed; the leaf records in the database, with the leaf index, the
+// cached proofs and the metadata of keys the checkpoint does not hold, are
+// then rewritten in one transaction and the C tree rebuilt. Unlike replaying the database, this
+// only ever lands on a state that was known to be good.
+func (tree *MerkleTree) RestoreCheckpoint() error {
+    if err := tree.checkMutable(); err != nil {
+        return err
+    }
+    if tree.opts.NoPersistence {
+        return errors.New("tree has no database to restore from")
+    }
+
+    rtx := tree.db.ReadTx()
+    blob, err := rtx.Get(keyCheckpoint)
+    rtx.Discard()
+    if err != nil {
+        return fmt.Errorf("read checkpoint: %w", err)
+    }
+
+    keys, values, root, err := decodeCheckpoint(blob, false)
+    if err != nil {
+        return err
+    }
+
+    levels, err := tree.levelsOf(values)
+    if err != nil {
+        return err
+    }
+    computed := make([]byte, fpSize)
+    if len(levels) > 0 {
+        computed = levels[len(levels)-1][0]
+    }
+    if !bytes.Equal(computed, root) {
+        return errors.New("checkpoint root does not match its leaves")
+    }
+
+    restored := make(map[string]bool, len(keys))
+    for _, key := range keys {
+        if key != nil {
+            restored[string(key)] = true
+        }
+    }
+
+    err = tree.writeTx(func(txn db.WriteTx) error {
+        stale, err := txKeys(txn, prefixIndex)
+        if err != nil {
+            return err
+        }
+        for _, k := range stale {
+            if err := txn.Delete(dbKey(prefixIndex, k)); err != nil {
+                return err
+            }
+            if err := txn.Delete(dbKey(prefixValue, k)); err != nil {
+                return err
+            }
+            // Metadata is not part of the checkpoint; it is kept for the
+            // keys the checkpoint still holds.
+            if !restored[string(k)] {
+                if err := txn.Delete(dbKey(prefixMeta, k)); err != nil {
+                    return err
+                }
+            }
+        }
+
+        // The leaf index and the cached proofs describe the leaves being
+        // replaced, so both are dropped and the index rebuilt.
+        for _, prefix := range [][]byte{prefixLeafKey, prefixProofCache} {
+            ks, err := txKeys(txn, prefix)
+            if err != nil {
+                return err
+            }
+            for _, k := range ks {
+                if err := txn.Delete(dbKey(prefix, k)); err != nil {
+                    return err
+                }
+            }
+        }
+
+        for i, key := range keys {
+            if key == nil {
+                continue
+            }
+            if err := putLeafRecord(txn, key, values[i], i); err != nil {
+                return err
+            }
+            if err := putLeafKey(txn, levels[0][i], key); err != nil {
+                return err
+            }
+        }
+        if tree.opts.PersistNodes {
+            if err := putLevelNodes(txn, levels, 0, len(values)); err != nil {
+                return err
+            }
+        }
+        if err := txn.Set(keyRoot, root); err != nil {
+            return err
+        }
+        return txn.Set(keySize, encodeUint64(uint64(len(values))))
+    })
+    if err != nil {
+        return err
+    }
+
+    tree.keyIndex = make(map[string]int, len(keys))
+    for i, key := range keys {
+        if key != nil {
+            tree.setIndex(key, i)
+        }
+    }
+    tree.values = values
+    tree.currentIdx = len(values)
+
+    if err := tree.rebuild(); err != nil {
+        return err
+    }
+    tree.publishHead()
+    return nil
+}
+
+// txKeys returns the keys, without prefix, of every record under prefix.
+func txKeys(txn db.WriteTx, prefix []byte) ([][]byte, error) {
+    var keys [][]byte
+    err := txn.Iterate(prefix, func(k, _ []byte) bool {
+        keys = append(keys, append([]byte(nil), k...))
+        return true
+    })
+    return keys, err
+}
+
+// decodeCheckpoint splits a checkpoint blob into its slots and root. With
+// alias set the returned slices point into blob instead of copies, which
+// OpenSnapshotReadOnly uses to serve a mapped file without reading it all.
+func decodeCheckpoint(blob []byte, alias bool) (keys, values [][]byte, root []byte, err error) {
+    errMalformed := errors.New("malformed checkpoint")
+
+    readBytes := func(n uint64) ([]byte, bool) {
+        if uint64(len(blob)) < n {
+            return nil, false
+        }
+        out := blob[:n:n]
+        if !alias {
+            out = append(make([]byte, 0, n), out...)
+        }
+        blob = blob[n:]
+        return out, true
+    }
+    readUvarint := func() (uint64, bool) {
+        v, n := binary.Uvarint(blob)
+        if n <= 0 {
+            return 0, false
+        }
+        blob = blob[n:]
+        return v, true
+    }
+
+    // Every slot takes at least one byte, which bounds the allocations
+    // below by the size of the blob rather than by what it claims.
+    slots, ok := readUvarint()
+    if !ok || slots > uint64(len(blob)) {
+        return nil, nil, nil, errMalformed
+    }
+    keys = make([][]byte, 0, slots)
+    values = make([][]byte, 0, slots)
+    for i := uint64(0); i < slots; i++ {
+        keyLen, ok := readUvarint()
+        if !ok {
+            return nil, nil, nil, errMalformed
+        }
+        if keyLen == 0 {
+            keys = append(keys, nil)
+            values = append(values, nil)
+            continue
+        }
+        key, ok := readBytes(keyLen - 1)
+        if !ok {
+            return nil, nil, nil, errMalformed
+        }
+        valueLen, ok := readUvarint()
+        if !ok {
+            return nil, nil, nil, errMalformed
+        }
+        value, ok := readBytes(valueLen)
+        if !ok {
+            return nil, nil, nil, errMalformed
+        }
+        keys = append(keys, key)
+        values = append(values, value)
+    }
+
+    if len(blob) != fpSize {
+        return nil, nil, nil, errMalformed
+    }
+    return keys, values, blob, nil
+}
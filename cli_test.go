@@ -0,0 +1,66 @@
+package main
+
+import (
+    "bytes"
+    "encoding/hex"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestCLIVerify(t *testing.T) {
+    tree := newTestTree(t)
+    keys, values := testLeaves(5)
+    addAll(t, tree, keys, values)
+    root := hex.EncodeToString(tree.Root())
+
+    writeProof := func(name string, proof *MerkleProof) string {
+        data, err := proof.MarshalBinary()
+        if err != nil {
+            t.Fatal(err)
+        }
+        path := filepath.Join(t.TempDir(), name)
+        if err := os.WriteFile(path, data, 0o600); err != nil {
+            t.Fatal(err)
+        }
+        return path
+    }
+
+    proof, err := tree.GenProof(keys[2])
+    if err != nil {
+        t.Fatal(err)
+    }
+    valid := writeProof("valid", proof)
+
+    // The proof of key-2 carrying the leaf of key-3.
+    other, err := tree.LeafHash(keys[3])
+    if err != nil {
+        t.Fatal(err)
+    }
+    proof.Leaf = other
+    tampered := writeProof("tampered", proof)
+
+    for _, c := range []struct {
+        name   string
+        args   []string
+        status int
+        output string
+    }{
+        {"Valid", []string{"--root", root, "--key", "key-2", "--proof", valid}, 0, "verifies"},
+        {"Tampered", []string{"--root", root, "--key", "key-2", "--proof", tampered}, 1, "does NOT verify"},
+        {"MissingRoot", []string{"--key", "key-2", "--proof", valid}, 2, "required"},
+        {"MissingFile", []string{"--root", root, "--proof", filepath.Join(t.TempDir(), "none")}, 1, "verify:"},
+    } {
+        t.Run(c.name, func(t *testing.T) {
+            var stdout, stderr bytes.Buffer
+            status := runCLI(append([]string{"verify"}, c.args...), &stdout, &stderr)
+            if status != c.status {
+                t.Fatalf("exit status %d, want %d; stderr: %s", status, c.status, stderr.String())
+            }
+            if out := stdout.String() + stderr.String(); !strings.Contains(out, c.output) {
+                t.Fatalf("output %q does not contain %q", out, c.output)
+            }
+        })
+    }
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+    "bytes"
+    "errors"
+
+    "go.vocdoni.io/dvote/db"
+)
+
+// Two-phase insertion. AddCommitment inserts a leaf for a commitment
+// c = Poseidon(preimage) without revealing the preimage, and
+// RevealCommitment later swaps it for the revealed leaf:
+//
+//     committed: leaf = Poseidon(c)
+//     revealed:  leaf = Poseidon(preimage) = c
+//
+// The revealed leaf is what Add(key, preimage) gives with
+// StoreHashedValues. Pending commitments are kept under
+// commit/<key> -> c until revealed.
+var prefixCommitment = []byte("commit/")
+
+// ErrCommitmentMismatch is returned by RevealCommitment when the preimage
+// does not hash to the committed value.
+var ErrCommitmentMismatch = errors.New("preimage does not match commitment")
+
+var errCommitmentsNeedPersistence = errors.New("commitments are kept in the database; not available with NoPersistence")
+
+// AddCommitment adds key with a provisional leaf binding commitment, a
+// field element in its in-memory form. The commitment is recorded in the
+// same transaction as the leaf.
+func (tree *MerkleTree) AddCommitment(key, commitment []byte) (err error) {
+    defer tree.stats.record(&tree.stats.adds, &err)
+
+    if tree.opts.NoPersistence {
+        return errCommitmentsNeedPersistence
+    }
+    if len(commitment) != fpSize {
+        return errors.New("commitment must be a field element")
+    }
+    h, err := HashOne(commitment)
+    if err != nil {
+        return err
+    }
+
+    var leafHash [32]byte
+    copy(leafHash[:], tree.toAPI(h))
+    return tree.addRawLeaf(key, leafHash, func(txn db.WriteTx) error {
+        return txn.Set(dbKey(prefixCommitment, key), commitment)
+    })
+}
+
+// RevealCommitment checks that Poseidon(preimage) is the commitment added
+// for key and, if so, replaces the provisional leaf with it.
+func (tree *MerkleTree) RevealCommitment(key, preimage []byte) error {
+    idx, exists, err := tree.indexOf(key)
+    if err != nil {
+        return err
+    }
+    if !exists {
+        return ErrKeyNotFound
+    }
+    commitment, err := tree.pendingCommitment(key)
+    if err != nil {
+        return err
+    }
+
+    h, err := HashOne(preimage)
+    if err != nil {
+        return err
+    }
+    if !bytes.Equal(h, commitment) {
+        return ErrCommitmentMismatch
+    }
+
+    stored := tree.rawStored(tree.toAPI(h))
+    leaf, err := bytesToFp(h)
+    if err != nil {
+        return err
+    }
+    return tree.replaceLeafWith(idx, key, leaf, stored, func(txn db.WriteTx) error {
+        if err := tree.putPreimage(txn, h, preimage); err != nil {
+            return err
+        }
+        return txn.Delete(dbKey(prefixCommitment, key))
+    })
+}
+
+func (tree *MerkleTree) pendingCommitment(key []byte) ([]byte, error) {
+    if tree.opts.NoPersistence {
+        return nil, errCommitmentsNeedPersistence
+    }
+
+    rtx := tree.db.ReadTx()
+    defer rtx.Discard()
+    commitment, err := rtx.Get(dbKey(prefixCommitment, key))
+    if errors.Is(err, db.ErrKeyNotFound) {
+        return nil, errors.New("no pending commitment for key")
+    }
+    if err != nil {
+        return nil, err
+    }
+    return append([]byte(nil), commitment...), nil
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+)
+
+// ConsistencyProof proves that the tree of OldSize leaves is a prefix of
+// the tree of NewSize leaves, as in RFC 6962 section 2.1.2. Pairing leaves
+// level by level and carrying odd last nodes up unhashed, as lib.rs does,
+// gives exactly RFC 6962's tree shape, where the left subtree of n leaves is
+// the largest power of two below n, so the RFC algorithms apply unchanged
+// with Poseidon as the node hash and leaves taken as is.
+type ConsistencyProof struct {
+    OldSize, NewSize uint
+    Nodes            [][]byte
+    SortedChildren   bool
+}
+
+// GenConsistencyProof returns a proof that the tree's first oldSize leaves
+// are a prefix of its first newSize leaves. It reads the current leaves, so
+// it proves the history of an append-only tree: if any of the first
+// newSize leaves was updated or deleted since the roots being compared were
+// taken, the proof will not verify against them.
+func (tree *MerkleTree) GenConsistencyProof(oldSize, newSize uint) (*ConsistencyProof, error) {
+    if oldSize == 0 || oldSize > newSize || newSize > uint(len(tree.values)) {
+        return nil, ErrIndexOutOfRange
+    }
+
+    leaves, err := tree.leafPrefix(newSize)
+    if err != nil {
+        return nil, err
+    }
+
+    sorted := tree.opts.SortedChildren
+    nodes, err := consistencySubproof(oldSize, leaves, true, sorted)
+    if err != nil {
+        return nil, err
+    }
+    return &ConsistencyProof{
+        OldSize:        oldSize,
+        NewSize:        newSize,
+        Nodes:          nodes,
+        SortedChildren: sorted,
+    }, nil
+}
+
+// leafPrefix returns the first n current leaves in their in-memory form.
+func (tree *MerkleTree) leafPrefix(n uint) ([][]byte, error) {
+    leaves := make([][]byte, n)
+    for i := range leaves {
+        leaf, err := tree.storedLeafBytes(tree.values[i])
+        if err != nil {
+            return nil, err
+        }
+        leaves[i] = leaf
+    }
+    return leaves, nil
+}
+
+// consistencySubproof is SUBPROOF(m, D[n], b) of RFC 6962.
+func consistencySubproof(m uint, leaves [][]byte, complete, sorted bool) ([][]byte, error) {
+    n := uint(len(leaves))
+    if m == n {
+        if complete {
+            return nil, nil
+        }
+        h, err := subtreeHash(leaves, sorted)
+        if err != nil {
+            return nil, err
+        }
+        return [][]byte{h}, nil
+    }
+
+    k := largestPowerOfTwoBelow(n)
+    if m <= k {
+        nodes, err := consistencySubproof(m, leaves[:k], complete, sorted)
+        if err != nil {
+            return nil, err
+        }
+        h, err := subtreeHash(leaves[k:], sorted)
+        if err != nil {
+            return nil, err
+        }
+        return append(nodes, h), nil
+    }
+
+    nodes, err := consistencySubproof(m-k, leaves[k:], false, sorted)
+    if err != nil {
+        return nil, err
+    }
+    h, err := subtreeHash(leaves[:k], sorted)
+    if err != nil {
+        return nil, err
+    }
+    return append(nodes, h), nil
+}
+
+// subtreeHash is MTH(D[n]) of RFC 6962 over leaves.
+func subtreeHash(leaves [][]byte, sorted bool) ([]byte, error) {
+    if len(leaves) == 1 {
+        return leaves[0], nil
+    }
+    k := largestPowerOfTwoBelow(uint(len(leaves)))
+    left, err := subtreeHash(leaves[:k], sorted)
+    if err != nil {
+        return nil, err
+    }
+    right, err := subtreeHash(leaves[k:], sorted)
+    if err != nil {
+        return nil, err
+    }
+    return hashChildren(left, right, sorted)
+}
+
+// largestPowerOfTwoBelow returns the largest power of two strictly less
+// than n, for n > 1.
+func largestPowerOfTwoBelow(n uint) uint {
+    k := uint(1)
+    for k<<1 < n {
+        k <<= 1
+    }
+    return k
+}
+
+// VerifyConsistency reports whether p proves that oldRoot, the root of the
+// tree at p.OldSize leaves, and newRoot, its root at p.NewSize leaves,
+// belong to one append-only history. It follows RFC 9162 section 2.1.4.2.
+// Roots are in their in-memory form, as in proofs.
+func VerifyConsistency(oldRoot, newRoot []byte, p *ConsistencyProof) bool {
+    if p.OldSize == 0 || p.OldSize > p.NewSize {
+        return false
+    }
+    if p.OldSize == p.NewSize {
+        return len(p.Nodes) == 0 && fpEqual(oldRoot, newRoot)
+    }
+
+    path := p.Nodes
+    if p.OldSize&(p.OldSize-1) == 0 {
+        path = append([][]byte{oldRoot}, path...)
+    }
+    if len(path) == 0 {
+        return false
+    }
+
+    fn, sn := p.OldSize-1, p.NewSize-1
+    for fn&1 == 1 {
+        fn, sn = fn>>1, sn>>1
+    }
+
+    fr, sr := path[0], path[0]
+    var err error
+    for _, c := range path[1:] {
+        if sn == 0 {
+            return false
+        }
+        if fn&1 == 1 || fn == sn {
+            if fr, err = hashChildren(c, fr, p.SortedChildren); err != nil {
+                return false
+            }
+            if sr, err = hashChildren(c, sr, p.SortedChildren); err != nil {
+                return false
+            }
+            for fn&1 == 0 && fn != 0 {
+                fn, sn = fn>>1, sn>>1
+            }
+        } else {
+            if sr, err = hashChildren(sr, c, p.SortedChildren); err != nil {
+                return false
+            }
+        }
+        fn, sn = fn>>1, sn>>1
+    }
+
+    return sn == 0 && fpEqual(fr, oldRoot) && fpEqual(sr, newRoot)
+}
+
+// RevisionConsistencyProof is a ConsistencyProof between two versions of
+// the tree, carrying the roots it links so it can be checked on its own.
+type RevisionConsistencyProof struct {
+    FromRev, ToRev   int64
+    FromRoot, ToRoot []byte
+    ConsistencyProof
+}
+
+// GenRevisionConsistencyProof returns a proof that the tree at version
+// toRev was derived from the tree at version fromRev by appending leaves;
+// versions are those of RootAt, and both must still be retained. It is
+// GenConsistencyProof addressed by version rather than by size. If any
+// leaf present at fromRev was updated or deleted in between, no such proof
+// exists and an error is returned.
+//
+// Only roots and sizes are kept for past versions, not their leaves, so the
+// proof is built from the current leaves. It can therefore only be
+// generated while the first leaves of toRev, as many as it had, are
+// unchanged: once one of them is updated or deleted, even after toRev, it
+// fails with ErrRootMismatch. Auditors wanting proofs for a version should
+// request them before the tree changes past it.
+func (tree *MerkleTree) GenRevisionConsistencyProof(fromRev, toRev int64) (RevisionConsistencyProof, error) {
+    if fromRev < 0 || fromRev > toRev {
+        return RevisionConsistencyProof{}, fmt.Errorf("versions %d to %d: %w", fromRev, toRev, ErrIndexOutOfRange)
+    }
+    fromRoot, fromSize, err := tree.rootAndSizeAt(uint64(fromRev))
+    if err != nil {
+        return RevisionConsistencyProof{}, err
+    }
+    toRoot, toSize, err := tree.rootAndSizeAt(uint64(toRev))
+    if err != nil {
+        return RevisionConsistencyProof{}, err
+    }
+    if fromSize == 0 {
+        return RevisionConsistencyProof{}, fmt.Errorf("version %d is empty, which every version extends: %w", fromRev, ErrEmptyTree)
+    }
+
+    if toSize > uint(len(tree.values)) {
+        return RevisionConsistencyProof{}, fmt.Errorf("version %d has %d leaves, the tree %d: %w", toRev, toSize, len(tree.values), ErrIndexOutOfRange)
+    }
+    leaves, err := tree.leafPrefix(toSize)
+    if err != nil {
+        return RevisionConsistencyProof{}, err
+    }
+    current, err := subtreeHash(leaves, tree.opts.SortedChildren)
+    if err != nil {
+        return RevisionConsistencyProof{}, err
+    }
+    if !fpEqual(current, toRoot) {
+        return RevisionConsistencyProof{}, fmt.Errorf("leaves of version %d changed since: %w", toRev, ErrRootMismatch)
+    }
+
+    proof, err := tree.GenConsistencyProof(fromSize, toSize)
+    if err != nil {
+        return RevisionConsistencyProof{}, err
+    }
+    out := RevisionConsistencyProof{
+        FromRev:          fromRev,
+        ToRev:            toRev,
+        FromRoot:         fromRoot,
+        ToRoot:           toRoot,
+        ConsistencyProof: *proof,
+    }
+    // The current leaves give toRoot, so a proof that fails against
+    // fromRoot means a leaf of fromRev changed before toRev.
+    if !VerifyConsistency(fromRoot, toRoot, proof) {
+        return RevisionConsistencyProof{}, fmt.Errorf("versions %d to %d are not append-only", fromRev, toRev)
+    }
+    return out, nil
+}
+
+// VerifyConsistencyProof reports whether proof links its FromRoot to its
+// ToRoot. It checks the proof only; the caller still has to trust both
+// roots, for example by comparing them with roots published earlier.
+func VerifyConsistencyProof(proof RevisionConsistencyProof) (bool, error) {
+    if proof.FromRev < 0 || proof.FromRev > proof.ToRev {
+        return false, errors.New("consistency proof versions out of order")
+    }
+    if len(proof.FromRoot) != fpSize || len(proof.ToRoot) != fpSize {
+        return false, fmt.Errorf("consistency proof roots must be %d bytes", fpSize)
+    }
+    return VerifyConsistency(proof.FromRoot, proof.ToRoot, &proof.ConsistencyProof), nil
+}
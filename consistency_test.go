@@ -0,0 +1,113 @@
+package main
+
+import (
+    "bytes"
+    "errors"
+    "testing"
+)
+
+func TestConsistencyProof5To8(t *testing.T) {
+    tree := newTestTree(t)
+    keys, values := testLeaves(8)
+
+    addAll(t, tree, keys[:5], values[:5])
+    root5 := tree.root()
+    addAll(t, tree, keys[5:], values[5:])
+    root8 := tree.root()
+
+    p, err := tree.GenConsistencyProof(5, 8)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !VerifyConsistency(root5, root8, p) {
+        t.Fatal("consistency proof from 5 to 8 leaves does not verify")
+    }
+
+    if VerifyConsistency(root8, root8, p) {
+        t.Error("proof verifies with the new root given as the old one")
+    }
+    for i := range p.Nodes {
+        tampered := *p
+        tampered.Nodes = append([][]byte(nil), p.Nodes...)
+        tampered.Nodes[i] = bytes.Repeat([]byte{0x11}, fpSize)
+        if VerifyConsistency(root5, root8, &tampered) {
+            t.Errorf("proof verifies with node %d replaced", i)
+        }
+    }
+}
+
+func TestConsistencyProofAllSizes(t *testing.T) {
+    const n = 9
+    tree := newTestTree(t)
+    keys, values := testLeaves(n)
+
+    roots := make([][]byte, n+1)
+    for i := range keys {
+        addAll(t, tree, keys[i:i+1], values[i:i+1])
+        roots[i+1] = tree.root()
+    }
+
+    for m := uint(1); m <= n; m++ {
+        for k := m; k <= n; k++ {
+            p, err := tree.GenConsistencyProof(m, k)
+            if err != nil {
+                t.Fatalf("%d to %d: %v", m, k, err)
+            }
+            if !VerifyConsistency(roots[m], roots[k], p) {
+                t.Errorf("proof from %d to %d leaves does not verify", m, k)
+            }
+        }
+    }
+}
+
+func TestConsistencyProofRejectsUpdatedHistory(t *testing.T) {
+    tree := newTestTree(t)
+    keys, values := testLeaves(8)
+
+    addAll(t, tree, keys[:5], values[:5])
+    root5 := tree.root()
+    addAll(t, tree, keys[5:], values[5:])
+    if err := tree.Update(keys[2], encodeUint64(99)); err != nil {
+        t.Fatal(err)
+    }
+
+    p, err := tree.GenConsistencyProof(5, 8)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if VerifyConsistency(root5, tree.root(), p) {
+        t.Error("proof verifies although a leaf of the old tree was updated")
+    }
+}
+
+func TestRevisionConsistencyProof(t *testing.T) {
+    tree := newTestTree(t)
+    keys, values := testLeaves(8)
+
+    addAll(t, tree, keys[:3], values[:3])
+    from := int64(tree.Version())
+    addAll(t, tree, keys[3:6], values[3:6])
+    to := int64(tree.Version())
+
+    proof, err := tree.GenRevisionConsistencyProof(from, to)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if ok, err := VerifyConsistencyProof(proof); err != nil || !ok {
+        t.Fatalf("VerifyConsistencyProof = %v, %v", ok, err)
+    }
+
+    // Appending past toRev leaves its leaves alone.
+    addAll(t, tree, keys[6:], values[6:])
+    if _, err := tree.GenRevisionConsistencyProof(from, to); err != nil {
+        t.Fatalf("after appending: %v", err)
+    }
+
+    // Changing one of them does not, and the proof can no longer be built.
+    if err := tree.Update(keys[4], encodeUint64(99)); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := tree.GenRevisionConsistencyProof(from, to); !errors.Is(err, ErrRootMismatch) {
+        t.Fatalf("after updating a leaf of toRev: %v, want ErrRootMismatch", err)
+    }
+}
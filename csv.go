@@ -0,0 +1,96 @@
+package main
+
+import (
+    "encoding/csv"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "io"
+
+    "go.vocdoni.io/dvote/db"
+)
+
+var csvHeader = []string{"key", "value"}
+
+// EncodeTreeAsCSV writes one hex(key),hex(value) row per leaf in index
+// order, after a key,value header. Values are written as stored, so a tree
+// built with StoreHashedValues exports hashes.
+func EncodeTreeAsCSV(tree *MerkleTree, w io.Writer) error {
+    keys, err := tree.Keys()
+    if err != nil {
+        return err
+    }
+
+    cw := csv.NewWriter(w)
+    if err := cw.Write(csvHeader); err != nil {
+        return err
+    }
+    for _, key := range keys {
+        value, err := tree.Get(key)
+        if err != nil {
+            return err
+        }
+        if err := cw.Write([]string{hex.EncodeToString(key), hex.EncodeToString(value)}); err != nil {
+            return err
+        }
+    }
+    cw.Flush()
+    return cw.Error()
+}
+
+// DecodeTreeFromCSV builds a tree in database from rows written by
+// EncodeTreeAsCSV or by another system using the same layout. Keys longer
+// than a field element are replaced by HashBytes(key), as systems that key
+// leaves by field element do.
+func DecodeTreeFromCSV(database db.Database, r io.Reader, opts ...Option) (*MerkleTree, error) {
+    cr := csv.NewReader(r)
+    cr.FieldsPerRecord = len(csvHeader)
+
+    header, err := cr.Read()
+    if err == io.EOF {
+        return nil, errors.New("csv has no header")
+    }
+    if err != nil {
+        return nil, err
+    }
+    if header[0] != csvHeader[0] || header[1] != csvHeader[1] {
+        return nil, fmt.Errorf("unexpected csv header %q", header)
+    }
+
+    var keys, values [][]byte
+    for line := 2; ; line++ {
+        row, err := cr.Read()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return nil, err
+        }
+
+        key, err := hex.DecodeString(row[0])
+        if err != nil {
+            return nil, fmt.Errorf("line %d: key: %w", line, err)
+        }
+        value, err := hex.DecodeString(row[1])
+        if err != nil {
+            return nil, fmt.Errorf("line %d: value: %w", line, err)
+        }
+        if len(key) > fpSize {
+            if key, err = HashBytes(key); err != nil {
+                return nil, fmt.Errorf("line %d: %w", line, err)
+            }
+        }
+
+        keys = append(keys, key)
+        values = append(values, value)
+    }
+
+    tree := NewMerkleTree(database, opts...)
+    if len(keys) == 0 {
+        return tree, nil
+    }
+    if err := tree.AddBatch(keys, values); err != nil {
+        return nil, err
+    }
+    return tree, nil
+}
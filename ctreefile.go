@@ -0,0 +1,79 @@
+package main
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "os"
+)
+
+// C tree file layout:
+//
+//     "PTCT" | version (1 byte) | leaf count (uint64 little-endian) |
+//     leaves (fpSize bytes each, in their in-memory form) | root
+var cTreeMagic = []byte("PTCT")
+
+const cTreeFileVersion = 1
+
+// SaveCTree writes the leaves of tree, in index order, and its root to
+// path. Deleted slots are written as the empty leaf.
+func SaveCTree(tree *MerkleTree, path string) error {
+    out := append([]byte(nil), cTreeMagic...)
+    out = append(out, cTreeFileVersion)
+    out = binary.LittleEndian.AppendUint64(out, uint64(len(tree.values)))
+    for _, stored := range tree.values {
+        leaf, err := tree.storedLeafBytes(stored)
+        if err != nil {
+            return err
+        }
+        out = append(out, leaf...)
+    }
+    out = append(out, tree.root()...)
+    return os.WriteFile(path, out, 0o644)
+}
+
+// LoadCTree rebuilds the C tree from a file written by SaveCTree, with a
+// single tree_build call instead of replaying a database, and
+// checks the result against the saved root. The file holds leaves but no
+// keys, so the tree it returns is read-only and in memory: it serves the
+// root and proofs by position, such as ProofAt, GenRangeProof and
+// SubtreeRoot, but not lookups by key.
+func LoadCTree(path string) (*MerkleTree, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+
+    header := len(cTreeMagic) + 1 + 8
+    if len(data) < header+fpSize || !bytes.Equal(data[:len(cTreeMagic)], cTreeMagic) {
+        return nil, errors.New("not a C tree file")
+    }
+    if v := data[len(cTreeMagic)]; v != cTreeFileVersion {
+        return nil, fmt.Errorf("unsupported C tree file version %d", v)
+    }
+    count := binary.LittleEndian.Uint64(data[len(cTreeMagic)+1:])
+    body := data[header:]
+    if uint64(len(body)-fpSize)/fpSize != count || (len(body)-fpSize)%fpSize != 0 {
+        return nil, errors.New("C tree file is truncated or has trailing data")
+    }
+
+    // The leaves are kept as hashed values are, so each stored value is its
+    // own leaf.
+    tree := NewMerkleTree(nil, WithNoPersistence(), WithStoreHashedValues())
+    tree.values = make([][]byte, count)
+    for i := range tree.values {
+        tree.values[i] = body[i*fpSize : (i+1)*fpSize : (i+1)*fpSize]
+    }
+    tree.currentIdx = int(count)
+    tree.readOnly = true
+
+    if err := tree.rebuild(); err != nil {
+        return nil, err
+    }
+    if !bytes.Equal(tree.root(), body[len(body)-fpSize:]) {
+        return nil, errors.New("C tree file root does not match its leaves")
+    }
+    tree.publishHead()
+    return tree, nil
+}
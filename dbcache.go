@@ -0,0 +1,58 @@
+package main
+
+import (
+    "bytes"
+    "encoding/binary"
+    "time"
+
+    "go.vocdoni.io/dvote/db"
+)
+
+// prefixProofCache holds proofs cached by WithProofCache:
+// cache:<key> -> expiry (unix nanoseconds, uint64 big-endian) | root | proof
+// as written by MerkleProof.MarshalBinary.
+var prefixProofCache = []byte("cache:")
+
+// cachedProof returns the cached proof of key if it has not expired and was
+// generated under the current root. The cache is best effort: any failure
+// to read it counts as a miss.
+func (tree *MerkleTree) cachedProof(key []byte) (*MerkleProof, bool) {
+    if tree.opts.NoPersistence {
+        return nil, false
+    }
+
+    rtx := tree.db.ReadTx()
+    defer rtx.Discard()
+    entry, err := rtx.Get(dbKey(prefixProofCache, key))
+    if err != nil || len(entry) < 8+fpSize {
+        return nil, false
+    }
+
+    expiry := int64(binary.BigEndian.Uint64(entry))
+    if time.Now().UnixNano() >= expiry || !bytes.Equal(entry[8:8+fpSize], tree.root()) {
+        return nil, false
+    }
+
+    var proof MerkleProof
+    if err := proof.UnmarshalBinary(entry[8+fpSize:]); err != nil {
+        return nil, false
+    }
+    return &proof, true
+}
+
+// cacheProof stores proof for key. Like cachedProof it is best effort, and
+// a failed write only means the next request recomputes the proof.
+func (tree *MerkleTree) cacheProof(key []byte, proof *MerkleProof) {
+    encoded, err := proof.MarshalBinary()
+    if err != nil {
+        return
+    }
+
+    entry := make([]byte, 8, 8+fpSize+len(encoded))
+    binary.BigEndian.PutUint64(entry, uint64(time.Now().Add(tree.opts.ProofCacheTTL).UnixNano()))
+    entry = append(entry, tree.root()...)
+    entry = append(entry, encoded...)
+    tree.writeTx(func(txn db.WriteTx) error {
+        return txn.Set(dbKey(prefixProofCache, key), entry)
+    })
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+    "bufio"
+    "encoding/hex"
+    "fmt"
+    "io"
+    "strings"
+)
+
+// DumpDOT writes the current tree as a Graphviz DOT graph, root at the top.
+// Each node is labelled with the first four bytes of its hash in hex, and
+// each leaf also with its path from the root, L and R for left and right.
+func (tree *MerkleTree) DumpDOT(w io.Writer) error {
+    levels, err := tree.levels()
+    if err != nil {
+        return err
+    }
+
+    bw := bufio.NewWriter(w)
+    fmt.Fprintln(bw, "digraph merkle {")
+    fmt.Fprintln(bw, "    node [shape=box, fontname=monospace];")
+    depth := len(levels) - 1
+    for l, level := range levels {
+        for i, hash := range level {
+            if l == 0 {
+                fmt.Fprintf(bw, "    n%d_%d [label=\"%s\\n%s\"];\n", l, i, hex.EncodeToString(hash[:4]), pathString(uint(i), depth))
+                continue
+            }
+            fmt.Fprintf(bw, "    n%d_%d [label=\"%s\"];\n", l, i, hex.EncodeToString(hash[:4]))
+            fmt.Fprintf(bw, "    n%d_%d -> n%d_%d;\n", l, i, l-1, 2*i)
+            if 2*i+1 < len(levels[l-1]) {
+                fmt.Fprintf(bw, "    n%d_%d -> n%d_%d;\n", l, i, l-1, 2*i+1)
+            }
+        }
+    }
+    fmt.Fprintln(bw, "}")
+
+    return bw.Flush()
+}
+
+// pathString spells the PathBits of a leaf as L and R steps.
+func pathString(index uint, depth int) string {
+    var sb strings.Builder
+    for _, right := range PathBits(index, depth) {
+        if right {
+            sb.WriteByte('R')
+        } else {
+            sb.WriteByte('L')
+        }
+    }
+    return sb.String()
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+    "bytes"
+    "regexp"
+    "testing"
+)
+
+func TestDumpDOTFourLeaves(t *testing.T) {
+    keys, values := testLeaves(4)
+    tree := newTestTree(t)
+    addAll(t, tree, keys, values)
+
+    var buf bytes.Buffer
+    if err := tree.DumpDOT(&buf); err != nil {
+        t.Fatal(err)
+    }
+    out := buf.String()
+
+    nodes := regexp.MustCompile(`(?m)^\s*n\d+_\d+ \[label=`).FindAllString(out, -1)
+    if len(nodes) != 7 {
+        t.Fatalf("graph of 4 leaves has %d nodes, want 7:\n%s", len(nodes), out)
+    }
+    edges := regexp.MustCompile(`(?m)^\s*n\d+_\d+ -> n\d+_\d+;`).FindAllString(out, -1)
+    if len(edges) != 6 {
+        t.Fatalf("graph of 4 leaves has %d edges, want 6:\n%s", len(edges), out)
+    }
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "strconv"
+
+    "go.vocdoni.io/dvote/db"
+    "go.vocdoni.io/dvote/db/badgerdb"
+)
+
+// Environment variables read by NewMerkleTreeFromEnv.
+const (
+    EnvDBPath   = "POSEIDONTREE_DB_PATH"   // required: directory of the badger database
+    EnvPrefix   = "POSEIDONTREE_PREFIX"    // optional: see WithPrefix
+    EnvMaxDepth = "POSEIDONTREE_MAX_DEPTH" // optional: see WithMaxDepth
+    EnvHasher   = "POSEIDONTREE_HASHER"    // optional: "none" (the default) or "poseidon"
+)
+
+// NewMerkleTreeFromEnv opens the badger database at $POSEIDONTREE_DB_PATH
+// and loads the tree in it, configured from the other POSEIDONTREE_*
+// variables. With POSEIDONTREE_HASHER=poseidon values are stored hashed, as
+// WithStoreHashedValues. The tree is loaded rather than created so a
+// restarted process picks up where it left off; an empty database loads as
+// an empty tree.
+func NewMerkleTreeFromEnv() (*MerkleTree, error) {
+    path := os.Getenv(EnvDBPath)
+    if path == "" {
+        return nil, fmt.Errorf("%s is not set; it must name the database directory", EnvDBPath)
+    }
+
+    var opts []Option
+    if prefix := os.Getenv(EnvPrefix); prefix != "" {
+        opts = append(opts, WithPrefix([]byte(prefix)))
+    }
+    if depth := os.Getenv(EnvMaxDepth); depth != "" {
+        n, err := strconv.Atoi(depth)
+        if err != nil || n <= 0 || n > maxDepthLimit {
+            return nil, fmt.Errorf("%s=%q: want a depth between 1 and %d", EnvMaxDepth, depth, maxDepthLimit)
+        }
+        opts = append(opts, WithMaxDepth(n))
+    }
+    switch hasher := os.Getenv(EnvHasher); hasher {
+    case "", "none":
+    case "poseidon":
+        opts = append(opts, WithStoreHashedValues(), WithHasher(PoseidonHasher{}))
+    default:
+        return nil, fmt.Errorf("%s=%q: want \"none\" or \"poseidon\"", EnvHasher, hasher)
+    }
+
+    var dbOpts db.Options
+    dbOpts.Path = path
+    database, err := badgerdb.New(dbOpts)
+    if err != nil {
+        return nil, fmt.Errorf("opening %s=%q: %w", EnvDBPath, path, err)
+    }
+    tree, err := LoadMerkleTree(database, opts...)
+    if err != nil {
+        return nil, errors.Join(err, database.Close())
+    }
+    return tree, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import "errors"
+
+// ErrKeyNotFound is returned by lookups and mutations of a key that is not
+// in the tree.
+var ErrKeyNotFound = errors.New("key does not exist")
+
+// ErrIndexOutOfRange is returned when a leaf index or tree position lies
+// outside the current tree.
+var ErrIndexOutOfRange = errors.New("index out of range")
+
+// ErrKeyExists is returned when adding a key that is already in the tree.
+var ErrKeyExists = errors.New("key already exists")
+
+// ErrTreeFull is returned when adding leaves would take the tree past
+// MaxCapacity.
+var ErrTreeFull = errors.New("tree is full")
+
+// ErrEmptyTree is returned when reading a path from a tree with no leaves.
+var ErrEmptyTree = errors.New("tree is empty")
+
+// ErrPathBufferTooSmall is returned when a merkle path does not fit the
+// buffer it is read into.
+var ErrPathBufferTooSmall = errors.New("merkle path buffer too small")
+
+// ErrProofMismatch is returned with WithVerifyOnGen when a proof read from
+// the C tree does not verify against its root.
+var ErrProofMismatch = errors.New("generated proof does not verify against the root")
+
+// ErrKeyOutsidePrefix is returned by a SubTree for keys that do not start
+// with its prefix.
+var ErrKeyOutsidePrefix = errors.New("key is outside the subtree prefix")
+
+// ErrReadOnly is returned by mutations of a tree opened with
+// OpenSnapshotReadOnly.
+var ErrReadOnly = errors.New("tree is read-only")
+
+// ErrFinalized is returned by mutations of a tree after Finalize.
+var ErrFinalized = errors.New("tree is finalized")
+
+// ErrVersionNotRetained is returned by RootAt for a version whose root has
+// aged out of the retained history, or that does not exist yet.
+var ErrVersionNotRetained = errors.New("version not retained")
+
+// ErrValueTooLarge is returned for a value longer than a field element
+// unless the tree was created WithAutoHashOversize.
+var ErrValueTooLarge = errors.New("value does not fit in a field element")
+
+// ErrNotKeyOrdered is returned by GenExclusionProof for a tree whose leaves
+// were not inserted in key order.
+var ErrNotKeyOrdered = errors.New("tree leaves are not in key order")
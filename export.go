@@ -0,0 +1,102 @@
+package main
+
+import (
+    "bufio"
+    "encoding/binary"
+    "errors"
+    "io"
+)
+
+// exportChunk is the number of proofs ExportAllProofs generates per
+// GenProofBatch call.
+const exportChunk = 1024
+
+// ExportAllProofs writes the proof of every key in the tree to w, in leaf
+// order, so proofs can be precomputed and handed to clients offline. Each
+// record is
+//
+//     uvarint len(key) | key | uvarint len(proof) | proof
+//
+// with the proof encoded by MerkleProof.MarshalBinary. Proofs are against
+// the root at the time of the call. ReadExportedProofs reads the stream
+// back.
+func (tree *MerkleTree) ExportAllProofs(w io.Writer) error {
+    keys, err := tree.Keys()
+    if err != nil {
+        return err
+    }
+
+    bw := bufio.NewWriter(w)
+    for lo := 0; lo < len(keys); lo += exportChunk {
+        hi := lo + exportChunk
+        if hi > len(keys) {
+            hi = len(keys)
+        }
+        proofs, err := tree.GenProofBatch(keys[lo:hi])
+        if err != nil {
+            return err
+        }
+        for i, proof := range proofs {
+            data, err := proof.MarshalBinary()
+            if err != nil {
+                return err
+            }
+            record := binary.AppendUvarint(nil, uint64(len(keys[lo+i])))
+            record = append(record, keys[lo+i]...)
+            record = binary.AppendUvarint(record, uint64(len(data)))
+            record = append(record, data...)
+            if _, err := bw.Write(record); err != nil {
+                return err
+            }
+        }
+    }
+    return bw.Flush()
+}
+
+// maxExportedRecordField bounds the key and proof lengths ReadExportedProofs
+// accepts, so a corrupt stream cannot make it allocate without limit.
+const maxExportedRecordField = 1 << 20
+
+// ReadExportedProofs calls fn with every key and proof in a stream written
+// by ExportAllProofs, stopping at the first error fn returns.
+func ReadExportedProofs(r io.Reader, fn func(key []byte, proof *MerkleProof) error) error {
+    br := bufio.NewReader(r)
+    readField := func() ([]byte, error) {
+        n, err := binary.ReadUvarint(br)
+        if err != nil {
+            return nil, err
+        }
+        if n > maxExportedRecordField {
+            return nil, errors.New("malformed proof export: field too long")
+        }
+        field := make([]byte, n)
+        if _, err := io.ReadFull(br, field); err != nil {
+            return nil, err
+        }
+        return field, nil
+    }
+
+    for {
+        key, err := readField()
+        if err == io.EOF {
+            return nil
+        }
+        if err != nil {
+            return err
+        }
+        data, err := readField()
+        if err == io.EOF {
+            return io.ErrUnexpectedEOF
+        }
+        if err != nil {
+            return err
+        }
+        var proof MerkleProof
+        if err := proof.UnmarshalBinary(data); err != nil {
+            return err
+        }
+        if err := fn(key, &proof); err != nil {
+            return err
+        }
+    }
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+    "bytes"
+    "testing"
+)
+
+func TestExportAllProofs(t *testing.T) {
+    tree := newTestTree(t)
+    keys, values := testLeaves(64)
+    if err := tree.AddBatch(keys, values); err != nil {
+        t.Fatal(err)
+    }
+    root := tree.Root()
+
+    var buf bytes.Buffer
+    if err := tree.ExportAllProofs(&buf); err != nil {
+        t.Fatal(err)
+    }
+
+    n := 0
+    err := ReadExportedProofs(&buf, func(key []byte, proof *MerkleProof) error {
+        if !bytes.Equal(key, keys[n]) {
+            t.Fatalf("record %d is for %s, want %s", n, key, keys[n])
+        }
+        if proof.Index != uint(n) {
+            t.Fatalf("proof of %s is for leaf %d, want %d", key, proof.Index, n)
+        }
+        leaf, err := tree.LeafHash(key)
+        if err != nil {
+            return err
+        }
+        if !bytes.Equal(proof.Leaf, leaf) {
+            t.Fatalf("proof of %s commits to leaf %x, want %x", key, proof.Leaf, leaf)
+        }
+        ok, err := VerifyProof(root, proof)
+        if err != nil {
+            return err
+        }
+        if !ok {
+            t.Fatalf("exported proof of %s does not verify", key)
+        }
+        n++
+        return nil
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    if n != len(keys) {
+        t.Fatalf("read %d exported proofs, want %d", n, len(keys))
+    }
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+    "crypto/subtle"
+    "encoding/binary"
+    "math/big"
+)
+
+// FpElement is a field element in its in-memory form.
+type FpElement [fpSize]byte
+
+// ConstantTimeEquals reports whether a and b are equal in time that does
+// not depend on where they differ.
+func ConstantTimeEquals(a, b FpElement) bool {
+    return subtle.ConstantTimeCompare(a[:], b[:]) == 1
+}
+
+// fpEqual is ConstantTimeEquals for field elements held as slices; slices
+// of different lengths are unequal. Verification paths compare roots and
+// nodes with it rather than bytes.Equal.
+func fpEqual(a, b []byte) bool {
+    return subtle.ConstantTimeCompare(a, b) == 1
+}
+
+// Field elements cross the cgo boundary in the Montgomery form used by
+// ark-ff: the four limbs hold x*R mod p with R = 2^256. Tools outside this
+// package (Circom, snarkjs, Fp's Display impl in lib.rs) work with the
+// canonical integer x, so conversions to and from big.Int go through R.
+
+var (
+    montR    = new(big.Int).Mod(new(big.Int).Lsh(big.NewInt(1), 256), fieldModulus)
+    montRInv = new(big.Int).ModInverse(montR, fieldModulus)
+)
+
+func leBytesToBigInt(b []byte) *big.Int {
+    be := make([]byte, len(b))
+    for i := range b {
+        be[len(b)-1-i] = b[i]
+    }
+    return new(big.Int).SetBytes(be)
+}
+
+func bigIntToLEBytes(n *big.Int) []byte {
+    buf := n.FillBytes(make([]byte, fpSize))
+    for i, j := 0, len(buf)-1; i < j; i, j = i+1, j-1 {
+        buf[i], buf[j] = buf[j], buf[i]
+    }
+    return buf
+}
+
+// fpToBigInt returns the canonical integer of a field element given in its
+// in-memory form.
+func fpToBigInt(b []byte) *big.Int {
+    n := leBytesToBigInt(b)
+    n.Mul(n, montRInv)
+    return n.Mod(n, fieldModulus)
+}
+
+// bigIntToFp returns the in-memory form of the field element n mod p.
+func bigIntToFp(n *big.Int) []byte {
+    m := new(big.Int).Mod(n, fieldModulus)
+    m.Mul(m, montR)
+    return bigIntToLEBytes(m.Mod(m, fieldModulus))
+}
+
+// limbsToBytes returns the in-memory form of a field element given as four
+// little-endian limbs.
+func limbsToBytes(limbs [4]uint64) []byte {
+    out := make([]byte, fpSize)
+    for i, limb := range limbs {
+        binary.LittleEndian.PutUint64(out[i*8:], limb)
+    }
+    return out
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "strings"
+    "sync"
+
+    "go.vocdoni.io/dvote/db"
+)
+
+// Forest manages named trees sharing one database. Each tree lives under
+// its own prefix, forest/<name>/, and a forest/<name> marker records that
+// it exists, so a later Forest over the same database finds it again.
+//
+// Each tree has its own C tree in lib.rs, so trees of a Forest can be used
+// from different goroutines at once. Forest methods may be called
+// concurrently; the trees they return follow the usual MerkleTree rules.
+type Forest struct {
+    db   db.Database
+    opts []Option
+
+    mu    sync.Mutex
+    trees map[string]*MerkleTree
+}
+
+var prefixForest = []byte("forest/")
+
+// ErrTreeNotFound is returned by Forest.Get and Forest.Drop for a name with
+// no tree.
+var ErrTreeNotFound = errors.New("tree does not exist")
+
+// NewForest returns a Forest over database. opts apply to every tree.
+func NewForest(database db.Database, opts ...Option) *Forest {
+    return &Forest{
+        db:    database,
+        opts:  opts,
+        trees: make(map[string]*MerkleTree),
+    }
+}
+
+func forestMarker(name string) []byte {
+    return dbKey(prefixForest, []byte(name))
+}
+
+func forestPrefix(name string) []byte {
+    return dbKey(prefixForest, []byte(name+"/"))
+}
+
+func validTreeName(name string) error {
+    if name == "" || strings.Contains(name, "/") {
+        return fmt.Errorf("invalid tree name %q", name)
+    }
+    return nil
+}
+
+func (f *Forest) exists(name string) (bool, error) {
+    rtx := f.db.ReadTx()
+    defer rtx.Discard()
+    _, err := rtx.Get(forestMarker(name))
+    if errors.Is(err, db.ErrKeyNotFound) {
+        return false, nil
+    }
+    return err == nil, err
+}
+
+// Create adds an empty tree called name. It fails with ErrKeyExists if the
+// name is taken.
+func (f *Forest) Create(name string) (*MerkleTree, error) {
+    if err := validTreeName(name); err != nil {
+        return nil, err
+    }
+
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    exists, err := f.exists(name)
+    if err != nil {
+        return nil, err
+    }
+    if _, open := f.trees[name]; exists || open {
+        return nil, fmt.Errorf("tree %q: %w", name, ErrKeyExists)
+    }
+
+    if err := f.deleteRecords(forestPrefix(name)); err != nil {
+        return nil, err
+    }
+    wtx := f.db.WriteTx()
+    defer wtx.Discard()
+    if err := wtx.Set(forestMarker(name), nil); err != nil {
+        return nil, err
+    }
+    if err := wtx.Commit(); err != nil {
+        return nil, err
+    }
+
+    tree := NewMerkleTree(db.NewPrefixedDatabase(forestPrefix(name), f.db), f.opts...)
+    f.trees[name] = tree
+    return tree, nil
+}
+
+// Get returns the tree called name, loading it from the database the first
+// time.
+func (f *Forest) Get(name string) (*MerkleTree, error) {
+    if err := validTreeName(name); err != nil {
+        return nil, err
+    }
+
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    if tree, ok := f.trees[name]; ok {
+        return tree, nil
+    }
+    exists, err := f.exists(name)
+    if err != nil {
+        return nil, err
+    }
+    if !exists {
+        return nil, fmt.Errorf("tree %q: %w", name, ErrTreeNotFound)
+    }
+
+    tree, err := LoadMerkleTree(db.NewPrefixedDatabase(forestPrefix(name), f.db), f.opts...)
+    if err != nil {
+        return nil, err
+    }
+    f.trees[name] = tree
+    return tree, nil
+}
+
+// forestDropChunk is the number of records Drop deletes per transaction.
+const forestDropChunk = 1000
+
+// Drop deletes the tree called name and all its records. Trees previously
+// returned for name must not be used afterwards.
+func (f *Forest) Drop(name string) error {
+    if err := validTreeName(name); err != nil {
+        return err
+    }
+
+    f.mu.Lock()
+    defer f.mu.Unlock()
+
+    exists, err := f.exists(name)
+    if err != nil {
+        return err
+    }
+    if !exists {
+        return fmt.Errorf("tree %q: %w", name, ErrTreeNotFound)
+    }
+
+    // Remove the marker first, so an interrupted Drop leaves no tree behind,
+    // only stray records, which Create clears before reusing the name.
+    wtx := f.db.WriteTx()
+    defer wtx.Discard()
+    if err := wtx.Delete(forestMarker(name)); err != nil {
+        return err
+    }
+    if err := wtx.Commit(); err != nil {
+        return err
+    }
+    if tree := f.trees[name]; tree != nil {
+        tree.Close()
+    }
+    delete(f.trees, name)
+
+    return f.deleteRecords(forestPrefix(name))
+}
+
+// deleteRecords deletes every record under prefix.
+func (f *Forest) deleteRecords(prefix []byte) error {
+    for {
+        var keys [][]byte
+        rtx := f.db.ReadTx()
+        err := rtx.Iterate(prefix, func(k, _ []byte) bool {
+            keys = append(keys, dbKey(prefix, k))
+            return len(keys) < forestDropChunk
+        })
+        rtx.Discard()
+        if err != nil {
+            return err
+        }
+        if len(keys) == 0 {
+            return nil
+        }
+
+        wtx := f.db.WriteTx()
+        for _, k := range keys {
+            if err := wtx.Delete(k); err != nil {
+                wtx.Discard()
+                return err
+            }
+        }
+        err = wtx.Commit()
+        wtx.Discard()
+        if err != nil {
+            return err
+        }
+    }
+}
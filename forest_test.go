@@ -0,0 +1,104 @@
+package main
+
+import (
+    "bytes"
+    "errors"
+    "testing"
+)
+
+// TestForestTreesAreIsolated fills three named trees of one database with
+// the same keys and different values, and checks that roots, values and
+// proofs never cross from one tree to another, including after the forest
+// is opened again.
+func TestForestTreesAreIsolated(t *testing.T) {
+    database := newTestDB(t)
+    forest := NewForest(database)
+    names := []string{"alpha", "beta", "gamma"}
+    keys, _ := testLeaves(5)
+
+    valuesOf := func(tree int) [][]byte {
+        values := make([][]byte, len(keys))
+        for i := range values {
+            values[i] = encodeUint64(uint64(100*(tree+1) + i))
+        }
+        return values
+    }
+
+    roots := make([][]byte, len(names))
+    for n, name := range names {
+        tree, err := forest.Create(name)
+        if err != nil {
+            t.Fatal(err)
+        }
+        addAll(t, tree, keys, valuesOf(n))
+        roots[n] = tree.Root()
+
+        want := newTestTree(t, WithNoPersistence())
+        addAll(t, want, keys, valuesOf(n))
+        if !bytes.Equal(roots[n], want.Root()) {
+            t.Fatalf("tree %s has root %x, want that of its own leaves %x", name, roots[n], want.Root())
+        }
+    }
+    for a := range names {
+        for b := a + 1; b < len(names); b++ {
+            if bytes.Equal(roots[a], roots[b]) {
+                t.Fatalf("trees %s and %s share root %x", names[a], names[b], roots[a])
+            }
+        }
+    }
+    if _, err := forest.Create("beta"); !errors.Is(err, ErrKeyExists) {
+        t.Fatalf("creating beta again = %v, want ErrKeyExists", err)
+    }
+
+    // A second forest over the database loads the trees from their records.
+    reopened := NewForest(database)
+    for n, name := range names {
+        tree, err := reopened.Get(name)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !bytes.Equal(tree.Root(), roots[n]) {
+            t.Fatalf("reloaded tree %s has root %x, want %x", name, tree.Root(), roots[n])
+        }
+        values := valuesOf(n)
+        for i, key := range keys {
+            got, err := tree.Get(key)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if !bytes.Equal(got, values[i]) {
+                t.Fatalf("tree %s holds %x for %s, want %x", name, got, key, values[i])
+            }
+        }
+
+        proof, err := tree.GenProof(keys[0])
+        if err != nil {
+            t.Fatal(err)
+        }
+        for other := range names {
+            ok, err := VerifyProof(roots[other], proof)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if ok != (other == n) {
+                t.Fatalf("proof from tree %s verifies against the root of %s: %v", name, names[other], ok)
+            }
+        }
+    }
+
+    if err := reopened.Drop("beta"); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := reopened.Get("beta"); !errors.Is(err, ErrTreeNotFound) {
+        t.Fatalf("get of a dropped tree = %v, want ErrTreeNotFound", err)
+    }
+    for _, name := range []string{"alpha", "gamma"} {
+        tree, err := NewForest(database).Get(name)
+        if err != nil {
+            t.Fatalf("tree %s after dropping beta: %v", name, err)
+        }
+        if err := tree.CheckConsistency(); err != nil {
+            t.Fatalf("tree %s after dropping beta: %v", name, err)
+        }
+    }
+}
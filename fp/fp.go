@@ -0,0 +1,63 @@
+// Package fp holds helpers for the field the Poseidon tree hashes over.
+//
+// The Rust side of the tree (lib.rs) uses the Pasta Fp field from
+// mina-curves, not BN254, so the modulus below is the Pallas base field:
+//
+//     p = 0x40000000000000000000000000000000224698fc094cf91b992d30ed00000001
+//
+// Field elements cross the cgo boundary as four little-endian uint64 limbs,
+// the same layout as the C Fp struct.
+package fp
+
+import (
+    "encoding/binary"
+    "io"
+)
+
+// Modulus is the field modulus as little-endian limbs.
+var Modulus = [4]uint64{
+    0x992d30ed00000001,
+    0x224698fc094cf91b,
+    0x0000000000000000,
+    0x4000000000000000,
+}
+
+// topLimbMask clears the bits above the modulus bit length (255 bits), so
+// rejection sampling accepts roughly half of all draws.
+const topLimbMask = 0x7fffffffffffffff
+
+// Less reports whether a < b, comparing limbs from most significant.
+func Less(a, b [4]uint64) bool {
+    for i := 3; i >= 0; i-- {
+        if a[i] != b[i] {
+            return a[i] < b[i]
+        }
+    }
+    return false
+}
+
+// IsValid reports whether limbs is strictly below the modulus.
+func IsValid(limbs [4]uint64) bool {
+    return Less(limbs, Modulus)
+}
+
+// RandFp returns a uniformly random field element read from rng by
+// rejection sampling.
+func RandFp(rng io.Reader) ([4]uint64, error) {
+    var buf [32]byte
+    for {
+        if _, err := io.ReadFull(rng, buf[:]); err != nil {
+            return [4]uint64{}, err
+        }
+
+        var limbs [4]uint64
+        for i := range limbs {
+            limbs[i] = binary.LittleEndian.Uint64(buf[i*8:])
+        }
+        limbs[3] &= topLimbMask
+
+        if IsValid(limbs) {
+            return limbs, nil
+        }
+    }
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "math/big"
+    "testing"
+)
+
+// FuzzFpRoundTrip checks that a field element survives the trip through
+// C.Fp unchanged, that bytesToFp rejects slices of any other length than
+// fpSize instead of reading past them, and that the canonical big.Int
+// conversion inverts for elements below the modulus.
+func FuzzFpRoundTrip(f *testing.F) {
+    max := ^uint64(0)
+    seeds := [][]byte{
+        nil,
+        {1, 2, 3},
+        make([]byte, fpSize-1),
+        make([]byte, fpSize),
+        make([]byte, fpSize+1),
+        bigIntToFp(big.NewInt(1)),
+        bigIntToFp(new(big.Int).Sub(fieldModulus, big.NewInt(1))),
+        bigIntToLEBytes(fieldModulus),
+        limbsToBytes([4]uint64{max, max, max, max}),
+    }
+    for _, seed := range seeds {
+        f.Add(seed)
+    }
+
+    f.Fuzz(func(t *testing.T, b []byte) {
+        fp, err := bytesToFp(b)
+        if len(b) != fpSize {
+            if err == nil {
+                t.Fatalf("bytesToFp accepted %d bytes", len(b))
+            }
+            return
+        }
+        if err != nil {
+            t.Fatalf("bytesToFp(%x): %v", b, err)
+        }
+        if got := fpToBytes(&fp); !bytes.Equal(got, b) {
+            t.Fatalf("fpToBytes(bytesToFp(%x)) = %x", b, got)
+        }
+
+        if leBytesToBigInt(b).Cmp(fieldModulus) >= 0 {
+            return
+        }
+        n := fpToBigInt(b)
+        if n.Sign() < 0 || n.Cmp(fieldModulus) >= 0 {
+            t.Fatalf("fpToBigInt(%x) = %v, outside the field", b, n)
+        }
+        if got := bigIntToFp(n); !bytes.Equal(got, b) {
+            t.Fatalf("bigIntToFp(fpToBigInt(%x)) = %x", b, got)
+        }
+    })
+}
+
+func TestConstantTimeEquals(t *testing.T) {
+    var a FpElement
+    copy(a[:], bigIntToFp(big.NewInt(12345)))
+
+    first, last := a, a
+    first[0] ^= 1
+    last[fpSize-1] ^= 0x80
+
+    for _, c := range []struct {
+        name string
+        b    FpElement
+        want bool
+    }{
+        {"Equal", a, true},
+        {"FirstByte", first, false},
+        {"LastByte", last, false},
+    } {
+        if got := ConstantTimeEquals(a, c.b); got != c.want {
+            t.Errorf("%s: ConstantTimeEquals = %v, want %v", c.name, got, c.want)
+        }
+        if got := fpEqual(a[:], c.b[:]); got != c.want {
+            t.Errorf("%s: fpEqual = %v, want %v", c.name, got, c.want)
+        }
+    }
+    if fpEqual(a[:], a[:fpSize-1]) {
+        t.Error("fpEqual matched slices of different lengths")
+    }
+}
+
+// BenchmarkConstantTimeEquals compares elements differing in their first
+// and in their last byte; the two should take the same time.
+func BenchmarkConstantTimeEquals(b *testing.B) {
+    var a FpElement
+    copy(a[:], bigIntToFp(big.NewInt(12345)))
+    for _, pos := range []int{0, fpSize - 1} {
+        other := a
+        other[pos] ^= 1
+        b.Run(fmt.Sprintf("DiffAt%d", pos), func(b *testing.B) {
+            for i := 0; i < b.N; i++ {
+                ConstantTimeEquals(a, other)
+            }
+        })
+    }
+}
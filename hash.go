@@ -0,0 +1,87 @@
+package main
+
+import (
+    "encoding/binary"
+    "errors"
+)
+
+// spongeRate is the number of data bytes absorbed per HashTwo call. 31 bytes
+// are always below the field modulus, so chunks are never reduced and
+// distinct chunks map to distinct field elements.
+const spongeRate = 31
+
+// HashBytes hashes an arbitrary-length byte string into one field element.
+//
+// The construction is a simple duplex over HashTwo:
+//
+//     state = HashOne(uint64 little-endian len(data))
+//     for each 31-byte chunk c of data, the last one zero padded:
+//         state = HashTwo(state, c)
+//     return state
+//
+// Committing to the length first keeps inputs that differ only in trailing
+// zero bytes from colliding.
+func HashBytes(data []byte) ([]byte, error) {
+    var length [8]byte
+    binary.LittleEndian.PutUint64(length[:], uint64(len(data)))
+    state, err := HashOne(length[:])
+    if err != nil {
+        return nil, err
+    }
+
+    for off := 0; off < len(data); off += spongeRate {
+        end := off + spongeRate
+        if end > len(data) {
+            end = len(data)
+        }
+        if state, err = HashTwo(state, data[off:end]); err != nil {
+            return nil, err
+        }
+    }
+
+    return state, nil
+}
+
+// KeyedLeaf returns the leaf binding key to value under WithKeyedLeaves:
+// HashTwo(HashBytes(key), value). Keys may be of any length, so the key is
+// first hashed into a field element; value is the field element the leaf
+// would hold without keying, in its in-memory form.
+func KeyedLeaf(key, value []byte) ([]byte, error) {
+    k, err := HashBytes(key)
+    if err != nil {
+        return nil, err
+    }
+    return HashTwo(k, value)
+}
+
+// Hasher hashes a value into the leaf committed for it when values are
+// stored hashed. Internal nodes are always hashed by lib.rs with Poseidon,
+// so only the leaf derivation is pluggable. The result must be a field
+// element in its in-memory form.
+type Hasher interface {
+    HashLeaf(value []byte) ([]byte, error)
+}
+
+// PoseidonHasher is the default Hasher: HashOne.
+type PoseidonHasher struct{}
+
+// HashLeaf returns HashOne(value).
+func (PoseidonHasher) HashLeaf(value []byte) ([]byte, error) {
+    return HashOne(value)
+}
+
+// hashLeaf hashes value with the tree's Hasher and checks that the result
+// is a field element.
+func (tree *MerkleTree) hashLeaf(value []byte) ([]byte, error) {
+    if tree.opts.Hasher == nil {
+        return HashOne(value)
+    }
+    h, err := tree.opts.Hasher.HashLeaf(value)
+    if err != nil {
+        return nil, err
+    }
+    if len(h) != fpSize || leBytesToBigInt(h).Cmp(fieldModulus) >= 0 {
+        return nil, errors.New("hasher returned a value that is not a field element")
+    }
+    return h, nil
+}
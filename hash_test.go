@@ -0,0 +1,123 @@
+package main
+
+import (
+    "bytes"
+    "encoding/binary"
+    "testing"
+)
+
+// TestHashBytesVectors checks HashBytes on 0, 31, 32, 33 and 100 bytes
+// against the documented construction, written out chunk by chunk.
+func TestHashBytesVectors(t *testing.T) {
+    hashOne := func(v []byte) []byte {
+        h, err := HashOne(v)
+        if err != nil {
+            t.Fatal(err)
+        }
+        return h
+    }
+    hashTwo := func(a, b []byte) []byte {
+        h, err := HashTwo(a, b)
+        if err != nil {
+            t.Fatal(err)
+        }
+        return h
+    }
+    lengthHash := func(n int) []byte {
+        var length [8]byte
+        binary.LittleEndian.PutUint64(length[:], uint64(n))
+        return hashOne(length[:])
+    }
+
+    data := make([]byte, 100)
+    for i := range data {
+        data[i] = byte(i + 1)
+    }
+
+    vectors := []struct {
+        n      int
+        chunks [][2]int // the 31-byte chunks absorbed, as [lo, hi)
+    }{
+        {0, nil},
+        {31, [][2]int{{0, 31}}},
+        {32, [][2]int{{0, 31}, {31, 32}}},
+        {33, [][2]int{{0, 31}, {31, 33}}},
+        {100, [][2]int{{0, 31}, {31, 62}, {62, 93}, {93, 100}}},
+    }
+    seen := make(map[string]int)
+    for _, v := range vectors {
+        want := lengthHash(v.n)
+        for _, c := range v.chunks {
+            want = hashTwo(want, data[c[0]:c[1]])
+        }
+
+        got, err := HashBytes(data[:v.n])
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !bytes.Equal(got, want) {
+            t.Errorf("HashBytes of %d bytes = %x, want %x", v.n, got, want)
+        }
+        if n, ok := seen[string(got)]; ok {
+            t.Errorf("HashBytes of %d and %d bytes collide", n, v.n)
+        }
+        seen[string(got)] = v.n
+    }
+
+    // Trailing zero bytes change the length, so they change the hash.
+    padded, err := HashBytes(append(data[:31:31], 0))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if n, ok := seen[string(padded)]; ok {
+        t.Errorf("31 bytes and a zero collide with %d bytes", n)
+    }
+}
+
+func TestKeyedProofBindsKey(t *testing.T) {
+    keys, values := testLeaves(4)
+    keyed := newTestTree(t, WithKeyedLeaves())
+    addAll(t, keyed, keys, values)
+    plain := newTestTree(t)
+    addAll(t, plain, keys, values)
+    root := keyed.Root()
+
+    // VerifyKeyedProof takes each value as the field element an unkeyed
+    // tree holds for it.
+    elements := make([][]byte, len(keys))
+    for i, key := range keys {
+        element, err := plain.LeafHash(key)
+        if err != nil {
+            t.Fatal(err)
+        }
+        elements[i] = element
+    }
+
+    a, b := keys[1], keys[2]
+    proof, err := keyed.GenProof(a)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, c := range []struct {
+        name       string
+        key, value []byte
+        want       bool
+    }{
+        {"KeyAValueA", a, elements[1], true},
+        {"KeyAValueB", a, elements[2], false},
+        {"KeyBValueB", b, elements[2], false},
+        {"KeyBValueA", b, elements[1], false},
+    } {
+        ok, err := VerifyKeyedProof(root, c.key, c.value, proof)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if ok != c.want {
+            t.Errorf("%s: proof of %s verifies = %v, want %v", c.name, a, ok, c.want)
+        }
+    }
+
+    if bytes.Equal(keyed.Root(), plain.Root()) {
+        t.Fatal("keyed and unkeyed trees share a root")
+    }
+}
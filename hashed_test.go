@@ -0,0 +1,76 @@
+package main
+
+import (
+    "bytes"
+    "testing"
+
+    "go.vocdoni.io/dvote/db"
+)
+
+// recordsContaining returns the database records whose key or value
+// contains needle.
+func recordsContaining(t *testing.T, database db.Database, needle []byte) [][]byte {
+    t.Helper()
+    rtx := database.ReadTx()
+    defer rtx.Discard()
+    var found [][]byte
+    err := rtx.Iterate(nil, func(key, value []byte) bool {
+        if bytes.Contains(key, needle) || bytes.Contains(value, needle) {
+            found = append(found, append([]byte(nil), key...))
+        }
+        return true
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    return found
+}
+
+func TestStoreHashedValuesKeepsNoRawValue(t *testing.T) {
+    raw := []byte("raw value, not for the database")
+
+    for _, hashed := range []bool{false, true} {
+        database := newTestDB(t)
+        var opts []Option
+        if hashed {
+            opts = append(opts, WithStoreHashedValues())
+        }
+        tree := NewMerkleTree(database, opts...)
+        defer tree.Close()
+
+        if err := tree.Add([]byte("key"), raw); err != nil {
+            t.Fatal(err)
+        }
+        found := recordsContaining(t, database, raw)
+        if !hashed {
+            // Without the option the value is stored as is, which shows the
+            // scan finds it.
+            if len(found) == 0 {
+                t.Fatal("raw value not found in the database of a tree storing values")
+            }
+            continue
+        }
+        if len(found) != 0 {
+            t.Fatalf("raw value found in records %q", found)
+        }
+
+        want, err := HashOne(raw)
+        if err != nil {
+            t.Fatal(err)
+        }
+        got, err := tree.Get([]byte("key"))
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !bytes.Equal(got, want) {
+            t.Errorf("Get = %x, want HashOne(value) %x", got, want)
+        }
+        hash, err := tree.GetHash([]byte("key"))
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !bytes.Equal(hash, want) {
+            t.Errorf("GetHash = %x, want %x", hash, want)
+        }
+    }
+}
@@ -0,0 +1,40 @@
+package main
+
+import "sync"
+
+// treeHead is the last committed root and size of a tree, published for
+// readers on other goroutines. The rest of a MerkleTree must stay on the
+// goroutine that mutates it, so these readers cannot ask the C tree.
+type treeHead struct {
+    mu        sync.RWMutex
+    root      []byte
+    size      uint64
+    published bool
+}
+
+// publishHead makes the current root and size the ones RootAndSize returns.
+// It runs after every committed mutation and whenever the whole state is
+// replaced.
+func (tree *MerkleTree) publishHead() {
+    root := tree.Root()
+    size := uint64(len(tree.values))
+
+    tree.head.mu.Lock()
+    defer tree.head.mu.Unlock()
+    tree.head.root, tree.head.size, tree.head.published = root, size, true
+}
+
+// RootAndSize returns the root and the number of leaf slots of the tree
+// after its last committed mutation, as one consistent pair: the size is
+// always that of the tree the root is for. Unlike Root it may be called
+// from any goroutine while another mutates the tree, which makes it the way
+// to publish snapshots. Optimistic adds count once flushed. It returns
+// ErrEmptyTree for a new tree that has not committed anything yet.
+func (tree *MerkleTree) RootAndSize() (root []byte, size uint64, err error) {
+    tree.head.mu.RLock()
+    defer tree.head.mu.RUnlock()
+    if !tree.head.published {
+        return nil, 0, ErrEmptyTree
+    }
+    return append([]byte(nil), tree.head.root...), tree.head.size, nil
+}
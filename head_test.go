@@ -0,0 +1,75 @@
+package main
+
+import (
+    "bytes"
+    "errors"
+    "sync"
+    "testing"
+)
+
+// TestRootAndSizeConcurrent reads RootAndSize from several goroutines while
+// another adds leaves, and checks every pair against the root a reference
+// tree had at that size. Run it with -race.
+func TestRootAndSizeConcurrent(t *testing.T) {
+    const leaves = 200
+    keys, values := testLeaves(leaves)
+
+    ref := newTestTree(t, WithNoPersistence())
+    roots := make([][]byte, leaves+1)
+    for i := range keys {
+        addAll(t, ref, keys[i:i+1], values[i:i+1])
+        roots[i+1] = ref.Root()
+    }
+
+    tree := newTestTree(t)
+    if _, _, err := tree.RootAndSize(); !errors.Is(err, ErrEmptyTree) {
+        t.Fatalf("RootAndSize of a new tree = %v, want ErrEmptyTree", err)
+    }
+
+    done := make(chan struct{})
+    var wg sync.WaitGroup
+    errs := make(chan error, 4)
+    for r := 0; r < 4; r++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for {
+                select {
+                case <-done:
+                    return
+                default:
+                }
+                root, size, err := tree.RootAndSize()
+                if errors.Is(err, ErrEmptyTree) {
+                    continue
+                }
+                if err != nil {
+                    errs <- err
+                    return
+                }
+                if size == 0 || size > leaves || !bytes.Equal(root, roots[size]) {
+                    errs <- errors.New("root does not belong to the size returned with it")
+                    return
+                }
+            }
+        }()
+    }
+
+    for i := range keys {
+        if err := tree.Add(keys[i], values[i]); err != nil {
+            t.Error(err)
+            break
+        }
+    }
+    close(done)
+    wg.Wait()
+    close(errs)
+    for err := range errs {
+        t.Error(err)
+    }
+
+    root, size, err := tree.RootAndSize()
+    if err != nil || size != leaves || !bytes.Equal(root, roots[leaves]) {
+        t.Errorf("final RootAndSize = %x, %d, %v; want %x, %d", root, size, err, roots[leaves], leaves)
+    }
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+    "errors"
+    "math/big"
+)
+
+// IMT is the incremental Merkle tree interface used by Go ZK tooling that
+// works with field elements as big.Int and emits proofs for Circom circuits.
+type IMT interface {
+    Add(key, value *big.Int) error
+    GetRoot() *big.Int
+    GenerateCircomVerifierProof(key *big.Int) (*CircomProof, error)
+}
+
+// CircomProof is an inclusion proof laid out as the inputs of a Circom
+// Merkle inclusion circuit: the leaf, one sibling per level from the leaves
+// up, and per level 0 if the running node is the left child and 1 if it is
+// the right child. Every value is a canonical field element in decimal.
+//
+// The circuit must hash with the same Poseidon instance as lib.rs (Kimchi
+// parameters over Pasta Fp); circomlib's BN254 Poseidon gives other roots.
+type CircomProof struct {
+    Root         string   `json:"root"`
+    Leaf         string   `json:"leaf"`
+    PathElements []string `json:"pathElements"`
+    PathIndices  []int    `json:"pathIndices"`
+}
+
+// IMTAdapter exposes a MerkleTree through the IMT interface.
+type IMTAdapter struct {
+    *MerkleTree
+}
+
+var _ IMT = IMTAdapter{}
+
+// Add inserts value under the big-endian bytes of key.
+func (a IMTAdapter) Add(key, value *big.Int) error {
+    return a.MerkleTree.Add(key.Bytes(), bigIntToFp(value))
+}
+
+// GetRoot returns the root as a canonical field element.
+func (a IMTAdapter) GetRoot() *big.Int {
+    return fpToBigInt(a.MerkleTree.root())
+}
+
+// GenerateCircomVerifierProof returns the inclusion proof of key. Circom
+// circuits hash at every level, so it fails when the leaf's path contains an
+// odd last node that lib.rs carries up unhashed; that never happens in a
+// tree whose size is a power of two.
+func (a IMTAdapter) GenerateCircomVerifierProof(key *big.Int) (*CircomProof, error) {
+    proof, err := a.GenProof(key.Bytes())
+    if err != nil {
+        return nil, err
+    }
+    return circomProof(proof, a.MerkleTree.root())
+}
+
+// circomProof lays out proof as a CircomProof; see
+// GenerateCircomVerifierProof.
+func circomProof(proof *MerkleProof, root []byte) (*CircomProof, error) {
+    depth := treeDepth(proof.Size)
+    if len(proof.Siblings) != depth {
+        return nil, errors.New("leaf path has a level without a sibling; Circom proofs need a complete tree")
+    }
+
+    cp := &CircomProof{
+        Root:         fpToBigInt(root).String(),
+        Leaf:         fpToBigInt(proof.Leaf).String(),
+        PathElements: make([]string, depth),
+        PathIndices:  make([]int, depth),
+    }
+    for level, sibling := range proof.Siblings {
+        cp.PathElements[level] = fpToBigInt(sibling).String()
+        cp.PathIndices[level] = int(proof.Index>>uint(level)) & 1
+    }
+
+    return cp, nil
+}
+
+// GenGroth16Witness returns the inclusion proof of key as a witness input
+// map for snarkjs, with the keys used by Circom Merkle inclusion circuits
+// such as Tornado's: "root", "leaf", "path_elements" and "path_indices".
+// Values are as in CircomProof, and the same restrictions apply.
+func (tree *MerkleTree) GenGroth16Witness(key []byte) (map[string]interface{}, error) {
+    proof, err := tree.GenProof(key)
+    if err != nil {
+        return nil, err
+    }
+    cp, err := circomProof(proof, tree.root())
+    if err != nil {
+        return nil, err
+    }
+
+    return map[string]interface{}{
+        "root":          cp.Root,
+        "leaf":          cp.Leaf,
+        "path_elements": cp.PathElements,
+        "path_indices":  cp.PathIndices,
+    }, nil
+}
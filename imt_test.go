@@ -0,0 +1,78 @@
+package main
+
+import (
+    "encoding/json"
+    "math/big"
+    "reflect"
+    "testing"
+)
+
+// TestCircomProofFormat checks that a Circom proof has the JSON layout of
+// a Circom Merkle inclusion circuit's inputs and that folding it as the
+// circuit does gives the root.
+func TestCircomProofFormat(t *testing.T) {
+    imt := IMTAdapter{newTestTree(t)}
+    for i := int64(0); i < 8; i++ {
+        if err := imt.Add(big.NewInt(i+1), big.NewInt(100+i)); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    proof, err := imt.GenerateCircomVerifierProof(big.NewInt(6))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    blob, err := json.Marshal(proof)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var fields map[string]interface{}
+    if err := json.Unmarshal(blob, &fields); err != nil {
+        t.Fatal(err)
+    }
+    for _, name := range []string{"root", "leaf", "pathElements", "pathIndices"} {
+        if _, ok := fields[name]; !ok || len(fields) != 4 {
+            t.Fatalf("proof JSON %s, want exactly root, leaf, pathElements and pathIndices", blob)
+        }
+    }
+
+    decimal := func(s string) *big.Int {
+        n, ok := new(big.Int).SetString(s, 10)
+        if !ok || n.Sign() < 0 || n.Cmp(fieldModulus) >= 0 {
+            t.Fatalf("%q is not a canonical field element in decimal", s)
+        }
+        return n
+    }
+
+    // Key 6 is the sixth leaf, index 5 = 0b101: right, left, right.
+    if want := []int{1, 0, 1}; !reflect.DeepEqual(proof.PathIndices, want) {
+        t.Fatalf("pathIndices %v, want %v", proof.PathIndices, want)
+    }
+    if len(proof.PathElements) != 3 {
+        t.Fatalf("%d pathElements for a depth 3 tree", len(proof.PathElements))
+    }
+    node := bigIntToFp(decimal(proof.Leaf))
+    for level, s := range proof.PathElements {
+        sibling := bigIntToFp(decimal(s))
+        if proof.PathIndices[level] == 0 {
+            node, err = HashTwo(node, sibling)
+        } else {
+            node, err = HashTwo(sibling, node)
+        }
+        if err != nil {
+            t.Fatal(err)
+        }
+    }
+    if got := fpToBigInt(node); got.Cmp(decimal(proof.Root)) != 0 || got.Cmp(imt.GetRoot()) != 0 {
+        t.Fatalf("folded root %s, proof root %s, tree root %s", got, proof.Root, imt.GetRoot())
+    }
+
+    // A ninth leaf leaves the last one without a sibling at every level.
+    if err := imt.Add(big.NewInt(9), big.NewInt(108)); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := imt.GenerateCircomVerifierProof(big.NewInt(9)); err == nil {
+        t.Fatal("Circom proof of a leaf with an unhashed level")
+    }
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+    "encoding/binary"
+    "errors"
+
+    "go.vocdoni.io/dvote/db"
+)
+
+// The key index maps each key to its leaf slot. By default it is mirrored
+// in memory in tree.keyIndex; with DBOnlyIndex the idx/ records in the
+// database are the only copy and every lookup reads them, trading a DB read
+// per lookup for memory proportional to the number of keys.
+
+func (tree *MerkleTree) dbOnlyIndex() bool {
+    return tree.opts.DBOnlyIndex && !tree.opts.NoPersistence
+}
+
+// indexOf returns the leaf index of key.
+func (tree *MerkleTree) indexOf(key []byte) (int, bool, error) {
+    if !tree.dbOnlyIndex() {
+        idx, exists := tree.keyIndex[string(key)]
+        return idx, exists, nil
+    }
+    if tree.bloom != nil && !tree.bloom.MayContain(key) {
+        return 0, false, nil
+    }
+
+    rtx := tree.db.ReadTx()
+    defer rtx.Discard()
+    value, err := rtx.Get(dbKey(prefixIndex, key))
+    if errors.Is(err, db.ErrKeyNotFound) {
+        return 0, false, nil
+    }
+    if err != nil {
+        return 0, false, err
+    }
+    return int(binary.LittleEndian.Uint64(value)), true, nil
+}
+
+// setIndex records idx as the index of key in memory. The database record
+// is written separately by putLeafRecord.
+func (tree *MerkleTree) setIndex(key []byte, idx int) {
+    if tree.bloom != nil {
+        tree.bloom.Add(key)
+    }
+    if !tree.dbOnlyIndex() {
+        tree.keyIndex[string(key)] = idx
+    }
+}
+
+// dropIndex forgets key in memory.
+func (tree *MerkleTree) dropIndex(key []byte) {
+    delete(tree.keyHashes, string(key))
+    if !tree.dbOnlyIndex() {
+        delete(tree.keyIndex, string(key))
+    }
+}
+
+// eachIndex calls fn for every key and its index, in no particular order,
+// until fn returns false.
+func (tree *MerkleTree) eachIndex(fn func(key []byte, idx int) bool) error {
+    if !tree.dbOnlyIndex() {
+        for k, idx := range tree.keyIndex {
+            if !fn([]byte(k), idx) {
+                break
+            }
+        }
+        return nil
+    }
+
+    rtx := tree.db.ReadTx()
+    defer rtx.Discard()
+    return rtx.Iterate(prefixIndex, func(key, value []byte) bool {
+        return fn(append([]byte(nil), key...), int(binary.LittleEndian.Uint64(value)))
+    })
+}
+
+// Has reports whether key is in the tree. With UseBloomFilter, an absent
+// key is usually reported without reading the database.
+func (tree *MerkleTree) Has(key []byte) (bool, error) {
+    _, exists, err := tree.indexOf(key)
+    return exists, err
+}
+
+// keyAt returns the key holding the leaf at idx, or ErrKeyNotFound for a
+// deleted slot. The leaf index in the database usually names it in one
+// read; keys sharing a leaf, or leaves written before that index existed,
+// fall back to scanning the key index.
+func (tree *MerkleTree) keyAt(idx int) ([]byte, error) {
+    stored := tree.values[idx]
+    if stored == nil {
+        return nil, ErrKeyNotFound
+    }
+
+    if !tree.opts.NoPersistence {
+        leaf, err := tree.storedLeafBytes(stored)
+        if err != nil {
+            return nil, err
+        }
+        rtx := tree.db.ReadTx()
+        key, err := rtx.Get(leafKeyKey(leaf))
+        if err == nil {
+            key = append([]byte(nil), key...)
+        }
+        rtx.Discard()
+        if err != nil && !errors.Is(err, db.ErrKeyNotFound) {
+            return nil, err
+        }
+        if err == nil {
+            if i, exists, err := tree.indexOf(key); err != nil {
+                return nil, err
+            } else if exists && i == idx {
+                return key, nil
+            }
+        }
+    }
+
+    var found []byte
+    err := tree.eachIndex(func(key []byte, i int) bool {
+        if i == idx {
+            found = key
+            return false
+        }
+        return true
+    })
+    if err != nil {
+        return nil, err
+    }
+    if found == nil {
+        return nil, ErrKeyNotFound
+    }
+    return found, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+    "bytes"
+    "runtime"
+    "testing"
+
+    "github.com/Aquariumdevs/poseidontree/poseidontreetest"
+)
+
+func TestDBOnlyIndexProofs(t *testing.T) {
+    database := newTestDB(t)
+    tree := NewMerkleTree(database, WithDBOnlyIndex())
+    defer tree.Close()
+    want := newTestTree(t, WithNoPersistence())
+
+    keys, values := testLeaves(40)
+    addAll(t, tree, keys[:10], values[:10])
+    if err := tree.AddBatch(keys[10:], values[10:]); err != nil {
+        t.Fatal(err)
+    }
+    addAll(t, want, keys, values)
+
+    if len(tree.keyIndex) != 0 {
+        t.Errorf("in-memory index holds %d keys", len(tree.keyIndex))
+    }
+    if !bytes.Equal(tree.Root(), want.Root()) {
+        t.Fatalf("root %x, want %x as with the in-memory index", tree.Root(), want.Root())
+    }
+    for i := range keys {
+        if ok, err := tree.Has(keys[i]); err != nil || !ok {
+            t.Fatalf("Has(%s) = %v, %v", keys[i], ok, err)
+        }
+        poseidontreetest.AssertProof(t, tree, keys[i], values[i])
+    }
+    if ok, err := tree.Has([]byte("absent")); err != nil || ok {
+        t.Errorf("Has(absent) = %v, %v", ok, err)
+    }
+
+    loaded, err := LoadMerkleTree(database, WithDBOnlyIndex())
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer loaded.Close()
+    for i := range keys {
+        poseidontreetest.AssertProof(t, loaded, keys[i], values[i])
+    }
+}
+
+// BenchmarkKeyIndexMemory reports the heap a tree of 10000 leaves holds
+// with the in-memory key index and with DBOnlyIndex. The database is part
+// of both, so the difference is the index.
+func BenchmarkKeyIndexMemory(b *testing.B) {
+    const leaves = 10000
+    keys, values := testLeaves(leaves)
+
+    for _, bench := range []struct {
+        name string
+        opts []Option
+    }{
+        {"map", nil},
+        {"db", []Option{WithDBOnlyIndex()}},
+    } {
+        b.Run(bench.name, func(b *testing.B) {
+            var held uint64
+            for i := 0; i < b.N; i++ {
+                var before, after runtime.MemStats
+                runtime.GC()
+                runtime.ReadMemStats(&before)
+
+                tree := NewMerkleTree(newTestDB(b), bench.opts...)
+                if err := tree.AddBatch(keys, values); err != nil {
+                    b.Fatal(err)
+                }
+
+                runtime.GC()
+                runtime.ReadMemStats(&after)
+                if after.HeapAlloc > before.HeapAlloc {
+                    held += after.HeapAlloc - before.HeapAlloc
+                }
+                runtime.KeepAlive(tree)
+                tree.Close()
+            }
+            b.ReportMetric(float64(held)/float64(b.N)/leaves, "heap-B/leaf")
+        })
+    }
+}
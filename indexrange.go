@@ -0,0 +1,131 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+)
+
+// IndexRangeProof proves that the tree holds a leaf somewhere in the index
+// range [Lo, Hi] without saying which.
+//
+// Instead of a leaf it opens Node, the node at Level (0 for the leaves) and
+// position Index of a tree holding Size leaves. Node commits to the leaves
+// [Index<<Level, (Index+1)<<Level), capped at Size, which all lie in the
+// range, and at least one of them is a present, not deleted, leaf. Siblings
+// lead from Node up to the root as in MerkleProof, so the verifier learns
+// only the block of leaves, and the prover picks the highest such block to
+// keep the proof short.
+type IndexRangeProof struct {
+    Lo, Hi         uint64
+    Level          int
+    Index          uint
+    Size           uint
+    Node           []byte
+    Siblings       [][]byte
+    SortedChildren bool
+}
+
+// nodeSpan returns the leaves [first, end) under the node at position index
+// of level in a tree of size leaves.
+func nodeSpan(level int, index, size uint) (first, end uint) {
+    first = index << uint(level)
+    end = (index + 1) << uint(level)
+    if end > size {
+        end = size
+    }
+    return first, end
+}
+
+// GenIndexRangeProof returns a proof that some leaf with an index in
+// [lo, hi] is in the tree.
+func (tree *MerkleTree) GenIndexRangeProof(lo, hi uint64) (IndexRangeProof, error) {
+    size := uint(len(tree.values))
+    if lo > hi || lo >= uint64(size) {
+        return IndexRangeProof{}, fmt.Errorf("range [%d, %d] of %d leaves: %w", lo, hi, size, ErrIndexOutOfRange)
+    }
+    last := uint(hi)
+    if hi >= uint64(size) {
+        last = size - 1
+    }
+
+    levels, err := tree.levels()
+    if err != nil {
+        return IndexRangeProof{}, err
+    }
+
+    present := func(first, end uint) bool {
+        for i := first; i < end; i++ {
+            if tree.values[i] != nil {
+                return true
+            }
+        }
+        return false
+    }
+
+    for level := len(levels) - 1; level >= 0; level-- {
+        nodes := levels[level]
+        for idx := uint(lo) >> uint(level); idx < uint(len(nodes)); idx++ {
+            first, end := nodeSpan(level, idx, size)
+            if end-1 > last {
+                break
+            }
+            if first < uint(lo) || !present(first, end) {
+                continue
+            }
+
+            proof := IndexRangeProof{
+                Lo:             lo,
+                Hi:             hi,
+                Level:          level,
+                Index:          idx,
+                Size:           size,
+                Node:           nodes[idx],
+                SortedChildren: tree.opts.SortedChildren,
+            }
+            pos := idx
+            for _, upper := range levels[level : len(levels)-1] {
+                if pos%2 == 1 {
+                    proof.Siblings = append(proof.Siblings, upper[pos-1])
+                } else if pos+1 < uint(len(upper)) {
+                    proof.Siblings = append(proof.Siblings, upper[pos+1])
+                }
+                pos /= 2
+            }
+            return proof, nil
+        }
+    }
+
+    return IndexRangeProof{}, fmt.Errorf("no leaf in [%d, %d]: %w", lo, hi, ErrKeyNotFound)
+}
+
+// VerifyIndexRangeProof reports whether proof shows that the tree with the
+// given root holds a leaf in [lo, hi]. It cannot check that the opened
+// block holds a present leaf rather than only deleted ones; that rests on
+// the prover, as the block's contents stay hidden.
+func VerifyIndexRangeProof(root []byte, lo, hi uint64, proof IndexRangeProof) (bool, error) {
+    if proof.Lo != lo || proof.Hi != hi {
+        return false, nil
+    }
+    if proof.Level < 0 || proof.Level >= 64 {
+        return false, errors.New("index range proof level out of range")
+    }
+
+    first, end := nodeSpan(proof.Level, proof.Index, proof.Size)
+    if first >= end || uint64(first) < lo || uint64(end-1) > hi {
+        return false, nil
+    }
+
+    // Above Level the tree is shaped like a tree of width leaves, so the
+    // node verifies like a leaf proof.
+    width := proof.Size
+    for l := 0; l < proof.Level; l++ {
+        width = (width + 1) / 2
+    }
+    return VerifyProof(root, &MerkleProof{
+        Index:          proof.Index,
+        Size:           width,
+        Leaf:           proof.Node,
+        Siblings:       proof.Siblings,
+        SortedChildren: proof.SortedChildren,
+    })
+}
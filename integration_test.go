@@ -0,0 +1,55 @@
+//go:build integration
+
+package main
+
+import (
+    "fmt"
+    "math/rand"
+    "testing"
+
+    "go.vocdoni.io/dvote/db"
+    "go.vocdoni.io/dvote/db/badgerdb"
+)
+
+// TestIntegration_AddAndVerify runs the proof lifecycle against the linked
+// library and a real database: 1000 leaves go in with AddBatch, and the
+// proofs of 50 of them, picked at random, must verify against the root.
+//
+//     go test -tags integration -run TestIntegration ./...
+func TestIntegration_AddAndVerify(t *testing.T) {
+    const leaves, samples = 1000, 50
+
+    database, err := badgerdb.New(db.Options{Path: t.TempDir()})
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer database.Close()
+    tree := NewMerkleTree(database)
+    defer tree.Close()
+
+    keys := make([][]byte, leaves)
+    values := make([][]byte, leaves)
+    for i := range keys {
+        keys[i] = []byte(fmt.Sprintf("key-%d", i))
+        values[i] = encodeUint64(uint64(i))
+    }
+    if err := tree.AddBatch(keys, values); err != nil {
+        t.Fatal(err)
+    }
+    root := tree.Root()
+
+    rng := rand.New(rand.NewSource(1))
+    for _, i := range rng.Perm(leaves)[:samples] {
+        proof, err := tree.GenProof(keys[i])
+        if err != nil {
+            t.Fatalf("proof of %s: %v", keys[i], err)
+        }
+        ok, err := proof.Verify(root)
+        if err != nil {
+            t.Fatalf("verify %s: %v", keys[i], err)
+        }
+        if !ok {
+            t.Errorf("proof of %s does not verify against root %x", keys[i], root)
+        }
+    }
+}
@@ -7,7 +7,9 @@ use std::os::raw::c_void;
 use std::slice;
 use ark_ff::Zero;
 use lazy_static::lazy_static;
-use std::sync::Mutex;
+use std::collections::HashMap;
+use std::sync::{Arc, Mutex};
+use std::sync::atomic::{AtomicBool, AtomicU64, Ordering};
 
 use mina_curves::pasta::fields::Fp;
 use mina_poseidon::{
@@ -123,6 +125,39 @@ pub extern "C" fn hashpd(output: *mut Fp, fp: Fp, fpd: Fp) -> Fp {
 	h
 }
 
+// When set, every internal node hashes its lexicographically smaller child
+// first, comparing the in-memory bytes of the two field elements. This makes
+// a parent independent of which side each child sits on.
+static SORTED_CHILDREN: AtomicBool = AtomicBool::new(false);
+
+fn fp_bytes(fp: &Fp) -> [u8; 32] {
+    unsafe { std::mem::transmute_copy(fp) }
+}
+
+fn hash_children(left: Fp, right: Fp) -> Fp {
+    hash_children_with(left, right, SORTED_CHILDREN.load(Ordering::Relaxed))
+}
+
+fn hash_children_with(left: Fp, right: Fp, sorted: bool) -> Fp {
+    if sorted && fp_bytes(&right) < fp_bytes(&left) {
+        poseidon_hash(&[right, left])
+    } else {
+        poseidon_hash(&[left, right])
+    }
+}
+
+// NUL-terminated crate version, for the Go side to check what it linked.
+static LIBRARY_VERSION: &str = concat!(env!("CARGO_PKG_VERSION"), "\0");
+
+#[no_mangle]
+pub extern "C" fn library_version() -> *const std::os::raw::c_char {
+    LIBRARY_VERSION.as_ptr() as *const std::os::raw::c_char
+}
+
+#[no_mangle]
+pub extern "C" fn set_sorted_children(enabled: u8) {
+    SORTED_CHILDREN.store(enabled != 0, Ordering::Relaxed);
+}
 
 #[repr(C)]
 struct MerkleNode {
@@ -151,7 +186,7 @@ fn build_merkle_tree(data: Vec<Fp>) -> Fp {
 
         for chunk in current_level.chunks(2) {
             let hash = match chunk {
-                [left, right] => poseidon_hash(&[*left, *right]),
+                [left, right] => hash_children(*left, *right),
                 [left] => *left, // In case of an odd number of elements, just carry the last one forward
                 _ => unreachable!(),
             };
@@ -176,14 +211,22 @@ struct MerkleTree {
 
 fn build_full_merkle_tree(data: Vec<Fp>) -> MerkleNode {
   LOCAL_TREE.with(|levels| {
-    // Use the borrowed value of `tree` here
+    let mut levels = levels.borrow_mut();
+    build_levels(&mut levels, data, SORTED_CHILDREN.load(Ordering::Relaxed));
+    let root = &levels.last().unwrap()[0];
+    MerkleNode {
+        hash: root.hash,
+        left: root.left,
+        right: root.right,
+    }
+  })
+}
 
+// Replaces levels with the tree of data, which must not be empty.
+fn build_levels(levels: &mut Vec<Vec<MerkleNode>>, data: Vec<Fp>, sorted: bool) {
     let leaf_count = data.len();
-    //let mut levels: Vec<Vec<MerkleNode>> = Vec::new();
-    let mut levels = levels.borrow_mut();
     levels.clear(); // Clear existing tree levels if any
 
-
     let mut nodes = Vec::with_capacity(leaf_count);
     for fp in data {
         nodes.push(MerkleNode {
@@ -201,9 +244,9 @@ fn build_full_merkle_tree(data: Vec<Fp>) -> MerkleNode {
             let node = match chunk {
                 [left, right] => {
                     MerkleNode {
-                        hash: poseidon_hash(&[left.hash, right.hash]),
-                        left: left as *const _ as *mut _, 
-                        right: right as *const _ as *mut _, 
+                        hash: hash_children_with(left.hash, right.hash, sorted),
+                        left: left as *const _ as *mut _,
+                        right: right as *const _ as *mut _,
                     }
                 },
                 [left] => {
@@ -217,14 +260,10 @@ fn build_full_merkle_tree(data: Vec<Fp>) -> MerkleNode {
             };
             new_level.push(node);
         }
+        // Keep every level, including the root, so paths and later appends
+        // see the whole tree.
         levels.push(new_level);
     }
-    if let Some(mut last_level) = levels.pop() {
-        return last_level.remove(0);
-    }
-
-    levels.remove(0).remove(0)
-  })   
 }
 
 
@@ -240,60 +279,118 @@ pub extern "C" fn create_merkle_tree(data: *const Fp, count: usize) -> *mut Merk
 }
 
 pub fn get_merkle_path_impl(leaf_index: usize) -> Vec<Fp> {
+    LOCAL_TREE.with(|tree| path_of(&tree.borrow(), leaf_index))
+}
+
+fn path_of(tree: &Vec<Vec<MerkleNode>>, leaf_index: usize) -> Vec<Fp> {
     let mut path = Vec::new();
 
-    LOCAL_TREE.with(|tree| {
-        let tree = tree.borrow();
+    if tree.is_empty() {
+        return path;
+    }
 
-        if tree.is_empty() {
-            return;
+    let mut current_index = leaf_index;
+    for level in 0..tree.len() - 1 {
+        let nodes = &tree[level];
+        if nodes.len() <= current_index {
+            break; // Safety check
         }
 
-        let mut current_index = leaf_index;
-        for level in 0..tree.len() - 1 {
-            let nodes = &tree[level];
-            if nodes.len() <= current_index {
-                break; // Safety check
-            }
-
-            let sibling_index = if current_index % 2 == 0 { current_index + 1 } else { current_index - 1 };
-            // Check if sibling index is out of bounds (can happen if the last node is duplicated)
-            let sibling_index = sibling_index.min(nodes.len() - 1);
-            
-            if let Some(this_node) = nodes.get(current_index){ 
-                if current_index < sibling_index {path.push(unsafe { (*this_node).hash });} // Push the hash of this node
-            }
-
-            if let Some(sibling_node) = nodes.get(sibling_index) {
-                path.push(unsafe { (*sibling_node).hash }); // Push the hash of the sibling node
-            }
-
-            if let Some(this_node) = nodes.get(current_index){ 
-                if current_index > sibling_index {path.push(unsafe { (*this_node).hash });} // Push the hash of this node
-            }
-
-            current_index /= 2; // Move to the next level
+        let sibling_index = if current_index % 2 == 0 { current_index + 1 } else { current_index - 1 };
+        // Check if sibling index is out of bounds (can happen if the last node is duplicated)
+        let sibling_index = sibling_index.min(nodes.len() - 1);
+
+        if let Some(this_node) = nodes.get(current_index){
+            if current_index < sibling_index {path.push(this_node.hash);} // Push the hash of this node
         }
-    });
+
+        if let Some(sibling_node) = nodes.get(sibling_index) {
+            path.push(sibling_node.hash); // Push the hash of the sibling node
+        }
+
+        if let Some(this_node) = nodes.get(current_index){
+            if current_index > sibling_index {path.push(this_node.hash);} // Push the hash of this node
+        }
+
+        current_index /= 2; // Move to the next level
+    }
 
     path
 }
 
 
+// Return codes of get_merkle_path.
+const PATH_OK: usize = 0;
+const PATH_INDEX_OUT_OF_RANGE: usize = 1;
+const PATH_EMPTY_TREE: usize = 2;
+const PATH_BUFFER_TOO_SMALL: usize = 3;
+const PATH_NULL_POINTER: usize = 4;
+const TREE_UNKNOWN_HANDLE: usize = 5;
+
+fn leaf_count() -> usize {
+    LOCAL_TREE.with(|levels| leaf_count_of(&levels.borrow()))
+}
+
+fn leaf_count_of(levels: &Vec<Vec<MerkleNode>>) -> usize {
+    levels.first().map_or(0, |leaves| leaves.len())
+}
+
+// Writes the path of leaf_index into out_path, which holds *out_path_len
+// elements, and sets *out_path_len to the path length. When the buffer is
+// too small nothing is written and *out_path_len is set to the length needed.
 #[no_mangle]
 pub extern "C" fn get_merkle_path(leaf_index: usize, out_path: *mut Fp, out_path_len: *mut usize) -> usize {
-    let path = get_merkle_path_impl(leaf_index); // Assume this function returns Vec<Fp>
+    LOCAL_TREE.with(|levels| write_path(&levels.borrow(), leaf_index, out_path, out_path_len))
+}
+
+fn write_path(levels: &Vec<Vec<MerkleNode>>, leaf_index: usize, out_path: *mut Fp, out_path_len: *mut usize) -> usize {
+    if out_path.is_null() || out_path_len.is_null() {
+        return PATH_NULL_POINTER;
+    }
+    let count = leaf_count_of(levels);
+    if count == 0 {
+        return PATH_EMPTY_TREE;
+    }
+    if leaf_index >= count {
+        return PATH_INDEX_OUT_OF_RANGE;
+    }
+
+    let path = path_of(levels, leaf_index);
     unsafe {
-        if !out_path.is_null() && !out_path_len.is_null() {
-            let out_path_slice = std::slice::from_raw_parts_mut(out_path, *out_path_len);
-            for (i, node) in path.iter().enumerate() {
-                if i >= *out_path_len { break; }
-                out_path_slice[i] = *node;
-            }
+        if path.len() > *out_path_len {
             *out_path_len = path.len();
+            return PATH_BUFFER_TOO_SMALL;
         }
+        let out_path_slice = std::slice::from_raw_parts_mut(out_path, path.len());
+        out_path_slice.copy_from_slice(&path);
+        *out_path_len = path.len();
     }
-    0 // Return success code
+    PATH_OK
+}
+
+// Writes the number of nodes held across all levels of the tree, carried
+// nodes included, and the bytes allocated for them. Returns 0, or
+// PATH_NULL_POINTER if either pointer is null.
+#[no_mangle]
+pub extern "C" fn tree_memory_stats(out_nodes: *mut usize, out_bytes: *mut u64) -> usize {
+    LOCAL_TREE.with(|levels| write_memory_stats(&levels.borrow(), out_nodes, out_bytes))
+}
+
+fn write_memory_stats(levels: &Vec<Vec<MerkleNode>>, out_nodes: *mut usize, out_bytes: *mut u64) -> usize {
+    if out_nodes.is_null() || out_bytes.is_null() {
+        return PATH_NULL_POINTER;
+    }
+    let mut nodes = 0usize;
+    let mut bytes = (levels.capacity() * std::mem::size_of::<Vec<MerkleNode>>()) as u64;
+    for level in levels.iter() {
+        nodes += level.len();
+        bytes += (level.capacity() * std::mem::size_of::<MerkleNode>()) as u64;
+    }
+    unsafe {
+        *out_nodes = nodes;
+        *out_bytes = bytes;
+    }
+    PATH_OK
 }
 
 ////////////////////////////////////////////////////
@@ -330,56 +427,99 @@ pub extern "C" fn logfp(fp: Fp) -> Fp {
 
 fn add_one_leaf(new_leaf: Fp) {
     LOCAL_TREE.with(|levels| {
-        let mut levels = levels.borrow_mut();
-        if levels.is_empty() {
-            // No tree exists, create a new one with the new leaf
-            levels.push(vec![MerkleNode::new(new_leaf)]);
-        } else {
-            // Add the new leaf to the bottom level
-            let mut current_level = levels.pop().unwrap();
-            current_level.push(MerkleNode::new(new_leaf));
-            levels.push(current_level);
-
-            // Update the tree by recalculating the hashes up to the root
-            let mut level_index = levels.len() - 1;
-            while level_index > 0 {
-                let mut parent_level = Vec::new();
-                let current_level = &levels[level_index];
-                for chunk in current_level.chunks(2) {
-                    let hash = match chunk {
-                        [left, right] => poseidon_hash(&[left.hash, right.hash]),
-                        [left] => left.hash,
-                        _ => unreachable!(),
-                    };
-                    parent_level.push(MerkleNode {
-                        hash,
-                        left: &chunk[0],
-                        right: if chunk.len() > 1 { &chunk[1] } else { &chunk[0] },
-                    });
-                }
-                levels[level_index - 1] = parent_level;
-                level_index -= 1;
-            }
-        }
+        add_leaf_levels(&mut levels.borrow_mut(), new_leaf, SORTED_CHILDREN.load(Ordering::Relaxed))
     });
 }
 
-fn get_root() -> Fp {
-    LOCAL_TREE.with(|levels| {
-        let levels = levels.borrow();
-        if levels.is_empty() {
-            Fp::zero()
+fn add_leaf_levels(levels: &mut Vec<Vec<MerkleNode>>, new_leaf: Fp, sorted: bool) {
+    if levels.is_empty() {
+        // No tree exists, create a new one with the new leaf
+        levels.push(vec![MerkleNode::new(new_leaf)]);
+        return;
+    }
+
+    // Add the new leaf to the bottom level
+    levels[0].push(MerkleNode::new(new_leaf));
+
+    // Update the tree by recalculating the hashes up to the root
+    let mut level_index = 0;
+    while levels[level_index].len() > 1 {
+        let mut parent_level = Vec::new();
+        let current_level = &levels[level_index];
+        for chunk in current_level.chunks(2) {
+            let hash = match chunk {
+                [left, right] => hash_children_with(left.hash, right.hash, sorted),
+                [left] => left.hash,
+                _ => unreachable!(),
+            };
+            parent_level.push(MerkleNode {
+                hash,
+                left: &chunk[0],
+                right: if chunk.len() > 1 { &chunk[1] } else { &chunk[0] },
+            });
+        }
+        if level_index + 1 < levels.len() {
+            levels[level_index + 1] = parent_level;
         } else {
-            levels.last().unwrap()[0].hash
+            levels.push(parent_level);
         }
+        level_index += 1;
+    }
+    levels.truncate(level_index + 1);
+}
+
+fn update_one_leaf(leaf_index: usize, new_leaf: Fp) -> bool {
+    LOCAL_TREE.with(|levels| {
+        update_leaf_levels(&mut levels.borrow_mut(), leaf_index, new_leaf, SORTED_CHILDREN.load(Ordering::Relaxed))
     })
 }
 
+fn update_leaf_levels(levels: &mut Vec<Vec<MerkleNode>>, leaf_index: usize, new_leaf: Fp, sorted: bool) -> bool {
+    if levels.is_empty() || leaf_index >= levels[0].len() {
+        return false;
+    }
+    levels[0][leaf_index].hash = new_leaf;
+
+    // Recompute only the hashes on the path from the leaf to the root
+    let mut index = leaf_index;
+    for level in 0..levels.len() - 1 {
+        let left = index & !1;
+        let hash = match levels[level].get(left + 1) {
+            Some(right) => hash_children_with(levels[level][left].hash, right.hash, sorted),
+            None => levels[level][left].hash,
+        };
+        index /= 2;
+        levels[level + 1][index].hash = hash;
+    }
+    true
+}
+
+fn get_root() -> Fp {
+    LOCAL_TREE.with(|levels| root_of(&levels.borrow()))
+}
+
+fn root_of(levels: &Vec<Vec<MerkleNode>>) -> Fp {
+    if levels.is_empty() {
+        Fp::zero()
+    } else {
+        levels.last().unwrap()[0].hash
+    }
+}
+
 #[no_mangle]
 pub extern "C" fn add_leaf_to_tree(new_leaf: Fp) {
     add_one_leaf(new_leaf);
 }
 
+#[no_mangle]
+pub extern "C" fn update_leaf(leaf_index: usize, new_leaf: Fp) -> usize {
+    if update_one_leaf(leaf_index, new_leaf) {
+        0
+    } else {
+        1 // Index out of range
+    }
+}
+
 #[no_mangle]
 pub extern "C" fn get_merkle_root() -> Fp {
     get_root()
@@ -397,3 +537,134 @@ pub extern "C" fn clear_merkle_tree() {
     clear_levels();
 }
 
+
+////////////////////////////////////////////////////
+// Handle API.
+//
+// The functions above work on one tree per thread, which callers that
+// cannot pin their threads, such as Go, cannot use safely. The tree_*
+// functions below work on trees addressed by a handle from tree_new
+// instead. Each tree has its own lock and child ordering, so different
+// trees can be used from different threads at once and calls on the same
+// tree are serialized. Every tree_* call other than tree_new returns
+// PATH_OK or an error code, TREE_UNKNOWN_HANDLE for a handle that was never
+// issued or has been freed.
+
+struct HandleTree {
+    levels: Vec<Vec<MerkleNode>>,
+    sorted: bool,
+}
+
+// The node pointers only ever point into the levels of the same tree, which
+// moves between threads as a whole under its lock.
+unsafe impl Send for HandleTree {}
+
+lazy_static! {
+    static ref TREES: Mutex<HashMap<u64, Arc<Mutex<HandleTree>>>> = Mutex::new(HashMap::new());
+}
+
+static NEXT_TREE: AtomicU64 = AtomicU64::new(1);
+
+fn with_tree<F: FnOnce(&mut HandleTree) -> usize>(handle: u64, f: F) -> usize {
+    let tree = match TREES.lock().unwrap().get(&handle) {
+        Some(tree) => Arc::clone(tree),
+        None => return TREE_UNKNOWN_HANDLE,
+    };
+    let mut tree = tree.lock().unwrap();
+    f(&mut tree)
+}
+
+// Returns the handle of a new, empty tree. Handles are never 0 and never
+// reused.
+#[no_mangle]
+pub extern "C" fn tree_new() -> u64 {
+    let handle = NEXT_TREE.fetch_add(1, Ordering::Relaxed);
+    let tree = HandleTree { levels: Vec::new(), sorted: false };
+    TREES.lock().unwrap().insert(handle, Arc::new(Mutex::new(tree)));
+    handle
+}
+
+// Frees the tree. Calls already holding it finish first.
+#[no_mangle]
+pub extern "C" fn tree_free(handle: u64) -> usize {
+    match TREES.lock().unwrap().remove(&handle) {
+        Some(_) => PATH_OK,
+        None => TREE_UNKNOWN_HANDLE,
+    }
+}
+
+// Sets the child ordering rule of the tree, as set_sorted_children does for
+// the thread's tree. It applies to hashes computed afterwards, so callers
+// set it before building the tree.
+#[no_mangle]
+pub extern "C" fn tree_set_sorted(handle: u64, enabled: u8) -> usize {
+    with_tree(handle, |tree| {
+        tree.sorted = enabled != 0;
+        PATH_OK
+    })
+}
+
+// Replaces the tree with the count leaves at data; a count of 0 empties it.
+#[no_mangle]
+pub extern "C" fn tree_build(handle: u64, data: *const Fp, count: usize) -> usize {
+    if count > 0 && data.is_null() {
+        return PATH_NULL_POINTER;
+    }
+    with_tree(handle, |tree| {
+        if count == 0 {
+            tree.levels.clear();
+        } else {
+            let input_slice = unsafe { slice::from_raw_parts(data, count) };
+            let sorted = tree.sorted;
+            build_levels(&mut tree.levels, input_slice.to_vec(), sorted);
+        }
+        PATH_OK
+    })
+}
+
+#[no_mangle]
+pub extern "C" fn tree_add_leaf(handle: u64, new_leaf: Fp) -> usize {
+    with_tree(handle, |tree| {
+        let sorted = tree.sorted;
+        add_leaf_levels(&mut tree.levels, new_leaf, sorted);
+        PATH_OK
+    })
+}
+
+#[no_mangle]
+pub extern "C" fn tree_update_leaf(handle: u64, leaf_index: usize, new_leaf: Fp) -> usize {
+    with_tree(handle, |tree| {
+        let sorted = tree.sorted;
+        if update_leaf_levels(&mut tree.levels, leaf_index, new_leaf, sorted) {
+            PATH_OK
+        } else {
+            PATH_INDEX_OUT_OF_RANGE
+        }
+    })
+}
+
+// Writes the root, zero for an empty tree, into *out_root.
+#[no_mangle]
+pub extern "C" fn tree_root(handle: u64, out_root: *mut Fp) -> usize {
+    if out_root.is_null() {
+        return PATH_NULL_POINTER;
+    }
+    with_tree(handle, |tree| {
+        unsafe {
+            *out_root = root_of(&tree.levels);
+        }
+        PATH_OK
+    })
+}
+
+// get_merkle_path for the tree.
+#[no_mangle]
+pub extern "C" fn tree_get_path(handle: u64, leaf_index: usize, out_path: *mut Fp, out_path_len: *mut usize) -> usize {
+    with_tree(handle, |tree| write_path(&tree.levels, leaf_index, out_path, out_path_len))
+}
+
+// tree_memory_stats for the tree.
+#[no_mangle]
+pub extern "C" fn tree_stats(handle: u64, out_nodes: *mut usize, out_bytes: *mut u64) -> usize {
+    with_tree(handle, |tree| write_memory_stats(&tree.levels, out_nodes, out_bytes))
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+    "fmt"
+    "math/rand"
+)
+
+// runLifecycle exercises the full proof lifecycle against the linked
+// library: it adds leaves leaves to an in-memory tree with AddBatch, picks
+// samples of them at random and checks that each one's proof commits to its
+// value and verifies against the root. It is the command-line counterpart
+// of TestIntegration_AddAndVerify and needs only the library the binary is
+// linked with.
+func runLifecycle(leaves, samples int, rng *rand.Rand) error {
+    if leaves <= 0 || samples < 0 || samples > leaves {
+        return fmt.Errorf("cannot sample %d of %d leaves", samples, leaves)
+    }
+
+    keys := make([][]byte, leaves)
+    values := make([][]byte, leaves)
+    for i := range keys {
+        keys[i] = []byte(fmt.Sprintf("key-%d", i))
+        value := make([]byte, fpSize)
+        copy(value, encodeUint64(uint64(i)))
+        values[i] = value
+    }
+
+    tree := NewMerkleTree(nil, WithNoPersistence())
+    if err := tree.AddBatch(keys, values); err != nil {
+        return err
+    }
+
+    for _, i := range rng.Perm(leaves)[:samples] {
+        if err := tree.CheckProof(keys[i], values[i]); err != nil {
+            return err
+        }
+    }
+    return nil
+}
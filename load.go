@@ -0,0 +1,161 @@
+package main
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "fmt"
+
+    "go.vocdoni.io/dvote/db"
+)
+
+// ErrRootMismatch is returned when the root rebuilt from the persisted
+// leaves differs from the persisted root.
+var ErrRootMismatch = errors.New("rebuilt root does not match persisted root")
+
+// LoadMerkleTree restores a tree previously written to database, rebuilds
+// the C tree from the persisted leaves and replays any insertions left in
+// the write-ahead log by a crash.
+func LoadMerkleTree(database db.Database, opts ...Option) (*MerkleTree, error) {
+    tree := NewMerkleTree(database, opts...)
+
+    rtx := tree.db.ReadTx()
+    defer rtx.Discard()
+
+    if err := tree.loadLeaves(rtx); err != nil {
+        return nil, err
+    }
+    if err := tree.loadTxLogSeq(rtx); err != nil {
+        return nil, err
+    }
+    if err := tree.rebuild(); err != nil {
+        return nil, err
+    }
+    if err := tree.ensureNodes(rtx); err != nil {
+        return nil, err
+    }
+    if err := tree.replayWAL(rtx); err != nil {
+        return nil, err
+    }
+    if err := tree.loadFinalized(rtx); err != nil {
+        return nil, err
+    }
+    tree.publishHead()
+
+    return tree, nil
+}
+
+// LoadMerkleTreeStrict is LoadMerkleTree that also checks the rebuilt root
+// against the root persisted by the last mutation and returns
+// ErrRootMismatch if they differ, which means the database is corrupt. A
+// database written before roots were persisted passes unchecked.
+func LoadMerkleTreeStrict(database db.Database, opts ...Option) (*MerkleTree, error) {
+    tree, err := LoadMerkleTree(database, opts...)
+    if err != nil {
+        return nil, err
+    }
+
+    persisted, ok, err := tree.persistedRoot()
+    if err != nil {
+        return nil, err
+    }
+    if ok && !bytes.Equal(persisted, tree.root()) {
+        return nil, ErrRootMismatch
+    }
+    return tree, nil
+}
+
+// Repair discards the in-memory state, reloads every leaf from the database,
+// rebuilds the C tree from them and persists the resulting root. Use it
+// after LoadMerkleTreeStrict reports ErrRootMismatch, once the leaf records
+// are trusted. The tree is StatusRebuilding meanwhile, and then
+// StatusHealthy, or StatusCorrupted if the repair fails.
+func (tree *MerkleTree) Repair() error {
+    tree.setStatus(StatusRebuilding)
+    if err := tree.repair(); err != nil {
+        tree.setStatus(StatusCorrupted)
+        return err
+    }
+    tree.setStatus(StatusHealthy)
+    return nil
+}
+
+func (tree *MerkleTree) repair() error {
+    rtx := tree.db.ReadTx()
+    err := tree.loadLeaves(rtx)
+    rtx.Discard()
+    if err != nil {
+        return err
+    }
+
+    if err := tree.rebuild(); err != nil {
+        return err
+    }
+    if err := tree.writeTx(tree.putRoot); err != nil {
+        return err
+    }
+    tree.publishHead()
+    return nil
+}
+
+func (tree *MerkleTree) persistedRoot() ([]byte, bool, error) {
+    rtx := tree.db.ReadTx()
+    defer rtx.Discard()
+
+    root, err := rtx.Get(keyRoot)
+    if errors.Is(err, db.ErrKeyNotFound) {
+        return nil, false, nil
+    }
+    if err != nil {
+        return nil, false, err
+    }
+    return root, true, nil
+}
+
+// loadLeaves replaces the in-memory key index and values with the leaf
+// records in the database.
+func (tree *MerkleTree) loadLeaves(rtx db.ReadTx) error {
+    var keys [][]byte
+    var indexes []int
+    err := rtx.Iterate(prefixIndex, func(key, value []byte) bool {
+        keys = append(keys, append([]byte(nil), key...))
+        indexes = append(indexes, int(binary.LittleEndian.Uint64(value)))
+        return true
+    })
+    if err != nil {
+        return err
+    }
+
+    // Without tree/size, the slots up to the highest stored index are all
+    // that can be recovered; deleted slots past it are lost.
+    size := 0
+    for _, idx := range indexes {
+        if idx+1 > size {
+            size = idx + 1
+        }
+    }
+    if sizeBytes, err := rtx.Get(keySize); err == nil {
+        size = int(binary.LittleEndian.Uint64(sizeBytes))
+    } else if !errors.Is(err, db.ErrKeyNotFound) {
+        return err
+    }
+
+    // Slots without an index record belong to deleted keys and stay nil.
+    tree.keyIndex = make(map[string]int)
+    tree.values = make([][]byte, size)
+    for i, key := range keys {
+        idx := indexes[i]
+        if idx >= size || tree.values[idx] != nil {
+            return fmt.Errorf("corrupt index %d for key %x", idx, key)
+        }
+        stored, err := rtx.Get(dbKey(prefixValue, key))
+        if err != nil {
+            return fmt.Errorf("value for key %x: %w", key, err)
+        }
+        tree.setIndex(key, idx)
+        tree.values[idx] = stored
+    }
+    tree.currentIdx = size
+
+    return nil
+}
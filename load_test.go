@@ -0,0 +1,81 @@
+package main
+
+import (
+    "bytes"
+    "errors"
+    "testing"
+)
+
+func TestLoadStrictRejectsTamperedRoot(t *testing.T) {
+    database := newTestDB(t)
+    keys, values := testLeaves(5)
+    tree := NewMerkleTree(database)
+    addAll(t, tree, keys, values)
+    want := tree.Root()
+    tree.Close()
+
+    wtx := database.WriteTx()
+    if err := wtx.Set(keyRoot, bytes.Repeat([]byte{0x42}, fpSize)); err != nil {
+        t.Fatal(err)
+    }
+    if err := wtx.Commit(); err != nil {
+        t.Fatal(err)
+    }
+    wtx.Discard()
+
+    if _, err := LoadMerkleTreeStrict(database); !errors.Is(err, ErrRootMismatch) {
+        t.Fatalf("strict load of a tampered root = %v, want ErrRootMismatch", err)
+    }
+
+    // The leaves are intact, so Repair persists their root again.
+    loaded, err := LoadMerkleTree(database)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer loaded.Close()
+    if err := loaded.Repair(); err != nil {
+        t.Fatal(err)
+    }
+    repaired, err := LoadMerkleTreeStrict(database)
+    if err != nil {
+        t.Fatalf("strict load after Repair: %v", err)
+    }
+    defer repaired.Close()
+    if !bytes.Equal(repaired.Root(), want) {
+        t.Fatalf("root after Repair %x, want %x", repaired.Root(), want)
+    }
+}
+
+func TestLoadRecoversSizeFromIndexes(t *testing.T) {
+    database := newTestDB(t)
+    keys, values := testLeaves(6)
+    tree := NewMerkleTree(database)
+    addAll(t, tree, keys[:5], values[:5])
+    want := tree.Root()
+    tree.Close()
+
+    wtx := database.WriteTx()
+    if err := wtx.Delete(keySize); err != nil {
+        t.Fatal(err)
+    }
+    if err := wtx.Commit(); err != nil {
+        t.Fatal(err)
+    }
+    wtx.Discard()
+
+    loaded, err := LoadMerkleTreeStrict(database)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer loaded.Close()
+    if !bytes.Equal(loaded.Root(), want) {
+        t.Fatalf("root without the size record %x, want %x", loaded.Root(), want)
+    }
+    index, _, err := loaded.AddWithResult(keys[5], values[5])
+    if err != nil {
+        t.Fatal(err)
+    }
+    if index != 5 {
+        t.Fatalf("next leaf got index %d, want 5", index)
+    }
+}
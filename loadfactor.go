@@ -0,0 +1,40 @@
+package main
+
+import "time"
+
+// LoadFactor returns the fraction of leaf slots that hold a deleted key.
+// Deleted slots keep their empty leaf so other indexes stay put, which
+// means they are never reclaimed; a rebuild refreshes the C tree but does
+// not lower the load factor.
+func (tree *MerkleTree) LoadFactor() float64 {
+    if len(tree.values) == 0 {
+        return 0
+    }
+    return float64(tree.deleted) / float64(len(tree.values))
+}
+
+// maybeRebuild rebuilds the C tree if the deletes since the last rebuild
+// exceed RebuildThreshold of all slots. Counting from the last rebuild
+// rather than using LoadFactor directly keeps a tree above the threshold
+// from rebuilding on every delete.
+func (tree *MerkleTree) maybeRebuild() error {
+    threshold := tree.opts.RebuildThreshold
+    if threshold <= 0 || len(tree.values) == 0 {
+        return nil
+    }
+    if float64(tree.deletesSinceRebuild)/float64(len(tree.values)) <= threshold {
+        return nil
+    }
+
+    start := time.Now()
+    if err := tree.rebuild(); err != nil {
+        return err
+    }
+    if tree.opts.Logger != nil {
+        tree.opts.Logger.Info("rebuilt C tree",
+            "leaves", len(tree.values),
+            "load_factor", tree.LoadFactor(),
+            "duration", time.Since(start))
+    }
+    return nil
+}
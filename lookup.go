@@ -0,0 +1,116 @@
+package main
+
+import (
+    "bytes"
+    "encoding/hex"
+    "errors"
+
+    "go.vocdoni.io/dvote/db"
+)
+
+// prefixLeafKey is a secondary index from leaf to key:
+// leaf:<hex of the leaf in its in-memory form> -> key. When several keys
+// share a leaf it holds the one written last.
+var prefixLeafKey = []byte("leaf:")
+
+func leafKeyKey(leaf []byte) []byte {
+    return dbKey(prefixLeafKey, []byte(hex.EncodeToString(leaf)))
+}
+
+// putLeafKey indexes key under leaf.
+func putLeafKey(txn db.WriteTx, leaf, key []byte) error {
+    return txn.Set(leafKeyKey(leaf), key)
+}
+
+// dropLeafKey removes the index entry of leaf if it points at key.
+func dropLeafKey(txn db.WriteTx, leaf, key []byte) error {
+    current, err := txn.Get(leafKeyKey(leaf))
+    if errors.Is(err, db.ErrKeyNotFound) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    if !bytes.Equal(current, key) {
+        return nil
+    }
+    return txn.Delete(leafKeyKey(leaf))
+}
+
+// KeyByLeafHash returns the key whose leaf is leafHash, given as four
+// little-endian limbs, from the leaf index kept in the database. Unlike
+// FindByLeafHash it costs one read, but it only covers leaves written since
+// the index was introduced. The key found is checked against its current
+// leaf, and a stale entry is ErrKeyNotFound. With NoPersistence it falls
+// back to FindByLeafHash.
+func (tree *MerkleTree) KeyByLeafHash(leafHash [4]uint64) ([]byte, error) {
+    if tree.opts.NoPersistence {
+        key, _, err := tree.FindByLeafHash(leafHash)
+        return key, err
+    }
+
+    rtx := tree.db.ReadTx()
+    defer rtx.Discard()
+    key, err := rtx.Get(leafKeyKey(limbsToBytes(leafHash)))
+    if errors.Is(err, db.ErrKeyNotFound) {
+        return nil, ErrKeyNotFound
+    }
+    if err != nil {
+        return nil, err
+    }
+    key = append([]byte(nil), key...)
+
+    // The index holds the key that last wrote the leaf, which may since
+    // have moved to another leaf or been written by another MerkleTree on
+    // the database; only a key whose current leaf matches is returned.
+    idx, exists, err := tree.indexOf(key)
+    if err != nil {
+        return nil, err
+    }
+    if !exists || idx >= len(tree.values) || tree.values[idx] == nil {
+        return nil, ErrKeyNotFound
+    }
+    leaf, err := tree.storedLeafBytes(tree.values[idx])
+    if err != nil {
+        return nil, err
+    }
+    if !bytes.Equal(leaf, limbsToBytes(leafHash)) {
+        return nil, ErrKeyNotFound
+    }
+    return key, nil
+}
+
+// FindByLeafHash returns the key and index of the first leaf equal to hash,
+// such as a nullifier known only by its hash. It scans every leaf, which is
+// acceptable for sets up to around 10^5 leaves.
+func (tree *MerkleTree) FindByLeafHash(hash [4]uint64) (key []byte, idx int, err error) {
+    want := limbsToBytes(hash)
+
+    for i, stored := range tree.values {
+        if stored == nil {
+            continue
+        }
+        leaf, err := tree.storedLeafBytes(stored)
+        if err != nil {
+            return nil, 0, err
+        }
+        if !bytes.Equal(leaf, want) {
+            continue
+        }
+        var found []byte
+        err = tree.eachIndex(func(k []byte, kIdx int) bool {
+            if kIdx == i {
+                found = k
+            }
+            return found == nil
+        })
+        if err != nil {
+            return nil, 0, err
+        }
+        if found != nil {
+            return found, i, nil
+        }
+    }
+
+    return nil, 0, ErrKeyNotFound
+}
@@ -0,0 +1,26 @@
+package main
+
+import "fmt"
+
+// Sign authenticates the current root with a shared secret, returning the
+// 32-byte MAC Poseidon(root, secret). The secret is four little-endian
+// limbs; keep it out of the tree and the database. This suits a single
+// server vouching for its own roots, not third-party verification.
+func (tree *MerkleTree) Sign(secret [4]uint64) ([]byte, error) {
+    return HashTwo(tree.root(), limbsToBytes(secret))
+}
+
+// VerifyTreeMAC reports whether mac is Sign's MAC of root under secret.
+// root, mac and secret are field elements in their in-memory form, the
+// secret's limbs laid out little-endian one after another. The root is not
+// affected by WithByteOrder.
+func VerifyTreeMAC(root, mac, secret []byte) (bool, error) {
+    if len(root) != fpSize || len(mac) != fpSize || len(secret) != fpSize {
+        return false, fmt.Errorf("root, mac and secret must be %d bytes", fpSize)
+    }
+    want, err := HashTwo(root, secret)
+    if err != nil {
+        return false, err
+    }
+    return fpEqual(want, mac), nil
+}
@@ -21,133 +21,1642 @@ package main
 //
 // MerkleNode* create_merkle_tree(const Fp* data, size_t count);
 // void add_leaf_to_tree(Fp new_leaf);
+// size_t update_leaf(size_t leaf_index, Fp new_leaf);
 // Fp get_merkle_root();
 // void clear_merkle_tree();
-// unsigned int get_merkle_path(size_t leaf_index, Fp* out_path, size_t* out_path_len);
+// void set_sorted_children(uint8_t enabled);
+// const char* library_version();
+// size_t get_merkle_path(size_t leaf_index, Fp* out_path, size_t* out_path_len);
+// size_t tree_memory_stats(size_t* out_nodes, uint64_t* out_bytes);
+//
+// uint64_t tree_new();
+// size_t tree_free(uint64_t handle);
+// size_t tree_set_sorted(uint64_t handle, uint8_t enabled);
+// size_t tree_build(uint64_t handle, const Fp* data, size_t count);
+// size_t tree_add_leaf(uint64_t handle, Fp new_leaf);
+// size_t tree_update_leaf(uint64_t handle, size_t leaf_index, Fp new_leaf);
+// size_t tree_root(uint64_t handle, Fp* out_root);
+// size_t tree_get_path(uint64_t handle, size_t leaf_index, Fp* out_path, size_t* out_path_len);
+// size_t tree_stats(uint64_t handle, size_t* out_nodes, uint64_t* out_bytes);
 import "C"
 import (
+    "bytes"
     "encoding/binary"
     "errors"
     "fmt"
+    "math/big"
+    "os"
+    "runtime"
+    "sync"
+    "sync/atomic"
     "unsafe"
 
-    "go.vocdoni.io/dvote/db"
-    "go.vocdoni.io/dvote/db/badgerdb"
-)
+    "go.vocdoni.io/dvote/db"
+    "go.vocdoni.io/dvote/db/badgerdb"
+)
+
+// LeafKey is the key a leaf is stored under. It is a distinct type from
+// LeafValue so that the argument order of Add reads clearly at call sites.
+type LeafKey []byte
+
+// LeafValue is the value committed to by a leaf.
+type LeafValue []byte
+
+type MerkleTree struct {
+    db         db.Database
+    opts       config
+    keyIndex   map[string]int
+    values     [][]byte
+    currentIdx int
+    walSeq     uint64
+    txLogSeq   uint64
+    versions   rootRing
+
+    // deleted counts the deleted slots in values; deletesSinceRebuild
+    // those deleted since rebuild last ran. See LoadFactor.
+    deleted             int
+    deletesSinceRebuild int
+
+    // pending holds the optimistic adds not yet flushed, and
+    // pendingPrevRoot the root before the first of them; see Flush.
+    pending         []pendingAdd
+    pendingPrevRoot []byte
+
+    // emptyRoot caches the root of the tree while it has no leaves; see
+    // root. It is atomic because root runs on the read paths, which a
+    // read-only tree serves from several goroutines.
+    emptyRoot atomic.Pointer[[]byte]
+
+    // cTree is the handle of this tree's tree in lib.rs, 0 until rebuild
+    // first creates it; see useCTree.
+    cTree C.uint64_t
+
+    // readOnly is set for trees served from a snapshot and finalized by
+    // Finalize; see checkWritable.
+    readOnly  bool
+    finalized bool
+
+    stats     treeStats
+    status    atomic.Int32 // a TreeStatus
+    head      treeHead
+    preimages *PreimageRegistry
+    bloom     *BloomFilter
+    proofLRU  *proofCache
+
+    // keyHashes caches HashBytes of the keys given to PartialUpdate.
+    keyHashes map[string][]byte
+}
+
+// Database layout. Every key is namespaced so records of different kinds
+// can be iterated independently.
+var (
+    prefixIndex = []byte("idx/") // idx/<key> -> leaf index, uint64 little-endian
+    prefixValue = []byte("val/") // val/<key> -> stored value
+    prefixWAL   = []byte("wal/") // wal/<seq> -> pending insertion, see wal.go
+
+    keySize = []byte("tree/size") // number of leaf slots, including deleted ones
+    keyRoot = []byte("tree/root") // root after the last committed mutation
+)
+
+// fpSize is the size in bytes of a field element as laid out in C.Fp.
+const fpSize = 32
+
+// fieldModulus is the modulus of the Pasta Fp field used by lib.rs. It
+// matches fp.Modulus.
+var fieldModulus, _ = new(big.Int).SetString("40000000000000000000000000000000224698fc094cf91b992d30ed00000001", 16)
+
+func dbKey(prefix, key []byte) []byte {
+    out := make([]byte, 0, len(prefix)+len(key))
+    out = append(out, prefix...)
+    return append(out, key...)
+}
+
+func fpToBytes(fp *C.Fp) []byte {
+    size := unsafe.Sizeof(*fp)
+    byteSlice := (*[1 << 30]byte)(unsafe.Pointer(fp))[:size:size]
+    return byteSlice
+}
+
+// bytesToFp returns the field element laid out in value, which must be
+// exactly fpSize bytes; anything else would have the cast below read past
+// the slice or drop bytes. The limbs are copied, so value may be reused.
+func bytesToFp(value []byte) (C.Fp, error) {
+    if len(value) != fpSize {
+        return C.Fp{}, fmt.Errorf("field element is %d bytes, want %d", len(value), fpSize)
+    }
+    return *(*C.Fp)(unsafe.Pointer(&value[0])), nil
+}
+
+// reduceFp interprets b as a little-endian integer and reduces it modulo
+// the field modulus, so the result is always a valid field element.
+func reduceFp(b []byte) C.Fp {
+    n := leBytesToBigInt(b)
+    fp, _ := bytesToFp(bigIntToLEBytes(n.Mod(n, fieldModulus))) // always fpSize bytes
+    return fp
+}
+
+// valueToFp converts a value of at most fpSize bytes into a field element.
+func valueToFp(value []byte) (C.Fp, error) {
+    if len(value) > fpSize {
+        return C.Fp{}, fmt.Errorf("value is %d bytes, at most %d fit: %w", len(value), fpSize, ErrValueTooLarge)
+    }
+    return reduceFp(value), nil
+}
+
+// HashOne returns the Poseidon hash of value as a single field element.
+func HashOne(value []byte) ([]byte, error) {
+    fp, err := valueToFp(value)
+    if err != nil {
+        return nil, err
+    }
+    cgoCalls.hashes.Add(1)
+    h := C.hashp(fp)
+    return fpToBytes(&h), nil
+}
+
+// HashTwo returns the Poseidon hash of the field elements a and b.
+func HashTwo(a, b []byte) ([]byte, error) {
+    fa, err := valueToFp(a)
+    if err != nil {
+        return nil, err
+    }
+    fb, err := valueToFp(b)
+    if err != nil {
+        return nil, err
+    }
+    var h C.Fp
+    cgoCalls.hashes.Add(1)
+    C.hashpd(&h, fa, fb)
+    return fpToBytes(&h), nil
+}
+
+// hashChildren returns the parent of left and right. With sorted set the
+// child whose bytes compare smaller is hashed first, matching lib.rs under
+// WithSortedChildren.
+func hashChildren(left, right []byte, sorted bool) ([]byte, error) {
+    if sorted && bytes.Compare(right, left) < 0 {
+        left, right = right, left
+    }
+    return HashTwo(left, right)
+}
+
+// LibraryVersion returns the version of the linked libsimple_example, as
+// set in its Cargo.toml.
+func LibraryVersion() string {
+    return C.GoString(C.library_version())
+}
+
+func NewMerkleTree(database db.Database, opts ...Option) *MerkleTree {
+    tree := &MerkleTree{
+        db:       database,
+        keyIndex: make(map[string]int),
+        values:   make([][]byte, 0),
+    }
+    for _, opt := range opts {
+        opt(&tree.opts)
+    }
+    if len(tree.opts.Prefix) > 0 && database != nil {
+        tree.db = db.NewPrefixedDatabase(tree.opts.Prefix, database)
+    }
+    if tree.opts.StorePreimages {
+        tree.preimages = NewPreimageRegistry(tree.db)
+    }
+    if tree.opts.UseBloomFilter {
+        n := tree.opts.BloomExpectedKeys
+        if n <= 0 {
+            n = defaultBloomExpectedKeys
+        }
+        tree.bloom = NewBloomFilter(n, tree.opts.BloomFalsePositiveRate)
+    }
+    if tree.opts.ProofLRUSize > 0 {
+        tree.proofLRU = newProofCache(tree.opts.ProofLRUSize)
+    }
+    if tree.opts.AutoParallelism && tree.opts.Parallelism <= 0 {
+        tree.opts.Parallelism = tree.tuneParallelism()
+    }
+    return tree
+}
+
+// leaf returns the field element inserted into the C tree for value and the
+// bytes persisted for it. With StoreHashedValues both are the hash of value,
+// HashOne unless set WithHasher; with KeyedLeaves both are the KeyedLeaf of
+// key and value.
+func (tree *MerkleTree) leaf(key, value []byte) (C.Fp, []byte, error) {
+    if tree.opts.WideLeaves > 1 {
+        leaf, err := tree.wideLeafBytes(value)
+        if err != nil {
+            return C.Fp{}, nil, err
+        }
+        if tree.opts.KeyedLeaves {
+            if leaf, err = KeyedLeaf(key, leaf); err != nil {
+                return C.Fp{}, nil, err
+            }
+        }
+        fp, err := bytesToFp(leaf)
+        if err != nil {
+            return C.Fp{}, nil, err
+        }
+        if tree.opts.StoreHashedValues {
+            return fp, leaf, nil
+        }
+        return fp, value, nil
+    }
+
+    if tree.opts.StoreHashedValues {
+        var h []byte
+        var err error
+        if tree.opts.KeyedLeaves {
+            h, err = KeyedLeaf(key, tree.fromAPI(value))
+        } else {
+            h, err = tree.hashLeaf(tree.fromAPI(value))
+        }
+        if err != nil {
+            return C.Fp{}, nil, err
+        }
+        fp, err := bytesToFp(h)
+        if err != nil {
+            return C.Fp{}, nil, err
+        }
+        return fp, h, nil
+    }
+    fp, err := tree.valueLeaf(value)
+    if err != nil {
+        return C.Fp{}, nil, err
+    }
+    return fp, value, nil
+}
+
+// valueLeaf returns the leaf of a value stored as is. A value of up to
+// fpSize bytes is reduced into a field element; a longer one is an error
+// unless AutoHashOversize is set, in which case it is hashed with
+// HashBytes.
+func (tree *MerkleTree) valueLeaf(value []byte) (C.Fp, error) {
+    if len(value) <= fpSize || !tree.opts.AutoHashOversize {
+        return valueToFp(tree.fromAPI(value))
+    }
+    h, err := HashBytes(value)
+    if err != nil {
+        return C.Fp{}, err
+    }
+    return bytesToFp(h)
+}
+
+// storedLeaf recomputes the leaf for a value as it is kept in tree.values.
+// A nil value marks a deleted leaf, which holds the empty leaf (zero unless
+// set with WithEmptyLeaf), and a raw leaf in a wide tree holds itself; see
+// rawStored.
+func (tree *MerkleTree) storedLeaf(stored []byte) (C.Fp, error) {
+    if stored == nil {
+        return valueToFp(tree.fromAPI(tree.opts.EmptyLeaf))
+    }
+    if tree.opts.StoreHashedValues {
+        return bytesToFp(stored)
+    }
+    if tree.isWideRaw(stored) {
+        return bytesToFp(tree.fromAPI(stored[:fpSize]))
+    }
+    if tree.opts.WideLeaves > 1 {
+        leaf, err := tree.wideLeafBytes(stored)
+        if err != nil {
+            return C.Fp{}, err
+        }
+        return bytesToFp(leaf)
+    }
+    return tree.valueLeaf(stored)
+}
+
+// storedLeafBytes is storedLeaf returning the leaf in its in-memory form.
+func (tree *MerkleTree) storedLeafBytes(stored []byte) ([]byte, error) {
+    leaf, err := tree.storedLeaf(stored)
+    if err != nil {
+        return nil, err
+    }
+    return fpToBytes(&leaf), nil
+}
+
+func encodeUint64(v uint64) []byte {
+    out := make([]byte, 8)
+    binary.LittleEndian.PutUint64(out, v)
+    return out
+}
+
+// putLeafRecord writes the index and value records of the leaf at idx and
+// grows the persisted tree size to cover it.
+func putLeafRecord(txn db.WriteTx, key, stored []byte, idx int) error {
+    if err := txn.Set(dbKey(prefixIndex, key), encodeUint64(uint64(idx))); err != nil {
+        return err
+    }
+    if err := txn.Set(dbKey(prefixValue, key), stored); err != nil {
+        return err
+    }
+    return txn.Set(keySize, encodeUint64(uint64(idx+1)))
+}
+
+func (tree *MerkleTree) Add(key LeafKey, value LeafValue) (err error) {
+    defer tree.stats.record(&tree.stats.adds, &err)
+
+    leaf, stored, err := tree.leaf(key, value)
+    if err != nil {
+        return err
+    }
+    return tree.addLeaf(key, leaf, stored, value, nil)
+}
+
+// AddWithResult is Add that also returns the index assigned to key and the
+// root after the insertion.
+func (tree *MerkleTree) AddWithResult(key, value []byte) (index uint, newRoot []byte, err error) {
+    if err := tree.Add(key, value); err != nil {
+        return 0, nil, err
+    }
+    return uint(tree.currentIdx - 1), tree.Root(), nil
+}
+
+// AddRawLeaf adds key with leafHash, a field element in its in-memory form
+// such as a Poseidon hash computed elsewhere, as its leaf. The hash is
+// inserted as is, without hashing, and is also what Get returns for key.
+func (tree *MerkleTree) AddRawLeaf(key []byte, leafHash [32]byte) (err error) {
+    defer tree.stats.record(&tree.stats.adds, &err)
+
+    return tree.addRawLeaf(key, leafHash, nil)
+}
+
+// addRawLeaf is AddRawLeaf running extra, if not nil, in the transaction
+// that persists the leaf.
+func (tree *MerkleTree) addRawLeaf(key []byte, leafHash [32]byte, extra func(txn db.WriteTx) error) error {
+    leaf := tree.fromAPI(append([]byte(nil), leafHash[:]...))
+    if leBytesToBigInt(leaf).Cmp(fieldModulus) >= 0 {
+        return errors.New("leaf hash is not a valid field element")
+    }
+    stored := tree.rawStored(leafHash[:])
+    fp, err := bytesToFp(leaf)
+    if err != nil {
+        return err
+    }
+    return tree.addLeaf(key, fp, stored, nil, extra)
+}
+
+// addLeaf appends leaf for key and persists stored as its value. A non-nil
+// preimage is recorded with WithStorePreimages, and a non-nil extra runs in
+// the same database transaction.
+func (tree *MerkleTree) addLeaf(key []byte, leaf C.Fp, stored, preimage []byte, extra func(txn db.WriteTx) error) error {
+    if err := tree.checkMutable(); err != nil {
+        return err
+    }
+    _, exists, err := tree.indexOf(key)
+    if err != nil {
+        return err
+    }
+    if exists {
+        return ErrKeyExists
+    }
+    if err := tree.checkCapacity(1); err != nil {
+        return err
+    }
+
+    seqs, err := tree.logPending([][]byte{key}, [][]byte{stored})
+    if err != nil {
+        return err
+    }
+
+    entry := tree.newTxLog(TxOpAdd, key, tree.root())
+    idx, err := tree.insertLeaf(key, leaf, stored)
+    if err != nil {
+        tree.unwindLeaves(idx, [][]byte{key}, seqs)
+        return err
+    }
+
+    err = tree.writeTx(func(txn db.WriteTx) error {
+        if err := putLeafRecord(txn, key, stored, idx); err != nil {
+            return err
+        }
+        if err := putLeafKey(txn, fpToBytes(&leaf), key); err != nil {
+            return err
+        }
+        if err := tree.putTxLog(txn, entry); err != nil {
+            return err
+        }
+        if preimage != nil {
+            if err := tree.putPreimage(txn, fpToBytes(&leaf), preimage); err != nil {
+                return err
+            }
+        }
+        if extra != nil {
+            if err := extra(txn); err != nil {
+                return err
+            }
+        }
+        if err := tree.putNodes(txn, idx, idx+1); err != nil {
+            return err
+        }
+        if err := tree.putRoot(txn); err != nil {
+            return err
+        }
+        return clearPending(txn, seqs)
+    })
+    if err != nil {
+        tree.unwindLeaves(idx, [][]byte{key}, seqs)
+        return err
+    }
+    tree.advanceTxLog(entry)
+
+    tree.reportProgress()
+    return nil
+}
+
+// AddIfAbsent adds key unless it is already present. Re-adding an existing
+// key with the same value is not an error and reports inserted as false;
+// re-adding it with a different value is. Every call counts as an add in
+// Stats, or as an error.
+func (tree *MerkleTree) AddIfAbsent(key, value []byte) (inserted bool, err error) {
+    defer tree.stats.record(&tree.stats.adds, &err)
+
+    leaf, stored, err := tree.leaf(key, value)
+    if err != nil {
+        return false, err
+    }
+    idx, exists, err := tree.indexOf(key)
+    if err != nil {
+        return false, err
+    }
+    if !exists {
+        if err := tree.addLeaf(key, leaf, stored, value, nil); err != nil {
+            return false, err
+        }
+        return true, nil
+    }
+    if !bytes.Equal(stored, tree.values[idx]) {
+        return false, fmt.Errorf("%w with a different value", ErrKeyExists)
+    }
+    return false, nil
+}
+
+// AddOrUpdate adds key, or replaces its value if it is already in the tree.
+// updated reports which of the two happened.
+func (tree *MerkleTree) AddOrUpdate(key, value []byte) (updated bool, err error) {
+    _, exists, err := tree.indexOf(key)
+    if err != nil {
+        return false, err
+    }
+    if exists {
+        return true, tree.Update(key, value)
+    }
+    return false, tree.Add(key, value)
+}
+
+// defaultMaxDepth is the depth bound of a tree without WithMaxDepth.
+// maxDepthLimit keeps 1<<depth within an int and the path of the deepest
+// leaf within maxPathLength.
+const (
+    defaultMaxDepth = 32
+    maxDepthLimit   = 62
+)
+
+func (tree *MerkleTree) maxDepth() int {
+    depth := tree.opts.MaxDepth
+    if depth <= 0 {
+        return defaultMaxDepth
+    }
+    if depth > maxDepthLimit {
+        return maxDepthLimit
+    }
+    return depth
+}
+
+// MaxCapacity returns the number of leaf slots the tree can hold, deleted
+// ones included: 2^depth for the configured depth.
+func (tree *MerkleTree) MaxCapacity() int {
+    return 1 << tree.maxDepth()
+}
+
+// Depth returns the depth bound of the tree, log2 of MaxCapacity. With
+// AutoGrow it increases as the tree fills up. The C tree itself is only as
+// tall as its leaves need, so leaves, roots and proofs do not depend on it.
+func (tree *MerkleTree) Depth() int {
+    return tree.maxDepth()
+}
+
+// checkCapacity returns ErrTreeFull if n more leaves do not fit. With
+// AutoGrow it first deepens the tree one level at a time, doubling
+// MaxCapacity each time, until they do or maxDepthLimit is reached.
+func (tree *MerkleTree) checkCapacity(n int) error {
+    for tree.opts.AutoGrow && tree.currentIdx+n > tree.MaxCapacity() && tree.maxDepth() < maxDepthLimit {
+        tree.opts.MaxDepth = tree.maxDepth() + 1
+    }
+    if tree.currentIdx+n > tree.MaxCapacity() {
+        return fmt.Errorf("adding %d leaves to %d: %w", n, tree.currentIdx, ErrTreeFull)
+    }
+    return nil
+}
+
+// insertLeaf appends a leaf to the in-memory state and the C tree and
+// returns its index. It does not touch the database.
+func (tree *MerkleTree) insertLeaf(key []byte, leaf C.Fp, stored []byte) (int, error) {
+    idx := tree.currentIdx
+    tree.setIndex(key, idx)
+    tree.values = append(tree.values, stored)
+    tree.currentIdx++
+
+    if tree.cTree == 0 {
+        // Rebuilding from tree.values already includes the new leaf.
+        return idx, tree.rebuild()
+    }
+    cgoCalls.inserts.Add(1)
+    if ret := C.tree_add_leaf(tree.cTree, leaf); ret != 0 {
+        return idx, cTreeError("tree_add_leaf", ret)
+    }
+
+    return idx, nil
+}
+
+// unwindLeaves removes the leaves insertLeaf or insertBatch appended for
+// keys from index from on, after they failed to reach the database, so
+// memory and the C tree keep matching the database. Their WAL entries,
+// seqs, are dropped too, so LoadMerkleTree does not complete the insertions
+// later; if that write fails as well, the entries stay and are replayed.
+func (tree *MerkleTree) unwindLeaves(from int, keys [][]byte, seqs []uint64) {
+    for _, key := range keys {
+        tree.dropIndex(key)
+    }
+    tree.values = tree.values[:from]
+    tree.currentIdx = from
+    if err := tree.rebuild(); err != nil {
+        // useCTree rebuilds it on the next use.
+        tree.freeCTree()
+    }
+    tree.writeTx(func(txn db.WriteTx) error {
+        return clearPending(txn, seqs)
+    })
+}
+
+// Update replaces the value of an existing key. The key keeps its leaf
+// index.
+func (tree *MerkleTree) Update(key, value []byte) error {
+    idx, exists, err := tree.indexOf(key)
+    if err != nil {
+        return err
+    }
+    if !exists {
+        return ErrKeyNotFound
+    }
+    return tree.replaceLeaf(idx, key, value)
+}
+
+// PartialUpdate is Update for keys whose value changes often. With
+// KeyedLeaves the leaf is HashTwo(HashBytes(key), value); the key hash is
+// computed on the first PartialUpdate of a key and kept, so later ones only
+// hash the new value into the leaf. The result is the leaf Update gives.
+// Without KeyedLeaves the key is not part of the leaf and PartialUpdate is
+// Update.
+func (tree *MerkleTree) PartialUpdate(key, newValue []byte) error {
+    idx, exists, err := tree.indexOf(key)
+    if err != nil {
+        return err
+    }
+    if !exists {
+        return ErrKeyNotFound
+    }
+    if !tree.opts.KeyedLeaves {
+        return tree.replaceLeaf(idx, key, newValue)
+    }
+
+    keyHash, ok := tree.keyHashes[string(key)]
+    if !ok {
+        if keyHash, err = HashBytes(key); err != nil {
+            return err
+        }
+        if tree.keyHashes == nil {
+            tree.keyHashes = make(map[string][]byte)
+        }
+        tree.keyHashes[string(key)] = keyHash
+    }
+
+    value := tree.fromAPI(newValue)
+    if tree.opts.WideLeaves > 1 {
+        if value, err = tree.wideLeafBytes(newValue); err != nil {
+            return err
+        }
+    }
+    h, err := HashTwo(keyHash, value)
+    if err != nil {
+        return err
+    }
+    leaf, err := bytesToFp(h)
+    if err != nil {
+        return err
+    }
+    return tree.replaceLeafWith(idx, key, leaf, h, func(txn db.WriteTx) error {
+        return tree.putPreimage(txn, h, newValue)
+    })
+}
+
+func (tree *MerkleTree) replaceLeaf(idx int, key, value []byte) error {
+    leaf, stored, err := tree.leaf(key, value)
+    if err != nil {
+        return err
+    }
+
+    return tree.replaceLeafWith(idx, key, leaf, stored, func(txn db.WriteTx) error {
+        return tree.putPreimage(txn, fpToBytes(&leaf), value)
+    })
+}
+
+// replaceLeafWith sets the leaf at idx to leaf and persists stored as the
+// value of key. extra runs in the same database transaction.
+func (tree *MerkleTree) replaceLeafWith(idx int, key []byte, leaf C.Fp, stored []byte, extra func(txn db.WriteTx) error) error {
+    if err := tree.checkMutable(); err != nil {
+        return err
+    }
+    entry := tree.newTxLog(TxOpUpdate, key, tree.root())
+    prev := tree.values[idx]
+    prevLeaf, err := tree.storedLeafBytes(prev)
+    if err != nil {
+        return err
+    }
+    if err := tree.setLeaf(idx, stored, leaf); err != nil {
+        return err
+    }
+
+    err = tree.writeTx(func(txn db.WriteTx) error {
+        if err := extra(txn); err != nil {
+            return err
+        }
+        if err := dropLeafKey(txn, prevLeaf, key); err != nil {
+            return err
+        }
+        if err := putLeafKey(txn, fpToBytes(&leaf), key); err != nil {
+            return err
+        }
+        if err := txn.Set(dbKey(prefixValue, key), stored); err != nil {
+            return err
+        }
+        if err := tree.putTxLog(txn, entry); err != nil {
+            return err
+        }
+        if err := tree.putNodes(txn, idx, idx+1); err != nil {
+            return err
+        }
+        return tree.putRoot(txn)
+    })
+    if err != nil {
+        tree.restoreLeaf(idx, prev)
+        return err
+    }
+    tree.advanceTxLog(entry)
+
+    return nil
+}
+
+// Delete removes key from the tree. Its leaf is set to the empty leaf rather
+// than removed, so the indexes of all other leaves are unchanged.
+func (tree *MerkleTree) Delete(key []byte) error {
+    if err := tree.checkMutable(); err != nil {
+        return err
+    }
+    idx, exists, err := tree.indexOf(key)
+    if err != nil {
+        return err
+    }
+    if !exists {
+        return ErrKeyNotFound
+    }
+
+    empty, err := tree.storedLeaf(nil)
+    if err != nil {
+        return err
+    }
+
+    entry := tree.newTxLog(TxOpDelete, key, tree.root())
+    prev := tree.values[idx]
+    prevLeaf, err := tree.storedLeafBytes(prev)
+    if err != nil {
+        return err
+    }
+    if err := tree.setLeaf(idx, nil, empty); err != nil {
+        return err
+    }
+
+    err = tree.writeTx(func(txn db.WriteTx) error {
+        if err := dropLeafKey(txn, prevLeaf, key); err != nil {
+            return err
+        }
+        if err := txn.Delete(dbKey(prefixIndex, key)); err != nil {
+            return err
+        }
+        if err := txn.Delete(dbKey(prefixValue, key)); err != nil {
+            return err
+        }
+        if err := txn.Delete(dbKey(prefixMeta, key)); err != nil {
+            return err
+        }
+        if err := tree.putTxLog(txn, entry); err != nil {
+            return err
+        }
+        if err := tree.putNodes(txn, idx, idx+1); err != nil {
+            return err
+        }
+        return tree.putRoot(txn)
+    })
+    if err != nil {
+        tree.restoreLeaf(idx, prev)
+        return err
+    }
+    tree.advanceTxLog(entry)
+
+    tree.dropIndex(key)
+    tree.deleted++
+    tree.deletesSinceRebuild++
+    return tree.maybeRebuild()
+}
+
+// DeleteByIndex is Delete for the key holding the leaf at index, for
+// callers that track leaves by position. It returns ErrIndexOutOfRange past
+// the last slot and ErrKeyNotFound for a slot already deleted.
+func (tree *MerkleTree) DeleteByIndex(index uint) error {
+    if index >= uint(len(tree.values)) {
+        return fmt.Errorf("leaf %d of %d: %w", index, len(tree.values), ErrIndexOutOfRange)
+    }
+    key, err := tree.keyAt(int(index))
+    if err != nil {
+        return fmt.Errorf("leaf %d: %w", index, err)
+    }
+    return tree.Delete(key)
+}
+
+// setLeaf replaces the leaf at idx in memory and in the C tree.
+func (tree *MerkleTree) setLeaf(idx int, stored []byte, leaf C.Fp) error {
+    if err := tree.useCTree(); err != nil {
+        return err
+    }
+    cgoCalls.inserts.Add(1)
+    if ret := C.tree_update_leaf(tree.cTree, C.size_t(idx), leaf); ret != 0 {
+        return fmt.Errorf("leaf %d: %w", idx, cTreeError("tree_update_leaf", ret))
+    }
+    tree.values[idx] = stored
+    return nil
+}
+
+// restoreLeaf puts back the leaf at idx after a failed database write, so
+// memory and the C tree keep matching the database.
+func (tree *MerkleTree) restoreLeaf(idx int, stored []byte) {
+    if leaf, err := tree.storedLeaf(stored); err == nil {
+        tree.setLeaf(idx, stored, leaf)
+    }
+}
 
-type MerkleTree struct {
-    db         db.Database
-    keyIndex   map[string]int
-    values     [][]byte
-    currentIdx int
+// putRoot persists the current root, which LoadMerkleTreeStrict checks the
+// rebuilt tree against.
+func (tree *MerkleTree) putRoot(txn db.WriteTx) error {
+    return txn.Set(keyRoot, tree.root())
 }
 
-func fpToBytes(fp *C.Fp) []byte {
-    size := unsafe.Sizeof(*fp)
-    byteSlice := (*[1 << 30]byte)(unsafe.Pointer(fp))[:size:size]
-    return byteSlice
+// Get returns the value stored for key. With StoreHashedValues this is
+// HashOne of the original value; see GetHash.
+func (tree *MerkleTree) Get(key []byte) (_ []byte, err error) {
+    defer tree.stats.record(&tree.stats.gets, &err)
+
+    idx, exists, err := tree.indexOf(key)
+    if err != nil {
+        return nil, err
+    }
+    if !exists {
+        return nil, ErrKeyNotFound
+    }
+    return tree.apiValue(tree.values[idx]), nil
 }
 
-func bytesToFp(value []byte) C.Fp {
-    fp := (*C.Fp)(unsafe.Pointer(&value[0]))
-    return *fp
+// apiValue returns the value Get returns for stored.
+func (tree *MerkleTree) apiValue(stored []byte) []byte {
+    if tree.opts.StoreHashedValues {
+        return tree.toAPI(stored)
+    }
+    if tree.isWideRaw(stored) {
+        return stored[:fpSize:fpSize]
+    }
+    return stored
 }
 
-func NewMerkleTree(database db.Database) *MerkleTree {
-    return &MerkleTree{
-        db:       database,
-        keyIndex: make(map[string]int),
-        values:   make([][]byte, 0),
+// GetHash returns HashOne of the value stored for key, whether or not the
+// tree keeps the raw value.
+func (tree *MerkleTree) GetHash(key []byte) ([]byte, error) {
+    stored, err := tree.Get(key)
+    if err != nil {
+        return nil, err
+    }
+    if tree.opts.StoreHashedValues {
+        return stored, nil
+    }
+    h, err := tree.hashLeaf(tree.fromAPI(stored))
+    if err != nil {
+        return nil, err
+    }
+    return tree.toAPI(h), nil
+}
+
+// LeafHash returns the leaf committed for key, the field element a
+// verifier starts folding from: the value reduced into the field, or its
+// hash with StoreHashedValues. It is in its in-memory form, as in
+// MerkleProof.Leaf.
+func (tree *MerkleTree) LeafHash(key []byte) ([]byte, error) {
+    idx, exists, err := tree.indexOf(key)
+    if err != nil {
+        return nil, err
     }
+    if !exists {
+        return nil, ErrKeyNotFound
+    }
+    return tree.storedLeafBytes(tree.values[idx])
 }
 
-func (tree *MerkleTree) Add(key, value []byte) error {
-    keyStr := string(key)
-    if _, exists := tree.keyIndex[keyStr]; exists {
-        return errors.New("key already exists")
+// CheckProof generates the proof of key and checks that it commits to value
+// and verifies against the current root. The error names the key and root
+// in hex, so it can be reported as is; poseidontreetest.AssertProof wraps
+// it for tests.
+func (tree *MerkleTree) CheckProof(key, value []byte) (err error) {
+    defer tree.stats.record(&tree.stats.verifications, &err)
+
+    root := tree.root()
+    proof, err := tree.GenProof(key)
+    if err != nil {
+        return fmt.Errorf("proof of key %x at root %x: %w", key, root, err)
+    }
+    want, _, err := tree.leaf(key, value)
+    if err != nil {
+        return fmt.Errorf("proof of key %x at root %x: %w", key, root, err)
+    }
+    if !bytes.Equal(proof.Leaf, fpToBytes(&want)) {
+        return fmt.Errorf("proof of key %x at root %x: leaf %x does not commit to value %x", key, root, proof.Leaf, value)
     }
+    ok, err := VerifyProof(root, proof)
+    if err != nil {
+        return fmt.Errorf("proof of key %x at root %x: %w", key, root, err)
+    }
+    if !ok {
+        return fmt.Errorf("proof of key %x does not verify against root %x", key, root)
+    }
+    return nil
+}
 
-    tree.keyIndex[keyStr] = tree.currentIdx
-    tree.values = append(tree.values, value)
-    tree.currentIdx++
+// GetAll returns every key in the tree mapped to its value, as Get would
+// return it, for debugging and tests. With a database the keys are read
+// from it, so records written by another MerkleTree on the same database
+// are included too: a key whose index lies beyond the leaves held in memory
+// gets its value from the database.
+func (tree *MerkleTree) GetAll() (map[string][]byte, error) {
+    all := make(map[string][]byte)
 
-    fmt.Printf("Adding leaf: ")
-    logFp(bytesToFp(value))
+    if tree.opts.NoPersistence {
+        err := tree.eachIndex(func(key []byte, idx int) bool {
+            all[string(key)] = tree.apiValue(tree.values[idx])
+            return true
+        })
+        return all, err
+    }
 
-    C.add_leaf_to_tree(bytesToFp(value))
+    rtx := tree.db.ReadTx()
+    defer rtx.Discard()
 
-    txn := tree.db.WriteTx()
-    defer txn.Discard()
-    indexBytes := make([]byte, 8)
-    binary.LittleEndian.PutUint64(indexBytes, uint64(tree.keyIndex[keyStr]))
-    if err := txn.Set(key, indexBytes); err != nil {
-        return err
+    var missing [][]byte
+    err := rtx.Iterate(prefixIndex, func(key, value []byte) bool {
+        idx := binary.LittleEndian.Uint64(value)
+        if idx < uint64(len(tree.values)) && tree.values[idx] != nil {
+            all[string(key)] = tree.apiValue(tree.values[idx])
+        } else {
+            missing = append(missing, append([]byte(nil), key...))
+        }
+        return true
+    })
+    if err != nil {
+        return nil, err
     }
-    if err := txn.Commit(); err != nil {
-        return err
+    for _, key := range missing {
+        stored, err := rtx.Get(dbKey(prefixValue, key))
+        if err != nil {
+            return nil, fmt.Errorf("value of key %x: %w", key, err)
+        }
+        all[string(key)] = tree.apiValue(append([]byte(nil), stored...))
     }
 
-    return nil
+    return all, nil
+}
+
+// Keys returns every key in the tree ordered by leaf index, which is the
+// order the leaves must be replayed in to rebuild the tree. Deleted slots
+// are skipped.
+func (tree *MerkleTree) Keys() ([][]byte, error) {
+    byIndex := make([][]byte, tree.currentIdx)
+    err := tree.eachIndex(func(key []byte, idx int) bool {
+        byIndex[idx] = key
+        return true
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    keys := make([][]byte, 0, len(byIndex))
+    for _, k := range byIndex {
+        if k != nil {
+            keys = append(keys, k)
+        }
+    }
+    return keys, nil
 }
 
-func (tree *MerkleTree) GenProof(key []byte) ([]C.Fp, error) {
-    keyStr := string(key)
-    idx, exists := tree.keyIndex[keyStr]
+func (tree *MerkleTree) GenProof(key []byte) (_ *MerkleProof, err error) {
+    defer tree.stats.record(&tree.stats.proofs, &err)
+
+    idx, exists, err := tree.indexOf(key)
+    if err != nil {
+        return nil, err
+    }
     if !exists {
-        return nil, errors.New("key does not exist")
+        return nil, ErrKeyNotFound
     }
 
-    return getMerklePath(uint(idx))
+    if tree.opts.ProofCacheTTL <= 0 {
+        return tree.proofAt(uint(idx))
+    }
+    if proof, ok := tree.cachedProof(key); ok {
+        return proof, nil
+    }
+    proof, err := tree.proofAt(uint(idx))
+    if err != nil {
+        return nil, err
+    }
+    tree.cacheProof(key, proof)
+    return proof, nil
+}
+
+// GenProofBatch returns the proofs for keys, in order. All paths are read
+// through a single buffer allocated for the call, instead of one per key.
+func (tree *MerkleTree) GenProofBatch(keys [][]byte) (_ []*MerkleProof, err error) {
+    defer tree.stats.record(&tree.stats.proofs, &err)
+
+    buf := newPathBuffer()
+    proofs := make([]*MerkleProof, len(keys))
+    for i, key := range keys {
+        idx, exists, err := tree.indexOf(key)
+        if err != nil {
+            return nil, err
+        }
+        if !exists {
+            return nil, fmt.Errorf("key %x: %w", key, ErrKeyNotFound)
+        }
+        if proofs[i], err = tree.proofAtInto(uint(idx), buf); err != nil {
+            return nil, err
+        }
+    }
+
+    return proofs, nil
+}
+
+// CMemoryStats reports the memory the C library holds for this tree: the
+// number of nodes across all levels, odd last nodes carried up included, and
+// the bytes allocated for them. A tree of 2^k leaves has 2*leaves-1 nodes.
+func (tree *MerkleTree) CMemoryStats() (nodes int, bytes uint64, err error) {
+    if err := tree.useCTree(); err != nil {
+        return 0, 0, err
+    }
+    var n C.size_t
+    var b C.uint64_t
+    if ret := C.tree_stats(tree.cTree, &n, &b); ret != 0 {
+        return 0, 0, cTreeError("tree_stats", ret)
+    }
+    return int(n), uint64(b), nil
+}
+
+// ProofAt returns the inclusion proof of the leaf at index, for callers
+// that track leaves by position rather than by key.
+func (tree *MerkleTree) ProofAt(index uint) (_ *MerkleProof, err error) {
+    defer tree.stats.record(&tree.stats.proofs, &err)
+
+    if index >= uint(len(tree.values)) {
+        return nil, fmt.Errorf("leaf %d of %d: %w", index, len(tree.values), ErrIndexOutOfRange)
+    }
+    return tree.proofAt(index)
+}
+
+// proofAt builds the inclusion proof for the leaf at idx.
+func (tree *MerkleTree) proofAt(idx uint) (*MerkleProof, error) {
+    return tree.proofAtInto(idx, newPathBuffer())
+}
+
+// proofAtInto is proofAt with the C path written into buf; see
+// getMerklePathInto. With WithProofLRU it serves cached proofs first.
+func (tree *MerkleTree) proofAtInto(idx uint, buf []C.Fp) (*MerkleProof, error) {
+    if tree.proofLRU == nil {
+        return tree.buildProof(idx, buf)
+    }
+
+    root := tree.root()
+    if proof, ok := tree.proofLRU.get(root, idx); ok {
+        return proof, nil
+    }
+    proof, err := tree.buildProof(idx, buf)
+    if err != nil {
+        return nil, err
+    }
+    tree.proofLRU.put(root, idx, proof)
+    return proof, nil
+}
+
+// buildProof builds the proof for the leaf at idx from the C tree, or with
+// PersistNodes from the nodes in the database, without touching the C tree.
+func (tree *MerkleTree) buildProof(idx uint, buf []C.Fp) (*MerkleProof, error) {
+    var proof *MerkleProof
+    var err error
+    if tree.servesProofsFromNodes() {
+        proof, err = tree.dbProof(idx)
+    } else {
+        proof, err = tree.cProof(idx, buf)
+    }
+    if err != nil {
+        return nil, err
+    }
+    proof.SortedChildren = tree.opts.SortedChildren
+
+    if tree.opts.VerifyOnGen {
+        ok, err := VerifyProof(tree.root(), proof)
+        if err != nil {
+            return nil, fmt.Errorf("verifying generated proof for leaf %d: %w", idx, err)
+        }
+        if !ok {
+            return nil, fmt.Errorf("proof for leaf %d: %w", idx, ErrProofMismatch)
+        }
+    }
+    return proof, nil
+}
+
+// cProofAt is cProof with a buffer of its own.
+func (tree *MerkleTree) cProofAt(idx uint) (*MerkleProof, error) {
+    return tree.cProof(idx, newPathBuffer())
+}
+
+// cProof is buildProof reading the path from the C tree.
+func (tree *MerkleTree) cProof(idx uint, buf []C.Fp) (*MerkleProof, error) {
+    leaf, err := tree.storedLeaf(tree.values[idx])
+    if err != nil {
+        return nil, err
+    }
+
+    if err := tree.useCTree(); err != nil {
+        return nil, err
+    }
+    path, err := tree.getMerklePathInto(idx, buf)
+    if err != nil {
+        return nil, err
+    }
+    return proofFromPath(idx, uint(len(tree.values)), leaf, path)
 }
 
+// Root returns the current root. If the C tree has to be rebuilt and that
+// fails, it returns the root persisted by the last mutation instead, or nil
+// without one; CheckConsistency reports the failure itself.
 func (tree *MerkleTree) Root() []byte {
-    rootFp := C.get_merkle_root()
-    return fpToBytes(&rootFp)
+    return tree.toAPI(tree.root())
+}
+
+// root returns the root in its in-memory form, regardless of ByteOrder.
+// The root of an empty tree never changes, so it is read from the C library
+// once and cached until the tree gets a leaf.
+func (tree *MerkleTree) root() []byte {
+    empty := len(tree.values) == 0
+    if cached := tree.emptyRoot.Load(); empty && cached != nil {
+        return append([]byte(nil), *cached...)
+    }
+
+    if err := tree.useCTree(); err != nil {
+        return tree.fallbackRoot(err)
+    }
+    cgoCalls.rootQueries.Add(1)
+    var rootFp C.Fp
+    C.tree_root(tree.cTree, &rootFp)
+    root := fpToBytes(&rootFp)
+    if empty {
+        cached := append([]byte(nil), root...)
+        tree.emptyRoot.Store(&cached)
+    }
+    return root
+}
+
+// fallbackRoot is what root returns when the C tree cannot be rebuilt
+// because of err: the persisted root if there is one, nil otherwise.
+func (tree *MerkleTree) fallbackRoot(err error) []byte {
+    if tree.opts.Logger != nil {
+        tree.opts.Logger.Error("cannot rebuild C tree for its root", "err", err)
+    }
+    if tree.opts.NoPersistence {
+        return nil
+    }
+    root, ok, err := tree.persistedRoot()
+    if err != nil || !ok {
+        return nil
+    }
+    return root
+}
+
+// fromAPI converts a field element or value given at the API boundary to
+// little-endian, the in-memory byte order; toAPI converts back. Both are
+// no-ops unless ByteOrder is binary.BigEndian.
+func (tree *MerkleTree) fromAPI(b []byte) []byte {
+    if tree.opts.ByteOrder != binary.BigEndian || b == nil {
+        return b
+    }
+    out := make([]byte, len(b))
+    for i := range b {
+        out[len(b)-1-i] = b[i]
+    }
+    return out
+}
+
+func (tree *MerkleTree) toAPI(b []byte) []byte {
+    return tree.fromAPI(b)
+}
+
+// batchWriteChunk is the number of leaves AddBatch persists per database
+// transaction.
+const batchWriteChunk = 100
+
+// AddBatch adds all key/value pairs and rebuilds the C tree in one call,
+// or in several for batches over MaxBatchSize.
+func (tree *MerkleTree) AddBatch(keys, values [][]byte) (err error) {
+    defer tree.stats.record(&tree.stats.addBatches, &err)
+
+    return tree.addBatch(keys, values, nil)
+}
+
+// AddPairs is AddBatch taking each key together with its value, so the two
+// cannot get out of step. The resulting tree is the same as AddBatch with
+// the keys and values in the same order.
+func (tree *MerkleTree) AddPairs(pairs [][2][]byte) (err error) {
+    defer tree.stats.record(&tree.stats.addBatches, &err)
+
+    keys := make([][]byte, len(pairs))
+    values := make([][]byte, len(pairs))
+    for i, pair := range pairs {
+        keys[i], values[i] = pair[0], pair[1]
+    }
+    return tree.addBatch(keys, values, nil)
+}
+
+// AddBatchWithProgress is AddBatch for large batches that need feedback:
+// the leaves are persisted in chunks of batchWriteChunk and progress is
+// called with the number of leaves written so far and the batch size after
+// each chunk commits. If a chunk fails to commit, the whole batch is
+// undone, including the chunks already reported.
+func (tree *MerkleTree) AddBatchWithProgress(keys, values [][]byte, progress func(done, total int)) (err error) {
+    defer tree.stats.record(&tree.stats.addBatches, &err)
+
+    return tree.addBatch(keys, values, progress)
 }
 
-func (tree *MerkleTree) AddBatch(keys, values [][]byte) error {
+func (tree *MerkleTree) addBatch(keys, values [][]byte, progress func(done, total int)) error {
+    if err := tree.checkMutable(); err != nil {
+        return err
+    }
     if len(keys) != len(values) {
         return errors.New("keys and values length mismatch")
     }
 
+    seen := make(map[string]bool, len(keys))
     for i := 0; i < len(keys); i++ {
         keyStr := string(keys[i])
-        if _, exists := tree.keyIndex[keyStr]; exists {
-            return errors.New("key already exists")
+        _, exists, err := tree.indexOf(keys[i])
+        if err != nil {
+            return err
+        }
+        if exists || seen[keyStr] {
+            return ErrKeyExists
+        }
+        seen[keyStr] = true
+    }
+    if err := tree.checkCapacity(len(keys)); err != nil {
+        return err
+    }
+
+    // Batches over MaxBatchSize go in as consecutive chunks, each its own
+    // addbatch. The first chunk builds the C tree as usual; the later ones
+    // append their leaves to it, so no single call into the library or
+    // buffer of leaves holds more than a chunk of new leaves.
+    limit := tree.maxBatchSize()
+    if len(keys) <= limit {
+        return tree.insertBatch(keys, values, false, progress)
+    }
+    for lo := 0; lo < len(keys); lo += limit {
+        hi := lo + limit
+        if hi > len(keys) {
+            hi = len(keys)
         }
+        var chunkProgress func(done, total int)
+        if progress != nil {
+            chunkProgress = func(done, _ int) { progress(lo+done, len(keys)) }
+        }
+        if err := tree.insertBatch(keys[lo:hi], values[lo:hi], lo > 0, chunkProgress); err != nil {
+            return err
+        }
+        if tree.opts.BatchChunkProgress != nil {
+            tree.opts.BatchChunkProgress(lo, hi, len(keys))
+        }
+    }
+    return nil
+}
+
+// maxBatchSize returns the number of leaves addBatch inserts at once.
+func (tree *MerkleTree) maxBatchSize() int {
+    if tree.opts.MaxBatchSize > 0 {
+        return tree.opts.MaxBatchSize
+    }
+    return defaultMaxBatchSize
+}
+
+// defaultMaxBatchSize is the MaxBatchSize of trees that do not set one.
+const defaultMaxBatchSize = 100000
+
+// insertBatch adds keys, already checked by addBatch, as one addbatch. With
+// extend set it appends their leaves to the C tree one at a time instead of
+// rebuilding it from every leaf.
+func (tree *MerkleTree) insertBatch(keys, values [][]byte, extend bool, progress func(done, total int)) error {
+    stored, leaves, err := tree.batchLeaves(keys, values)
+    if err != nil {
+        return err
+    }
+    seqs, err := tree.logPending(keys, stored)
+    if err != nil {
+        return err
+    }
 
-        tree.keyIndex[keyStr] = tree.currentIdx
+    prevRoot := tree.root()
+    entry := tree.newTxLog(TxOpAddBatch, nil, prevRoot)
+    start := tree.currentIdx
+    for i, key := range keys {
+        tree.setIndex(key, tree.currentIdx)
+        tree.values = append(tree.values, stored[i])
         tree.currentIdx++
     }
+    if extend && tree.cTree != 0 {
+        for _, leaf := range leaves {
+            fp, err := bytesToFp(leaf)
+            if err == nil {
+                cgoCalls.inserts.Add(1)
+                if ret := C.tree_add_leaf(tree.cTree, fp); ret != 0 {
+                    err = cTreeError("tree_add_leaf", ret)
+                }
+            }
+            if err != nil {
+                tree.unwindLeaves(start, keys, seqs)
+                return err
+            }
+        }
+    } else if err := tree.rebuild(); err != nil {
+        tree.unwindLeaves(start, keys, seqs)
+        return err
+    }
+
+    // Persist in chunks. The batch is logged once, with the last chunk. If
+    // a chunk fails to commit, the batch is undone; see unwindBatch.
+    for lo := 0; lo < len(keys); lo += batchWriteChunk {
+        hi := lo + batchWriteChunk
+        if hi > len(keys) {
+            hi = len(keys)
+        }
+        err = tree.writeTx(func(txn db.WriteTx) error {
+            for i := lo; i < hi; i++ {
+                if err := putLeafRecord(txn, keys[i], stored[i], start+i); err != nil {
+                    return err
+                }
+                if err := putLeafKey(txn, leaves[i], keys[i]); err != nil {
+                    return err
+                }
+                if err := tree.putPreimage(txn, leaves[i], values[i]); err != nil {
+                    return err
+                }
+            }
+            if hi == len(keys) {
+                if err := tree.putTxLog(txn, entry); err != nil {
+                    return err
+                }
+                if err := tree.putNodes(txn, start, start+len(keys)); err != nil {
+                    return err
+                }
+            }
+            if err := tree.putRoot(txn); err != nil {
+                return err
+            }
+            return clearPending(txn, seqs[lo:hi])
+        })
+        if err != nil {
+            return tree.unwindBatch(start, lo, keys, leaves, seqs, prevRoot, err)
+        }
+        if progress != nil {
+            progress(hi, len(keys))
+        }
+    }
+
+    tree.advanceTxLog(entry)
+
+    return tree.reportBatchProgress(start)
+}
+
+// unwindBatch undoes a batch of keys appended at start whose chunk at lo
+// failed to commit with err, and returns err. The chunks before lo are
+// deleted from the database again, with the size and root of the tree
+// before the batch, and the whole batch is removed from memory. If those
+// deletions fail too, only the uncommitted leaves are removed from memory,
+// so it still holds what the database does.
+func (tree *MerkleTree) unwindBatch(start, lo int, keys, leaves [][]byte, seqs []uint64, prevRoot []byte, err error) error {
+    if lo > 0 {
+        undoErr := tree.writeTx(func(txn db.WriteTx) error {
+            for i := 0; i < lo; i++ {
+                if err := txn.Delete(dbKey(prefixIndex, keys[i])); err != nil {
+                    return err
+                }
+                if err := txn.Delete(dbKey(prefixValue, keys[i])); err != nil {
+                    return err
+                }
+                if err := dropLeafKey(txn, leaves[i], keys[i]); err != nil {
+                    return err
+                }
+            }
+            if err := txn.Set(keySize, encodeUint64(uint64(start))); err != nil {
+                return err
+            }
+            return txn.Set(keyRoot, prevRoot)
+        })
+        if undoErr != nil {
+            tree.unwindLeaves(start+lo, keys[lo:], seqs[lo:])
+            return fmt.Errorf("%w; undoing the %d leaves committed before: %v", err, lo, undoErr)
+        }
+    }
+    tree.unwindLeaves(start, keys, seqs)
+    return err
+}
+
+// parallelReduceMin is the batch size from which batchLeaves spreads the
+// work over several goroutines; below it the goroutines cost more than the
+// reductions they share.
+const parallelReduceMin = 4096
+
+// parallelism returns the number of goroutines batch work is spread over.
+func (tree *MerkleTree) parallelism() int {
+    if tree.opts.Parallelism > 0 {
+        return tree.opts.Parallelism
+    }
+    return runtime.GOMAXPROCS(0)
+}
+
+// batchLeaves returns the stored bytes and leaf, in its in-memory form, of
+// each key and value, as tree.leaf does. Reducing a value is a big.Int
+// modulo and independent of every other value, so large batches are split
+// into one contiguous share per worker; see Options.Parallelism. The error
+// is that of the lowest failing index, as a serial loop would report.
+func (tree *MerkleTree) batchLeaves(keys, values [][]byte) (stored, leaves [][]byte, err error) {
+    stored = make([][]byte, len(values))
+    leaves = make([][]byte, len(values))
+    errs := make([]error, len(values))
+
+    reduce := func(lo, hi int) {
+        for i := lo; i < hi; i++ {
+            leaf, s, err := tree.leaf(keys[i], values[i])
+            if err != nil {
+                errs[i] = err
+                return
+            }
+            stored[i] = s
+            leaves[i] = append([]byte(nil), fpToBytes(&leaf)...)
+        }
+    }
+
+    workers := tree.parallelism()
+    if len(values) < parallelReduceMin {
+        workers = 1
+    }
+    runShares(len(values), workers, reduce)
+
+    for _, err := range errs {
+        if err != nil {
+            return nil, nil, err
+        }
+    }
+    return stored, leaves, nil
+}
+
+// runShares calls work on n items split into one contiguous share [lo, hi)
+// per worker, each on its own goroutine, and waits for all of them. One
+// worker runs on the calling goroutine.
+func runShares(n, workers int, work func(lo, hi int)) {
+    if workers < 2 {
+        work(0, n)
+        return
+    }
+    share := (n + workers - 1) / workers
+    var wg sync.WaitGroup
+    for lo := 0; lo < n; lo += share {
+        hi := lo + share
+        if hi > n {
+            hi = n
+        }
+        wg.Add(1)
+        go func(lo, hi int) {
+            defer wg.Done()
+            work(lo, hi)
+        }(lo, hi)
+    }
+    wg.Wait()
+}
+
+// Each MerkleTree owns a tree in lib.rs, addressed by the handle tree_new
+// returned. lib.rs locks a tree for every call on it and keeps no state
+// shared between trees, so different trees can be used from different
+// goroutines at once, and a tree that is only read, such as a snapshot,
+// from several. The handle is created by the first rebuild and freed by
+// Close, or by a finalizer once the MerkleTree is unreachable.
+
+// useCTree makes sure lib.rs holds this tree's leaves.
+func (tree *MerkleTree) useCTree() error {
+    if tree.cTree != 0 {
+        return nil
+    }
+    return tree.rebuild()
+}
 
-    flatValues := make([]C.Fp, len(values))
-    for i, value := range values {
-        flatValues[i] = bytesToFp(value)
+// rebuild recreates the C tree from every leaf held in tree.values.
+func (tree *MerkleTree) rebuild() error {
+    tree.deleted = 0
+    for _, stored := range tree.values {
+        if stored == nil {
+            tree.deleted++
+        }
+    }
+    tree.deletesSinceRebuild = 0
 
-        fmt.Printf("Batch adding leaf %d : ", i)
-        logFp(bytesToFp(value))
+    flatValues := make([]C.Fp, len(tree.values))
+    for i, stored := range tree.values {
+        leaf, err := tree.storedLeaf(stored)
+        if err != nil {
+            return err
+        }
+        flatValues[i] = leaf
     }
 
-    ptr := (*C.Fp)(unsafe.Pointer(&flatValues[0]))
-    C.create_merkle_tree(ptr, C.size_t(len(values)))
+    if tree.cTree == 0 {
+        tree.cTree = C.tree_new()
+        runtime.SetFinalizer(tree, (*MerkleTree).freeCTree)
+    }
+    var sorted C.uint8_t
+    if tree.opts.SortedChildren {
+        sorted = 1
+    }
+    if ret := C.tree_set_sorted(tree.cTree, sorted); ret != 0 {
+        return cTreeError("tree_set_sorted", ret)
+    }
 
+    var ptr *C.Fp
+    if len(flatValues) > 0 {
+        ptr = (*C.Fp)(unsafe.Pointer(&flatValues[0]))
+    }
+    cgoCalls.inserts.Add(1)
+    if ret := C.tree_build(tree.cTree, ptr, C.size_t(len(flatValues))); ret != 0 {
+        return cTreeError("tree_build", ret)
+    }
     return nil
 }
 
-func getMerklePath(leafIndex uint) ([]C.Fp, error) {
-    const maxPathLength = 256
-    outPath := make([]C.Fp, maxPathLength)
-    outPathLen := C.size_t(maxPathLength)
+// Close frees the memory lib.rs holds for the tree. The database stays
+// open; it belongs to the caller. A tree used after Close rebuilds its C
+// tree from its leaves.
+func (tree *MerkleTree) Close() {
+    tree.freeCTree()
+    runtime.SetFinalizer(tree, nil)
+}
+
+func (tree *MerkleTree) freeCTree() {
+    if tree.cTree != 0 {
+        C.tree_free(tree.cTree)
+        tree.cTree = 0
+    }
+}
+
+// cTreeError describes the error code ret returned by the tree_* function
+// fn of lib.rs.
+func cTreeError(fn string, ret C.size_t) error {
+    switch ret {
+    case 1:
+        return fmt.Errorf("%s: %w", fn, ErrIndexOutOfRange)
+    case 5:
+        return fmt.Errorf("%s: unknown tree handle", fn)
+    default:
+        return fmt.Errorf("%s failed with code %d", fn, ret)
+    }
+}
+
+// maxPathLength is the capacity of the buffer tree_get_path writes into.
+const maxPathLength = 256
+
+// newPathBuffer returns a buffer for getMerklePathInto.
+func newPathBuffer() []C.Fp {
+    return make([]C.Fp, maxPathLength)
+}
+
+// getMerklePathInto writes the path of leafIndex into buf, which must hold
+// maxPathLength elements, and returns the filled prefix of buf. Callers that
+// fetch many paths reuse one buf; it must not be shared between goroutines.
+func (tree *MerkleTree) getMerklePathInto(leafIndex uint, buf []C.Fp) ([]C.Fp, error) {
+    outPathLen := C.size_t(len(buf))
+
+    cgoCalls.pathQueries.Add(1)
+    ret := C.tree_get_path(tree.cTree, C.size_t(leafIndex), (*C.Fp)(unsafe.Pointer(&buf[0])), &outPathLen)
+    switch ret {
+    case 0:
+    case 1:
+        return nil, fmt.Errorf("merkle path of leaf %d: %w", leafIndex, ErrIndexOutOfRange)
+    case 2:
+        return nil, fmt.Errorf("merkle path of leaf %d: %w", leafIndex, ErrEmptyTree)
+    case 3:
+        return nil, fmt.Errorf("merkle path of leaf %d needs %d elements, buffer holds %d: %w", leafIndex, outPathLen, len(buf), ErrPathBufferTooSmall)
+    default:
+        return nil, fmt.Errorf("merkle path of leaf %d: %w", leafIndex, cTreeError("tree_get_path", ret))
+    }
+
+    return buf[:outPathLen], nil
+}
+
+// levels recomputes every level of the tree from the leaves, leaves first and
+// the root level last, following the same pairing rule as lib.rs.
+func (tree *MerkleTree) levels() ([][][]byte, error) {
+    return tree.levelsOf(tree.values)
+}
+
+// levelsOf is levels for an arbitrary slice of stored values.
+func (tree *MerkleTree) levelsOf(values [][]byte) ([][][]byte, error) {
+    if len(values) == 0 {
+        return nil, nil
+    }
+
+    current := make([]C.Fp, len(values))
+    for i, stored := range values {
+        leaf, err := tree.storedLeaf(stored)
+        if err != nil {
+            return nil, err
+        }
+        current[i] = leaf
+    }
+
+    var out [][][]byte
+    for {
+        level := make([][]byte, len(current))
+        for i := range current {
+            level[i] = fpToBytes(&current[i])
+        }
+        out = append(out, level)
+        if len(current) == 1 {
+            return out, nil
+        }
+
+        next := make([]C.Fp, (len(current)+1)/2)
+        for i := range next {
+            if 2*i+1 < len(current) {
+                left, right := current[2*i], current[2*i+1]
+                if tree.opts.SortedChildren && bytes.Compare(fpToBytes(&right), fpToBytes(&left)) < 0 {
+                    left, right = right, left
+                }
+                cgoCalls.hashes.Add(1)
+                C.hashpd(&next[i], left, right)
+            } else {
+                next[i] = current[2*i]
+            }
+        }
+        current = next
+    }
+}
 
-    ret := C.get_merkle_path(C.size_t(leafIndex), (*C.Fp)(unsafe.Pointer(&outPath[0])), &outPathLen)
-    if ret != 0 {
-        return nil, fmt.Errorf("failed to get merkle path")
+// proofFromPath decodes the path returned by tree_get_path into a
+// MerkleProof. For every level below the root the C side emits the node and
+// its sibling in left-to-right order, or only the node itself when it is the
+// odd last node of its level and is carried up unchanged.
+func proofFromPath(idx, size uint, leaf C.Fp, path []C.Fp) (*MerkleProof, error) {
+    proof := &MerkleProof{
+        Index: idx,
+        Size:  size,
+        Leaf:  fpToBytes(&leaf),
     }
 
-    goPath := make([]C.Fp, outPathLen)
-    copy(goPath, outPath[:outPathLen])
+    pos := 0
+    for i, width := idx, size; width > 1; i, width = i/2, (width+1)/2 {
+        if i == width-1 && width%2 == 1 {
+            pos++
+            continue
+        }
+        if pos+2 > len(path) {
+            return nil, fmt.Errorf("merkle path too short: %d elements for leaf %d of %d", len(path), idx, size)
+        }
+        sibling := path[pos+1]
+        if i%2 == 1 {
+            sibling = path[pos]
+        }
+        proof.Siblings = append(proof.Siblings, fpToBytes(&sibling))
+        pos += 2
+    }
 
-    return goPath, nil
+    return proof, nil
 }
 
 func logFp(fp C.Fp) {
@@ -159,13 +1668,13 @@ func logBytes(b []byte) {
     logFp(fp)
 }
 
-func printMerklePath(path []C.Fp) {
-    for _, element := range path {
-        logFp(element)
+func printMerklePath(proof *MerkleProof) {
+    for _, sibling := range proof.Siblings {
+        logBytes(sibling)
     }
 }
 
-func testSingleAddition(tree *MerkleTree, key, value []byte) {
+func testSingleAddition(tree *MerkleTree, key LeafKey, value LeafValue) {
     err := tree.Add(key, value)
     if err != nil {
         fmt.Printf("An error occurred: %v\n", err)
@@ -233,6 +1742,10 @@ func testLargeBatchAddition(tree *MerkleTree, largeKeys, largeValues [][]byte) {
 }
 
 func main() {
+    if len(os.Args) > 1 {
+        os.Exit(runCLI(os.Args[1:], os.Stdout, os.Stderr))
+    }
+
     var opts db.Options
     opts.Path = "dbname"
     dbpoint, err := badgerdb.New(opts)
@@ -244,8 +1757,8 @@ func main() {
     tree := NewMerkleTree(dbpoint)
 
     // Single addition test
-    key1 := []byte("key1")
-    value1 := []byte("value1")
+    key1 := LeafKey("key1")
+    value1 := LeafValue("value1")
     testSingleAddition(tree, key1, value1)
 
     // Batch addition test
@@ -254,12 +1767,12 @@ func main() {
     testBatchAddition(tree, keys, values)
 
     // More individual additions
-    key4 := []byte("key4")
-    value4 := []byte("value4")
+    key4 := LeafKey("key4")
+    value4 := LeafValue("value4")
     testSingleAddition(tree, key4, value4)
 
-    key5 := []byte("key5")
-    value5 := []byte("value5")
+    key5 := LeafKey("key5")
+    value5 := LeafValue("value5")
     testSingleAddition(tree, key5, value5)
 
     // Adding and verifying a large batch
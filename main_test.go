@@ -0,0 +1,263 @@
+package main
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "testing"
+
+    "go.vocdoni.io/dvote/db"
+    "go.vocdoni.io/dvote/db/badgerdb"
+)
+
+// newTestDB returns a database in a temporary directory, closed when the
+// test ends.
+func newTestDB(t testing.TB) db.Database {
+    t.Helper()
+    database, err := badgerdb.New(db.Options{Path: t.TempDir()})
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { database.Close() })
+    return database
+}
+
+// newTestTree returns a tree on a fresh database, closed when the test
+// ends.
+func newTestTree(t testing.TB, opts ...Option) *MerkleTree {
+    t.Helper()
+    tree := NewMerkleTree(newTestDB(t), opts...)
+    t.Cleanup(tree.Close)
+    return tree
+}
+
+// testLeaves returns n keys and single-element values for them.
+func testLeaves(n int) (keys, values [][]byte) {
+    keys = make([][]byte, n)
+    values = make([][]byte, n)
+    for i := range keys {
+        keys[i] = []byte(fmt.Sprintf("key-%d", i))
+        values[i] = encodeUint64(uint64(i) + 1)
+    }
+    return keys, values
+}
+
+// leafLimbs returns a leaf in its in-memory form as the four limbs
+// KeyByLeafHash takes.
+func leafLimbs(leaf []byte) [4]uint64 {
+    var limbs [4]uint64
+    for i := range limbs {
+        limbs[i] = binary.LittleEndian.Uint64(leaf[i*8:])
+    }
+    return limbs
+}
+
+// addAll adds every key with its value, one Add at a time.
+func addAll(t testing.TB, tree *MerkleTree, keys, values [][]byte) {
+    t.Helper()
+    for i := range keys {
+        if err := tree.Add(keys[i], values[i]); err != nil {
+            t.Fatalf("add %s: %v", keys[i], err)
+        }
+    }
+}
+
+func TestRootFallsBackToPersistedRoot(t *testing.T) {
+    keys, values := testLeaves(3)
+    tree := newTestTree(t)
+    addAll(t, tree, keys, values)
+    want := tree.Root()
+
+    // A stored value with no leaf makes the rebuild fail.
+    tree.freeCTree()
+    tree.values[0] = make([]byte, fpSize+1)
+    if got := tree.Root(); !bytes.Equal(got, want) {
+        t.Fatalf("root %x, want persisted %x", got, want)
+    }
+    if err := tree.CheckConsistency(); err == nil {
+        t.Fatal("consistency check passed with a broken leaf")
+    }
+}
+
+func TestDeleteByIndexMiddleOfSeven(t *testing.T) {
+    keys, values := testLeaves(7)
+    tree := newTestTree(t)
+    addAll(t, tree, keys, values)
+
+    leaf, err := tree.LeafHash(keys[3])
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := tree.DeleteByIndex(3); err != nil {
+        t.Fatal(err)
+    }
+
+    // A deleted slot holds the empty leaf, zero by default: the tree of
+    // the same leaves with a zero value in slot 3.
+    zeroed := append([][]byte(nil), values...)
+    zeroed[3] = encodeUint64(0)
+    want := newTestTree(t, WithNoPersistence())
+    addAll(t, want, keys, zeroed)
+    if !bytes.Equal(tree.Root(), want.Root()) {
+        t.Fatalf("root %x after deleting leaf 3, want %x", tree.Root(), want.Root())
+    }
+
+    if _, err := tree.Get(keys[3]); !errors.Is(err, ErrKeyNotFound) {
+        t.Fatalf("get of the deleted key = %v, want ErrKeyNotFound", err)
+    }
+    if _, err := tree.KeyByLeafHash(leafLimbs(leaf)); !errors.Is(err, ErrKeyNotFound) {
+        t.Fatalf("leaf index lookup of the deleted leaf = %v, want ErrKeyNotFound", err)
+    }
+    if err := tree.DeleteByIndex(3); !errors.Is(err, ErrKeyNotFound) {
+        t.Fatalf("deleting leaf 3 again = %v, want ErrKeyNotFound", err)
+    }
+    if err := tree.DeleteByIndex(7); !errors.Is(err, ErrIndexOutOfRange) {
+        t.Fatalf("deleting leaf 7 of 7 = %v, want ErrIndexOutOfRange", err)
+    }
+    for _, i := range []int{2, 4, 6} {
+        if err := tree.CheckProof(keys[i], values[i]); err != nil {
+            t.Error(err)
+        }
+    }
+}
+
+func TestEmptyRootQueriedOnce(t *testing.T) {
+    tree := newTestTree(t, WithDepth(8))
+
+    before := CgoRootQueries()
+    first := tree.Root()
+    for i := 0; i < 5; i++ {
+        if root := tree.Root(); !bytes.Equal(root, first) {
+            t.Fatalf("empty root %x, then %x", first, root)
+        }
+    }
+    if got := CgoRootQueries() - before; got != 1 {
+        t.Fatalf("%d tree_root calls for 6 roots of an empty tree, want 1", got)
+    }
+}
+
+func TestAddIfAbsent(t *testing.T) {
+    tree := newTestTree(t)
+    key := []byte("key")
+
+    inserted, err := tree.AddIfAbsent(key, encodeUint64(1))
+    if err != nil || !inserted {
+        t.Fatalf("new key: inserted %v, err %v; want true, nil", inserted, err)
+    }
+    root := tree.Root()
+
+    inserted, err = tree.AddIfAbsent(key, encodeUint64(1))
+    if err != nil || inserted {
+        t.Fatalf("same value again: inserted %v, err %v; want false, nil", inserted, err)
+    }
+    if !bytes.Equal(tree.Root(), root) {
+        t.Fatal("re-adding the same value changed the root")
+    }
+
+    inserted, err = tree.AddIfAbsent(key, encodeUint64(2))
+    if !errors.Is(err, ErrKeyExists) || inserted {
+        t.Fatalf("conflicting value: inserted %v, err %v; want false, ErrKeyExists", inserted, err)
+    }
+    got, err := tree.Get(key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(got, encodeUint64(1)) {
+        t.Fatalf("value %x after a conflicting add, want %x", got, encodeUint64(1))
+    }
+
+    if stats := tree.Stats(); stats.Adds != 2 || stats.Errors != 1 {
+        t.Fatalf("stats %+v, want 2 adds and 1 error", stats)
+    }
+}
+
+func TestNoPersistenceThousandLeaves(t *testing.T) {
+    keys, values := testLeaves(1000)
+    tree := NewMerkleTree(nil, WithNoPersistence())
+    defer tree.Close()
+    addAll(t, tree, keys, values)
+
+    if err := tree.CheckConsistency(); err != nil {
+        t.Fatal(err)
+    }
+    for _, i := range []int{0, 1, 499, 998, 999} {
+        if err := tree.CheckProof(keys[i], values[i]); err != nil {
+            t.Error(err)
+        }
+    }
+    if err := tree.Delete(keys[500]); err != nil {
+        t.Fatal(err)
+    }
+    if err := tree.CheckProof(keys[501], values[501]); err != nil {
+        t.Error(err)
+    }
+}
+
+// TestAddRawLeafMixedWithAdd adds every other leaf with AddRawLeaf, as the
+// hash Add would have computed, and expects the root of a tree built with
+// Add alone.
+func TestAddRawLeafMixedWithAdd(t *testing.T) {
+    keys, values := testLeaves(6)
+    for name, opts := range map[string][]Option{
+        "raw values":    nil,
+        "hashed values": {WithStoreHashedValues()},
+    } {
+        t.Run(name, func(t *testing.T) {
+            want := newTestTree(t, append(opts, WithNoPersistence())...)
+            addAll(t, want, keys, values)
+
+            tree := newTestTree(t, opts...)
+            for i, key := range keys {
+                if i%2 == 0 {
+                    if err := tree.Add(key, values[i]); err != nil {
+                        t.Fatal(err)
+                    }
+                    continue
+                }
+                leaf, err := want.LeafHash(key)
+                if err != nil {
+                    t.Fatal(err)
+                }
+                var leafHash [32]byte
+                copy(leafHash[:], leaf)
+                if err := tree.AddRawLeaf(key, leafHash); err != nil {
+                    t.Fatal(err)
+                }
+            }
+            if !bytes.Equal(tree.Root(), want.Root()) {
+                t.Fatalf("root %x, want %x", tree.Root(), want.Root())
+            }
+            if err := tree.CheckConsistency(); err != nil {
+                t.Fatal(err)
+            }
+        })
+    }
+}
+
+func TestAddWithResult(t *testing.T) {
+    tree := newTestTree(t)
+    keys, values := testLeaves(4)
+
+    prev := tree.Root()
+    for i, key := range keys {
+        index, root, err := tree.AddWithResult(key, values[i])
+        if err != nil {
+            t.Fatal(err)
+        }
+        if index != uint(i) {
+            t.Fatalf("%s got index %d, want %d", key, index, i)
+        }
+        if bytes.Equal(root, prev) {
+            t.Fatalf("root did not change when adding %s", key)
+        }
+        if !bytes.Equal(root, tree.Root()) {
+            t.Fatalf("%s returned root %x, tree has %x", key, root, tree.Root())
+        }
+        prev = root
+    }
+
+    if _, root, err := tree.AddWithResult(keys[0], values[0]); !errors.Is(err, ErrKeyExists) || root != nil {
+        t.Fatalf("adding %s again = %x, %v; want nil, ErrKeyExists", keys[0], root, err)
+    }
+}
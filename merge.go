@@ -0,0 +1,194 @@
+package main
+
+import (
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "reflect"
+
+    "go.vocdoni.io/dvote/db"
+)
+
+// MergeTrees returns a new tree in dst holding every leaf of a followed by
+// every leaf of b, each in index order, built with a single
+// tree_build call instead of re-inserting leaves one by one. Deleted
+// slots are dropped. The trees must have disjoint keys and derive leaves
+// the same way; see sameLeaves. The result uses a's options, so its
+// capacity is a's MaxDepth, and it carries over the leaf index, metadata,
+// preimages and pending commitments of both trees.
+func MergeTrees(a, b *MerkleTree, dst db.Database) (*MerkleTree, error) {
+    if err := sameLeaves(a.opts, b.opts); err != nil {
+        return nil, fmt.Errorf("cannot merge trees: %w", err)
+    }
+
+    var keys [][]byte
+    var srcs []*MerkleTree
+    seen := make(map[string]bool)
+    for _, src := range []*MerkleTree{a, b} {
+        srcKeys, err := src.Keys()
+        if err != nil {
+            return nil, err
+        }
+        for _, key := range srcKeys {
+            if seen[string(key)] {
+                return nil, fmt.Errorf("key %x is in both trees: %w", key, ErrKeyExists)
+            }
+            seen[string(key)] = true
+            keys = append(keys, key)
+            srcs = append(srcs, src)
+        }
+    }
+
+    merged := NewMerkleTree(dst, func(o *config) { *o = a.opts })
+    if err := merged.checkCapacity(len(keys)); err != nil {
+        return nil, err
+    }
+
+    records := make([]leafRecords, len(keys))
+    for i, key := range keys {
+        srcIdx, _, err := srcs[i].indexOf(key)
+        if err != nil {
+            return nil, err
+        }
+        stored := srcs[i].values[srcIdx]
+        if records[i], err = srcs[i].recordsOf(key, stored); err != nil {
+            return nil, err
+        }
+        merged.setIndex(key, merged.currentIdx)
+        merged.values = append(merged.values, stored)
+        merged.currentIdx++
+    }
+
+    if err := merged.rebuild(); err != nil {
+        return nil, err
+    }
+
+    err := merged.writeTx(func(txn db.WriteTx) error {
+        for i, key := range keys {
+            if err := putLeafRecord(txn, key, merged.values[i], i); err != nil {
+                return err
+            }
+            leaf, err := merged.storedLeafBytes(merged.values[i])
+            if err != nil {
+                return err
+            }
+            if err := putLeafKey(txn, leaf, key); err != nil {
+                return err
+            }
+            if err := merged.putLeafRecords(txn, key, leaf, records[i]); err != nil {
+                return err
+            }
+        }
+        if err := merged.putNodes(txn, 0, len(keys)); err != nil {
+            return err
+        }
+        return merged.putRoot(txn)
+    })
+    if err != nil {
+        return nil, err
+    }
+    merged.publishHead()
+
+    return merged, nil
+}
+
+// sameLeaves returns an error naming the first option on which a and b would
+// derive a different leaf from the same stored value, or hash the same
+// children differently. EmptyLeaf is not compared, as merging drops deleted
+// slots.
+func sameLeaves(a, b config) error {
+    switch {
+    case a.StoreHashedValues != b.StoreHashedValues:
+        return errors.New("StoreHashedValues differs")
+    case a.WideLeaves != b.WideLeaves && (a.WideLeaves > 1 || b.WideLeaves > 1):
+        return errors.New("WideLeaves differs")
+    case a.KeyedLeaves != b.KeyedLeaves:
+        return errors.New("KeyedLeaves differs")
+    case a.SortedChildren != b.SortedChildren:
+        return errors.New("SortedChildren differs")
+    case a.AutoHashOversize != b.AutoHashOversize:
+        return errors.New("AutoHashOversize differs")
+    case (a.ByteOrder == binary.BigEndian) != (b.ByteOrder == binary.BigEndian):
+        return errors.New("ByteOrder differs")
+    case !sameHasher(a.Hasher, b.Hasher):
+        return errors.New("Hasher differs")
+    }
+    return nil
+}
+
+// sameHasher reports whether h1 and h2 are the same Hasher, nil being
+// PoseidonHasher. Hashers of a type that cannot be compared are taken as
+// different.
+func sameHasher(h1, h2 Hasher) bool {
+    if h1 == nil {
+        h1 = PoseidonHasher{}
+    }
+    if h2 == nil {
+        h2 = PoseidonHasher{}
+    }
+    t := reflect.TypeOf(h1)
+    if t != reflect.TypeOf(h2) || !t.Comparable() {
+        return false
+    }
+    return h1 == h2
+}
+
+// leafRecords are the records of a leaf kept besides its value, which
+// MergeTrees copies to the merged tree. Absent records are nil.
+type leafRecords struct {
+    meta, preimage, commitment []byte
+}
+
+// recordsOf reads the records of the leaf of key, whose stored value is
+// stored.
+func (tree *MerkleTree) recordsOf(key, stored []byte) (leafRecords, error) {
+    var r leafRecords
+    if tree.opts.NoPersistence {
+        return r, nil
+    }
+    rtx := tree.db.ReadTx()
+    defer rtx.Discard()
+
+    get := func(k []byte) ([]byte, error) {
+        v, err := rtx.Get(k)
+        if errors.Is(err, db.ErrKeyNotFound) {
+            return nil, nil
+        }
+        return append([]byte(nil), v...), err
+    }
+    var err error
+    if r.meta, err = get(dbKey(prefixMeta, key)); err != nil {
+        return r, err
+    }
+    if r.commitment, err = get(dbKey(prefixCommitment, key)); err != nil {
+        return r, err
+    }
+    if tree.preimages != nil {
+        leaf, err := tree.storedLeafBytes(stored)
+        if err != nil {
+            return r, err
+        }
+        if r.preimage, err = get(preimageKey(leaf)); err != nil {
+            return r, err
+        }
+    }
+    return r, nil
+}
+
+// putLeafRecords writes r for the leaf of key in txn.
+func (tree *MerkleTree) putLeafRecords(txn db.WriteTx, key, leaf []byte, r leafRecords) error {
+    if r.meta != nil {
+        if err := txn.Set(dbKey(prefixMeta, key), r.meta); err != nil {
+            return err
+        }
+    }
+    if r.commitment != nil {
+        if err := txn.Set(dbKey(prefixCommitment, key), r.commitment); err != nil {
+            return err
+        }
+    }
+    if r.preimage != nil {
+        return tree.putPreimage(txn, leaf, r.preimage)
+    }
+    return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "testing"
+)
+
+func TestMergeTreesRejectsDifferentLeaves(t *testing.T) {
+    for name, opt := range map[string]Option{
+        "KeyedLeaves":      WithKeyedLeaves(),
+        "WideLeaves":       WithWideLeaves(2),
+        "SortedChildren":   WithSortedChildren(),
+        "AutoHashOversize": WithAutoHashOversize(),
+        "ByteOrder":        WithByteOrder(binary.BigEndian),
+    } {
+        t.Run(name, func(t *testing.T) {
+            a := newTestTree(t)
+            b := newTestTree(t, opt)
+            if _, err := MergeTrees(a, b, newTestDB(t)); err == nil {
+                t.Fatal("merged trees with different options")
+            }
+        })
+    }
+}
+
+func TestMergeTreesCarriesIndexes(t *testing.T) {
+    keys, values := testLeaves(4)
+    a := newTestTree(t, WithStoreHashedValues(), WithStorePreimages())
+    addAll(t, a, keys[:2], values[:2])
+    b := newTestTree(t, WithStoreHashedValues(), WithStorePreimages())
+    addAll(t, b, keys[2:3], values[2:3])
+    if err := b.AddWithMeta(keys[3], values[3], []byte("meta")); err != nil {
+        t.Fatal(err)
+    }
+
+    preimage := encodeUint64(99)
+    commitment, err := HashOne(preimage)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := b.AddCommitment([]byte("committed"), commitment); err != nil {
+        t.Fatal(err)
+    }
+
+    merged, err := MergeTrees(a, b, newTestDB(t))
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(merged.Close)
+
+    for _, key := range keys {
+        found, err := merged.KeyByLeafHash(leafLimbsOf(t, merged, key))
+        if err != nil {
+            t.Fatalf("leaf of %s: %v", key, err)
+        }
+        if !bytes.Equal(found, key) {
+            t.Fatalf("leaf of %s maps to %s", key, found)
+        }
+    }
+
+    value, ok, err := merged.LookupPreimage(leafLimbsOf(t, merged, keys[0]))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !ok || !bytes.Equal(value, values[0]) {
+        t.Fatalf("preimage of %s after merge = %x, %v; want %x", keys[0], value, ok, values[0])
+    }
+    meta, err := merged.GetMeta(keys[3])
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(meta, []byte("meta")) {
+        t.Fatalf("meta of %s after merge = %q, want %q", keys[3], meta, "meta")
+    }
+
+    if err := merged.RevealCommitment([]byte("committed"), preimage); err != nil {
+        t.Fatalf("reveal after merge: %v", err)
+    }
+}
+
+func TestMergeTreesChecksCapacity(t *testing.T) {
+    keys, values := testLeaves(5)
+    a := newTestTree(t, WithMaxDepth(2))
+    addAll(t, a, keys[:3], values[:3])
+    b := newTestTree(t)
+    addAll(t, b, keys[3:], values[3:])
+
+    if _, err := MergeTrees(a, b, newTestDB(t)); !errors.Is(err, ErrTreeFull) {
+        t.Fatalf("merging 5 leaves into a depth 2 tree = %v, want ErrTreeFull", err)
+    }
+}
+
+// leafLimbsOf returns the leaf of key in tree as KeyByLeafHash takes it.
+func leafLimbsOf(t *testing.T, tree *MerkleTree, key []byte) [4]uint64 {
+    t.Helper()
+    leaf, err := tree.LeafHash(key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return leafLimbs(leaf)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+    "errors"
+
+    "go.vocdoni.io/dvote/db"
+)
+
+// prefixMeta holds per-leaf metadata: meta/<key> -> meta. Metadata is not
+// part of the leaf, so it never affects the root.
+var prefixMeta = []byte("meta/")
+
+var errMetaNeedsPersistence = errors.New("leaf metadata is kept in the database; not available with NoPersistence")
+
+// AddWithMeta is Add that also stores meta, such as a timestamp or the
+// source of the value, alongside key. The leaf and the root are the same as
+// with Add. The metadata is written in the same transaction as the leaf and
+// removed by Delete.
+func (tree *MerkleTree) AddWithMeta(key, value, meta []byte) (err error) {
+    defer tree.stats.record(&tree.stats.adds, &err)
+
+    if tree.opts.NoPersistence {
+        return errMetaNeedsPersistence
+    }
+    leaf, stored, err := tree.leaf(key, value)
+    if err != nil {
+        return err
+    }
+    return tree.addLeaf(key, leaf, stored, value, func(txn db.WriteTx) error {
+        return txn.Set(dbKey(prefixMeta, key), meta)
+    })
+}
+
+// GetMeta returns the metadata stored for key by AddWithMeta, or nil if key
+// was added without any. It returns ErrKeyNotFound if key is not in the
+// tree.
+func (tree *MerkleTree) GetMeta(key []byte) ([]byte, error) {
+    if tree.opts.NoPersistence {
+        return nil, errMetaNeedsPersistence
+    }
+    exists, err := tree.Has(key)
+    if err != nil {
+        return nil, err
+    }
+    if !exists {
+        return nil, ErrKeyNotFound
+    }
+
+    rtx := tree.db.ReadTx()
+    defer rtx.Discard()
+    meta, err := rtx.Get(dbKey(prefixMeta, key))
+    if errors.Is(err, db.ErrKeyNotFound) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    return append([]byte(nil), meta...), nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+    "testing"
+)
+
+func TestMetaDoesNotAffectRoot(t *testing.T) {
+    keys, values := testLeaves(4)
+    a := newTestTree(t)
+    b := newTestTree(t)
+    plain := newTestTree(t)
+    for i, key := range keys {
+        if err := a.AddWithMeta(key, values[i], []byte(fmt.Sprintf("source-a-%d", i))); err != nil {
+            t.Fatal(err)
+        }
+        if err := b.AddWithMeta(key, values[i], []byte(fmt.Sprintf("ts=%d", 1000+i))); err != nil {
+            t.Fatal(err)
+        }
+    }
+    addAll(t, plain, keys, values)
+
+    if !bytes.Equal(a.Root(), b.Root()) || !bytes.Equal(a.Root(), plain.Root()) {
+        t.Fatalf("roots %x, %x and without meta %x differ", a.Root(), b.Root(), plain.Root())
+    }
+
+    meta, err := b.GetMeta(keys[2])
+    if err != nil {
+        t.Fatal(err)
+    }
+    if string(meta) != "ts=1002" {
+        t.Fatalf("meta of %s = %q, want %q", keys[2], meta, "ts=1002")
+    }
+    if meta, err := plain.GetMeta(keys[2]); err != nil || meta != nil {
+        t.Fatalf("meta of a key added without any = %q, %v; want nil", meta, err)
+    }
+    if _, err := a.GetMeta([]byte("absent")); !errors.Is(err, ErrKeyNotFound) {
+        t.Fatalf("meta of an absent key = %v, want ErrKeyNotFound", err)
+    }
+}
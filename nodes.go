@@ -0,0 +1,174 @@
+package main
+
+import (
+    "encoding/binary"
+    "errors"
+    "fmt"
+
+    "go.vocdoni.io/dvote/db"
+)
+
+// With PersistNodes every node of the tree is stored, in its in-memory form:
+//
+//     node/<level, 1 byte><position, uint64 big-endian> -> node
+//
+// Level 0 holds the leaves and the highest level the root. A node at level l
+// and position p covers the leaves [p<<l, (p+1)<<l), so a mutation of a
+// leaf only changes the nodes on its path and appending leaves never moves
+// an existing node. Levels above the root of a tree that shrank through
+// RestoreCheckpoint may keep stale nodes; readers never go past the width
+// the persisted size gives each level.
+var prefixNode = []byte("node/")
+
+func nodeKey(level int, pos uint) []byte {
+    key := make([]byte, 9)
+    key[0] = byte(level)
+    binary.BigEndian.PutUint64(key[1:], uint64(pos))
+    return dbKey(prefixNode, key)
+}
+
+// putNodes writes every node covering a leaf in [lo, hi) with PersistNodes.
+// A single leaf costs one proof, taken from the C tree since the persisted
+// nodes are the ones being replaced; a range recomputes the levels.
+func (tree *MerkleTree) putNodes(txn db.WriteTx, lo, hi int) error {
+    if !tree.opts.PersistNodes || lo >= hi {
+        return nil
+    }
+
+    if hi-lo == 1 {
+        proof, err := tree.cProofAt(uint(lo))
+        if err != nil {
+            return err
+        }
+        nodes, err := pathNodes(proof)
+        if err != nil {
+            return err
+        }
+        for level, node := range nodes {
+            if err := txn.Set(nodeKey(level, uint(lo)>>uint(level)), node); err != nil {
+                return err
+            }
+        }
+        return nil
+    }
+
+    levels, err := tree.levels()
+    if err != nil {
+        return err
+    }
+    return putLevelNodes(txn, levels, lo, hi)
+}
+
+// putLevelNodes writes the nodes of levels covering a leaf in [lo, hi).
+func putLevelNodes(txn db.WriteTx, levels [][][]byte, lo, hi int) error {
+    for level, nodes := range levels {
+        for pos := uint(lo) >> uint(level); pos <= uint(hi-1)>>uint(level); pos++ {
+            if err := txn.Set(nodeKey(level, pos), nodes[pos]); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+// VerifyLeafFromDB reports whether key is in the tree with the given root,
+// reading only the records on its path from database rather than loading
+// the tree. The tree must have been written with WithPersistNodes, and
+// prefix is the one given to WithPrefix, or "". root is in its in-memory
+// form; the leaf proven is the one recorded for key, so callers that need
+// it to hold a particular value check Get or GetHash separately. The
+// database does not record SortedChildren, so trees built with it cannot be
+// checked this way.
+func VerifyLeafFromDB(database db.Database, prefix string, key []byte, root []byte) (bool, error) {
+    if prefix != "" {
+        database = db.NewPrefixedDatabase([]byte(prefix), database)
+    }
+    rtx := database.ReadTx()
+    defer rtx.Discard()
+
+    idxBytes, err := rtx.Get(dbKey(prefixIndex, key))
+    if errors.Is(err, db.ErrKeyNotFound) {
+        return false, ErrKeyNotFound
+    }
+    if err != nil {
+        return false, err
+    }
+    sizeBytes, err := rtx.Get(keySize)
+    if err != nil {
+        return false, err
+    }
+    idx := uint(binary.LittleEndian.Uint64(idxBytes))
+    size := uint(binary.LittleEndian.Uint64(sizeBytes))
+    if idx >= size {
+        return false, fmt.Errorf("leaf %d of %d: %w", idx, size, ErrIndexOutOfRange)
+    }
+
+    proof, err := proofFromNodes(rtx, idx, size)
+    if err != nil {
+        return false, err
+    }
+    return VerifyProof(root, proof)
+}
+
+// proofFromNodes assembles the proof for the leaf at idx of a tree of size
+// leaves from its persisted nodes.
+func proofFromNodes(rtx db.Reader, idx, size uint) (*MerkleProof, error) {
+    readNode := func(level int, pos uint) ([]byte, error) {
+        node, err := rtx.Get(nodeKey(level, pos))
+        if errors.Is(err, db.ErrKeyNotFound) {
+            return nil, fmt.Errorf("node %d/%d missing; was the tree written WithPersistNodes?", level, pos)
+        }
+        if err != nil {
+            return nil, err
+        }
+        return append([]byte(nil), node...), nil
+    }
+
+    leaf, err := readNode(0, idx)
+    if err != nil {
+        return nil, err
+    }
+    proof := &MerkleProof{Index: idx, Size: size, Leaf: leaf}
+    pos, width := idx, size
+    for level := 0; width > 1; level++ {
+        if sibling := pos ^ 1; sibling < width {
+            node, err := readNode(level, sibling)
+            if err != nil {
+                return nil, err
+            }
+            proof.Siblings = append(proof.Siblings, node)
+        }
+        pos, width = pos/2, (width+1)/2
+    }
+    return proof, nil
+}
+
+// ensureNodes writes every node of a loaded tree whose database has none
+// yet, so PersistNodes can be turned on for an existing tree.
+func (tree *MerkleTree) ensureNodes(rtx db.ReadTx) error {
+    size := len(tree.values)
+    if !tree.opts.PersistNodes || tree.opts.NoPersistence || size == 0 {
+        return nil
+    }
+    _, err := rtx.Get(nodeKey(0, uint(size-1)))
+    if !errors.Is(err, db.ErrKeyNotFound) {
+        return err
+    }
+    return tree.writeTx(func(txn db.WriteTx) error {
+        return tree.putNodes(txn, 0, size)
+    })
+}
+
+// servesProofsFromNodes reports whether buildProof reads proofs from the
+// persisted nodes instead of the C tree. Optimistic adds are not persisted
+// until Flush, so their nodes would be stale until then.
+func (tree *MerkleTree) servesProofsFromNodes() bool {
+    return tree.opts.PersistNodes && !tree.opts.NoPersistence && len(tree.pending) == 0
+}
+
+// dbProof is buildProof reading the siblings from the persisted nodes.
+func (tree *MerkleTree) dbProof(idx uint) (*MerkleProof, error) {
+    rtx := tree.db.ReadTx()
+    defer rtx.Discard()
+    return proofFromNodes(rtx, idx, uint(len(tree.values)))
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+    "fmt"
+    "testing"
+)
+
+// TestPersistedNodeCount checks that a full binary tree of n leaves stores
+// 2n-1 nodes, whether its leaves were added one at a time or in a batch.
+// Other sizes store every carried-up node once per level, and the C tree
+// counts its nodes the same way.
+func TestPersistedNodeCount(t *testing.T) {
+    for _, c := range []struct {
+        leaves, nodes int
+    }{
+        {1, 1},
+        {8, 15},
+        {64, 127},
+        {5, 5 + 3 + 2 + 1},
+    } {
+        keys, values := testLeaves(c.leaves)
+        for _, batch := range []bool{false, true} {
+            t.Run(fmt.Sprintf("%d/batch=%v", c.leaves, batch), func(t *testing.T) {
+                database := newTestDB(t)
+                tree := NewMerkleTree(database, WithPersistNodes())
+                defer tree.Close()
+                if batch {
+                    if err := tree.AddBatch(keys, values); err != nil {
+                        t.Fatal(err)
+                    }
+                } else {
+                    addAll(t, tree, keys, values)
+                }
+
+                rtx := database.ReadTx()
+                defer rtx.Discard()
+                n := 0
+                if err := rtx.Iterate(prefixNode, func(_, _ []byte) bool {
+                    n++
+                    return true
+                }); err != nil {
+                    t.Fatal(err)
+                }
+                if n != c.nodes {
+                    t.Fatalf("%d nodes persisted for %d leaves, want %d", n, c.leaves, c.nodes)
+                }
+
+                nodes, _, err := tree.CMemoryStats()
+                if err != nil {
+                    t.Fatal(err)
+                }
+                if nodes != c.nodes {
+                    t.Fatalf("C tree holds %d nodes for %d leaves, want %d", nodes, c.leaves, c.nodes)
+                }
+            })
+        }
+    }
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+    "bytes"
+    "errors"
+
+    "go.vocdoni.io/dvote/db"
+)
+
+// pendingAdd is a leaf added by AddOptimistic that Flush has not written
+// yet.
+type pendingAdd struct {
+    key, stored, preimage []byte
+    idx                   int
+}
+
+// AddOptimistic adds key to the in-memory state and the C tree only; its
+// database records are written by the next Flush. It is meant for
+// ingestion where database writes are the bottleneck: proofs and roots
+// include the leaf at once, but nothing about it is durable, not even in
+// the write-ahead log. Flush must be called before the process exits, or
+// every optimistic add since the last Flush is lost. Any other mutation
+// flushes them first.
+//
+// AddOptimistic needs the in-memory key index and fails with WithDBOnlyIndex.
+func (tree *MerkleTree) AddOptimistic(key, value []byte) (err error) {
+    defer tree.stats.record(&tree.stats.adds, &err)
+
+    if err := tree.checkWritable(); err != nil {
+        return err
+    }
+    if tree.dbOnlyIndex() {
+        return errors.New("optimistic adds need the in-memory key index")
+    }
+    _, exists, err := tree.indexOf(key)
+    if err != nil {
+        return err
+    }
+    if exists {
+        return ErrKeyExists
+    }
+    if err := tree.checkCapacity(1); err != nil {
+        return err
+    }
+    leaf, stored, err := tree.leaf(key, value)
+    if err != nil {
+        return err
+    }
+
+    if len(tree.pending) == 0 {
+        tree.pendingPrevRoot = tree.root()
+    }
+    idx, err := tree.insertLeaf(key, leaf, stored)
+    if err != nil {
+        return err
+    }
+    tree.pending = append(tree.pending, pendingAdd{
+        key:      append([]byte(nil), key...),
+        stored:   stored,
+        preimage: value,
+        idx:      idx,
+    })
+    return nil
+}
+
+// checkMutable is checkWritable followed by Flush, for every mutation but
+// AddOptimistic: the mutation persists the size and root of the tree, which
+// cover the optimistic adds, so their records must reach the database
+// first.
+func (tree *MerkleTree) checkMutable() error {
+    if err := tree.checkWritable(); err != nil {
+        return err
+    }
+    return tree.Flush()
+}
+
+// Pending returns the number of optimistic adds Flush has yet to write.
+func (tree *MerkleTree) Pending() int {
+    return len(tree.pending)
+}
+
+// Flush writes the records of every optimistic add in one database
+// transaction, logged as a single addbatch whose previous root is the root
+// before the first of them. Keys updated since are written with their
+// current value and keys deleted since are skipped. On error nothing is
+// written and the adds stay pending.
+func (tree *MerkleTree) Flush() error {
+    if len(tree.pending) == 0 {
+        return nil
+    }
+
+    entry := tree.newTxLog(TxOpAddBatch, nil, tree.pendingPrevRoot)
+    err := tree.writeTx(func(txn db.WriteTx) error {
+        for _, p := range tree.pending {
+            idx, exists, err := tree.indexOf(p.key)
+            if err != nil {
+                return err
+            }
+            if !exists || idx != p.idx {
+                continue
+            }
+            stored := tree.values[idx]
+            leaf, err := tree.storedLeafBytes(stored)
+            if err != nil {
+                return err
+            }
+            if err := putLeafRecord(txn, p.key, stored, idx); err != nil {
+                return err
+            }
+            if err := putLeafKey(txn, leaf, p.key); err != nil {
+                return err
+            }
+            if bytes.Equal(stored, p.stored) {
+                if err := tree.putPreimage(txn, leaf, p.preimage); err != nil {
+                    return err
+                }
+            }
+        }
+        if err := txn.Set(keySize, encodeUint64(uint64(tree.currentIdx))); err != nil {
+            return err
+        }
+        if err := tree.putTxLog(txn, entry); err != nil {
+            return err
+        }
+        if err := tree.putNodes(txn, tree.pending[0].idx, tree.currentIdx); err != nil {
+            return err
+        }
+        return tree.putRoot(txn)
+    })
+    if err != nil {
+        return err
+    }
+    tree.advanceTxLog(entry)
+
+    tree.pending = nil
+    tree.pendingPrevRoot = nil
+    return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+    "bytes"
+    "errors"
+    "testing"
+)
+
+// TestOptimisticAddsSurviveOtherMutations crashes after an Update that
+// follows optimistic adds. The Update persists the size and root covering
+// them, so it must flush them first for the database to load strictly.
+func TestOptimisticAddsSurviveOtherMutations(t *testing.T) {
+    database := newTestDB(t)
+    keys, values := testLeaves(4)
+
+    tree := NewMerkleTree(database)
+    addAll(t, tree, keys[:2], values[:2])
+    for i := 2; i < 4; i++ {
+        if err := tree.AddOptimistic(keys[i], values[i]); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if err := tree.Update(keys[3], encodeUint64(40)); err != nil {
+        t.Fatal(err)
+    }
+    if n := tree.Pending(); n != 0 {
+        t.Fatalf("%d optimistic adds pending after an update", n)
+    }
+    want := tree.Root()
+    tree.Close() // the crash
+
+    loaded, err := LoadMerkleTreeStrict(database)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer loaded.Close()
+    if !bytes.Equal(loaded.Root(), want) {
+        t.Fatalf("loaded root %x, want %x", loaded.Root(), want)
+    }
+    got, err := loaded.Get(keys[3])
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(got, encodeUint64(40)) {
+        t.Fatalf("updated pending key holds %x, want %x", got, encodeUint64(40))
+    }
+}
+
+// TestOptimisticAddsFlushCrashReload checks that flushed adds are durable
+// and that adds after the last Flush are lost in a crash, leaving a
+// database that still loads strictly.
+func TestOptimisticAddsFlushCrashReload(t *testing.T) {
+    database := newTestDB(t)
+    keys, values := testLeaves(4)
+
+    tree := NewMerkleTree(database)
+    for i := 0; i < 3; i++ {
+        if err := tree.AddOptimistic(keys[i], values[i]); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if err := tree.Flush(); err != nil {
+        t.Fatal(err)
+    }
+    flushed := tree.Root()
+    if err := tree.AddOptimistic(keys[3], values[3]); err != nil {
+        t.Fatal(err)
+    }
+    tree.Close() // the crash, before the second Flush
+
+    loaded, err := LoadMerkleTreeStrict(database)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer loaded.Close()
+    if !bytes.Equal(loaded.Root(), flushed) {
+        t.Fatalf("loaded root %x, want the flushed root %x", loaded.Root(), flushed)
+    }
+    for i := 0; i < 3; i++ {
+        if _, err := loaded.Get(keys[i]); err != nil {
+            t.Fatalf("flushed key %s: %v", keys[i], err)
+        }
+    }
+    if _, err := loaded.Get(keys[3]); !errors.Is(err, ErrKeyNotFound) {
+        t.Fatalf("unflushed key = %v, want ErrKeyNotFound", err)
+    }
+}
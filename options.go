@@ -0,0 +1,402 @@
+package main
+
+import (
+    "encoding/binary"
+    "log/slog"
+    "time"
+)
+
+// config configures a MerkleTree. It is set through the With* Option
+// functions passed to NewMerkleTree.
+type config struct {
+    // StoreHashedValues keeps only HashOne(value) in the database and in
+    // memory instead of the raw value. The leaf committed to the tree is
+    // then the hash as well.
+    StoreHashedValues bool
+
+    // EmptyLeaf is the value of a leaf slot that holds no key, such as one
+    // freed by Delete. Nil means the zero field element.
+    EmptyLeaf []byte
+
+    // NoPersistence keeps the tree in memory only. No database is needed
+    // and every write to it is skipped.
+    NoPersistence bool
+
+    // StorePreimages records the original value of every leaf in a
+    // PreimageRegistry, so LookupPreimage can recover it from the leaf.
+    StorePreimages bool
+
+    // ProgressEvery and Progress report the root while leaves stream in:
+    // Progress is called with the leaf count and root each time the tree
+    // grows to a multiple of ProgressEvery leaves.
+    ProgressEvery int
+    Progress      func(count int, root []byte)
+
+    // DBOnlyIndex resolves key indexes from the database instead of keeping
+    // an in-memory copy of the key index. Ignored with NoPersistence.
+    DBOnlyIndex bool
+
+    // SortedChildren hashes the smaller child of every internal node first,
+    // comparing the bytes of the two field elements, instead of the left
+    // child. Proofs then verify without knowing which side each sibling is
+    // on.
+    SortedChildren bool
+
+    // MaxDepth bounds the tree to 2^MaxDepth leaves; see MaxCapacity. Zero
+    // means defaultMaxDepth.
+    MaxDepth int
+
+    // AutoGrow raises MaxDepth when an add would exceed MaxCapacity instead
+    // of failing with ErrTreeFull. The pairing of leaves does not depend on
+    // the depth bound, so growing changes no existing node, and proofs
+    // generated before still verify against the roots they were made for.
+    AutoGrow bool
+
+    // UseBloomFilter keeps a Bloom filter over every key this tree adds or
+    // loads, so lookups of absent keys can usually skip the database read
+    // that DBOnlyIndex otherwise makes. It is sized for BloomExpectedKeys
+    // keys at BloomFalsePositiveRate; zero values select
+    // defaultBloomExpectedKeys and defaultBloomFalsePositiveRate. Only keys
+    // that pass through this tree are in the filter, so open an existing
+    // database with LoadMerkleTree when using it.
+    UseBloomFilter         bool
+    BloomExpectedKeys      int
+    BloomFalsePositiveRate float64
+
+    // ByteOrder is the byte order of field elements and values at the API
+    // boundary. With binary.BigEndian, values passed to Add, Update and
+    // AddRawLeaf and EmptyLeaf are read as big-endian integers, and Root,
+    // SubtreeRoot, GetHash, Get of a hashed value, RotateRoot, RootHistory,
+    // TreeTx.TentativeRoot and the WithRootProgress callback return
+    // byte-reversed field elements. The in-memory limb layout, the database
+    // and proofs, including the roots carried in WitnessProof and
+    // ExclusionProof, are unchanged. Nil means binary.LittleEndian.
+    ByteOrder binary.ByteOrder
+
+    // ProofLRUSize, when positive, keeps up to that many recently generated
+    // proofs in memory, keyed by root and leaf index, so repeated requests
+    // for the same leaf at the same root skip the C library.
+    ProofLRUSize int
+
+    // ProofCacheTTL, when positive, stores every proof GenProof computes in
+    // the database for that long; see WithProofCache.
+    ProofCacheTTL time.Duration
+
+    // VerifyOnGen checks every proof read from the C tree against the
+    // current root before returning it, at the cost of one hash per level.
+    VerifyOnGen bool
+
+    // WideLeaves, when at least 2, lets a value span that many field
+    // elements: up to WideLeaves*32 bytes, committed to by hashing its
+    // length and the elements together. Get still returns the value as
+    // given. With StoreHashedValues the combined hash is stored and
+    // committed to as is.
+    WideLeaves int
+
+    // RebuildThreshold, when positive, rebuilds the C tree from the leaves
+    // once the slots deleted since the last rebuild exceed that fraction of
+    // all slots; see LoadFactor.
+    RebuildThreshold float64
+
+    // Logger receives structured logs of maintenance work such as
+    // threshold rebuilds. Nil disables logging.
+    Logger *slog.Logger
+
+    // Prefix namespaces every database record of the tree, so several trees
+    // can share one database; see WithPrefix.
+    Prefix []byte
+
+    // Hasher hashes values into leaves with StoreHashedValues. Nil means
+    // PoseidonHasher.
+    Hasher Hasher
+
+    // KeyedLeaves commits every leaf to its key as well as its value, as
+    // KeyedLeaf(key, value), so a proof also authenticates which key it is
+    // for. It implies StoreHashedValues: the keyed leaf is what is stored
+    // and what Get returns.
+    KeyedLeaves bool
+
+    // Parallelism is the number of goroutines AddBatch spreads the
+    // conversion of values into leaves over. Zero means GOMAXPROCS; 1 keeps
+    // the work on the calling goroutine. Building the C tree itself stays
+    // serial: tree_build builds the whole tree under the tree's lock, and
+    // lib.rs cannot merge subtrees built separately.
+    Parallelism int
+
+    // MaxBatchSize is the most leaves AddBatch inserts at once; larger
+    // batches are split into chunks of this size, each committed and logged
+    // as its own addbatch. A failure part way leaves the earlier chunks in
+    // the tree. Zero means defaultMaxBatchSize.
+    MaxBatchSize int
+
+    // BatchChunkProgress, if set, is called after each chunk of a batch
+    // split by MaxBatchSize commits, with the chunk's bounds [lo, hi) within
+    // the batch and the batch size.
+    BatchChunkProgress func(lo, hi, total int)
+
+    // AutoHashOversize turns values longer than a field element into
+    // leaves with HashBytes instead of rejecting them. Values of up to 32
+    // bytes keep their usual leaf, so turning it on does not change the
+    // root of an existing tree. It only applies to values stored as is;
+    // with StoreHashedValues the Hasher decides.
+    AutoHashOversize bool
+
+    // AutoParallelism has NewMerkleTree pick Parallelism itself by timing
+    // a sample batch with 1 up to runtime.NumCPU() workers; see
+    // WithAutoParallelism. An explicit Parallelism is left alone.
+    AutoParallelism bool
+
+    // RetainedVersions is how many recent roots RootAt can return. Zero
+    // means defaultRetainedVersions.
+    RetainedVersions int
+
+    // PersistNodes also stores every internal node under node/, updated in
+    // the transaction of each mutation, so VerifyLeafFromDB can check a leaf
+    // against the database without loading the tree. Proofs are then read
+    // from the stored nodes instead of the C tree, except while optimistic
+    // adds are pending.
+    PersistNodes bool
+}
+
+// Options is the former, exported name of the tree configuration.
+//
+// Deprecated: configure trees with the With* Option functions passed to
+// NewMerkleTree. The fields are not part of the stable API.
+type Options = config
+
+const (
+    defaultBloomExpectedKeys      = 1 << 20
+    defaultBloomFalsePositiveRate = 1e-4
+)
+
+// Option configures a MerkleTree at construction time.
+type Option func(*config)
+
+// WithStoreHashedValues stores HashOne(value) instead of the raw value, for
+// privacy or to save space. Get then returns the hash.
+func WithStoreHashedValues() Option {
+    return func(o *config) {
+        o.StoreHashedValues = true
+    }
+}
+
+// WithEmptyLeaf sets the value used for empty leaf slots. Poseidon setups
+// disagree on whether an empty leaf is zero or a fixed constant; trees that
+// must agree on roots need the same choice. The value must fit in a field
+// element.
+func WithEmptyLeaf(value []byte) Option {
+    return func(o *config) {
+        o.EmptyLeaf = append([]byte(nil), value...)
+    }
+}
+
+// WithNoPersistence keeps the tree purely in memory, for ephemeral trees and
+// tests. The database passed to NewMerkleTree may then be nil.
+func WithNoPersistence() Option {
+    return func(o *config) {
+        o.NoPersistence = true
+    }
+}
+
+// WithStorePreimages records the original value behind every leaf; see
+// LookupPreimage.
+func WithStorePreimages() Option {
+    return func(o *config) {
+        o.StorePreimages = true
+    }
+}
+
+// WithRootProgress calls fn with the leaf count and current root every time
+// the tree reaches a multiple of every leaves, including the intermediate
+// sizes passed through inside a single AddBatch.
+func WithRootProgress(every int, fn func(count int, root []byte)) Option {
+    return func(o *config) {
+        o.ProgressEvery = every
+        o.Progress = fn
+    }
+}
+
+// WithDBOnlyIndex drops the in-memory key index and resolves every key from
+// the database, for trees with too many keys to mirror in memory.
+func WithDBOnlyIndex() Option {
+    return func(o *config) {
+        o.DBOnlyIndex = true
+    }
+}
+
+// WithSortedChildren hashes the smaller child of every internal node first,
+// as some circuits expect; see Options.SortedChildren.
+func WithSortedChildren() Option {
+    return func(o *config) {
+        o.SortedChildren = true
+    }
+}
+
+// WithMaxDepth bounds the tree to 2^depth leaves. Adds beyond that fail with
+// ErrTreeFull.
+func WithMaxDepth(depth int) Option {
+    return func(o *config) {
+        o.MaxDepth = depth
+    }
+}
+
+// WithBloomFilter enables the key Bloom filter sized for expectedKeys keys
+// at false-positive rate fpRate; see Options.UseBloomFilter.
+func WithBloomFilter(expectedKeys int, fpRate float64) Option {
+    return func(o *config) {
+        o.UseBloomFilter = true
+        o.BloomExpectedKeys = expectedKeys
+        o.BloomFalsePositiveRate = fpRate
+    }
+}
+
+// WithByteOrder sets the byte order of field elements at the API boundary;
+// see Options.ByteOrder.
+func WithByteOrder(order binary.ByteOrder) Option {
+    return func(o *config) {
+        o.ByteOrder = order
+    }
+}
+
+// WithProofLRU caches up to size proofs in memory; see Options.ProofLRUSize.
+func WithProofLRU(size int) Option {
+    return func(o *config) {
+        o.ProofLRUSize = size
+    }
+}
+
+// WithProofCache persists the proofs GenProof computes for ttl, so repeated
+// requests for a key are served from the database, even across restarts,
+// until the proof expires or the root changes.
+func WithProofCache(ttl time.Duration) Option {
+    return func(o *config) {
+        o.ProofCacheTTL = ttl
+    }
+}
+
+// WithVerifyOnGen verifies every generated proof before it is returned;
+// see Options.VerifyOnGen.
+func WithVerifyOnGen() Option {
+    return func(o *config) {
+        o.VerifyOnGen = true
+    }
+}
+
+// WithAutoGrow deepens the tree as it fills instead of failing with
+// ErrTreeFull; see Options.AutoGrow.
+func WithAutoGrow() Option {
+    return func(o *config) {
+        o.AutoGrow = true
+    }
+}
+
+// WithWideLeaves lets each value span n field elements; see
+// Options.WideLeaves.
+func WithWideLeaves(n int) Option {
+    return func(o *config) {
+        o.WideLeaves = n
+    }
+}
+
+// WithRebuildThreshold sets Options.RebuildThreshold.
+func WithRebuildThreshold(fraction float64) Option {
+    return func(o *config) {
+        o.RebuildThreshold = fraction
+    }
+}
+
+// WithLogger sets the structured logger; see Options.Logger. It takes a
+// pointer, as slog.Logger values are not meant to be copied.
+func WithLogger(logger *slog.Logger) Option {
+    return func(o *config) {
+        o.Logger = logger
+    }
+}
+
+// WithPrefix stores every record of the tree under prefix in the database
+// passed to NewMerkleTree or LoadMerkleTree, as Forest does for its trees.
+func WithPrefix(prefix []byte) Option {
+    return func(o *config) {
+        o.Prefix = append([]byte(nil), prefix...)
+    }
+}
+
+// WithDepth bounds the tree to 2^depth leaves. It is WithMaxDepth under the
+// name used by other Merkle tree packages.
+func WithDepth(depth int) Option {
+    return WithMaxDepth(depth)
+}
+
+// WithHasher hashes values into leaves with h instead of Poseidon when
+// StoreHashedValues is set; see Hasher.
+func WithHasher(h Hasher) Option {
+    return func(o *config) {
+        o.Hasher = h
+    }
+}
+
+// WithAutoParallelism picks the number of goroutines AddBatch uses with a
+// short benchmark when the tree is created; see Options.AutoParallelism and
+// MerkleTree.Parallelism.
+func WithAutoParallelism() Option {
+    return func(o *config) {
+        o.AutoParallelism = true
+    }
+}
+
+// WithMaxBatchSize splits batches larger than n leaves; see
+// Options.MaxBatchSize.
+func WithMaxBatchSize(n int) Option {
+    return func(o *config) {
+        o.MaxBatchSize = n
+    }
+}
+
+// WithBatchChunkProgress reports each chunk of a split batch to fn; see
+// Options.BatchChunkProgress.
+func WithBatchChunkProgress(fn func(lo, hi, total int)) Option {
+    return func(o *config) {
+        o.BatchChunkProgress = fn
+    }
+}
+
+// WithAutoHashOversize accepts values longer than 32 bytes by hashing
+// them; see Options.AutoHashOversize.
+func WithAutoHashOversize() Option {
+    return func(o *config) {
+        o.AutoHashOversize = true
+    }
+}
+
+// WithRetainedVersions keeps the roots of the last n versions for RootAt
+// and VerifyAtVersion.
+func WithRetainedVersions(n int) Option {
+    return func(o *config) {
+        o.RetainedVersions = n
+    }
+}
+
+// WithKeyedLeaves binds every leaf to its key; see Options.KeyedLeaves and
+// VerifyKeyedProof.
+func WithKeyedLeaves() Option {
+    return func(o *config) {
+        o.KeyedLeaves = true
+        o.StoreHashedValues = true
+    }
+}
+
+// WithParallelism sets the number of goroutines AddBatch uses; see
+// Options.Parallelism.
+func WithParallelism(n int) Option {
+    return func(o *config) {
+        o.Parallelism = n
+    }
+}
+
+// WithPersistNodes stores the internal nodes of the tree; see
+// Options.PersistNodes.
+func WithPersistNodes() Option {
+    return func(o *config) {
+        o.PersistNodes = true
+    }
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "testing"
+)
+
+// TestEmptyLeafChangesPaddedRoot pads slot 1 of two trees, one with the
+// default zero empty leaf and one with a constant, by deleting its key.
+func TestEmptyLeafChangesPaddedRoot(t *testing.T) {
+    keys, values := testLeaves(4)
+    constant := encodeUint64(7)
+
+    zero := newTestTree(t)
+    addAll(t, zero, keys, values)
+    seven := newTestTree(t, WithEmptyLeaf(constant))
+    addAll(t, seven, keys, values)
+    if !bytes.Equal(zero.Root(), seven.Root()) {
+        t.Fatal("the empty leaf changed the root of a tree without empty slots")
+    }
+
+    for _, tree := range []*MerkleTree{zero, seven} {
+        if err := tree.Delete(keys[1]); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if bytes.Equal(zero.Root(), seven.Root()) {
+        t.Fatalf("empty leaves zero and %x give the same padded root %x", constant, zero.Root())
+    }
+
+    // The padded slot holds the constant, as if it were the value there.
+    padded := append([][]byte(nil), values...)
+    padded[1] = constant
+    want := newTestTree(t, WithNoPersistence())
+    addAll(t, want, keys, padded)
+    if !bytes.Equal(seven.Root(), want.Root()) {
+        t.Fatalf("padded root %x, want %x", seven.Root(), want.Root())
+    }
+}
+
+// TestByteOrderRoots adds the same integers to a little-endian and a
+// big-endian tree, each in its own byte order.
+func TestByteOrderRoots(t *testing.T) {
+    keys, values := testLeaves(5)
+    reversed := func(b []byte) []byte {
+        out := make([]byte, len(b))
+        for i := range b {
+            out[len(b)-1-i] = b[i]
+        }
+        return out
+    }
+
+    for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+        t.Run(order.String(), func(t *testing.T) {
+            little := newTestTree(t, WithByteOrder(binary.LittleEndian))
+            addAll(t, little, keys, values)
+
+            tree := newTestTree(t, WithByteOrder(order))
+            for i, key := range keys {
+                value := values[i]
+                if order == binary.BigEndian {
+                    value = reversed(value)
+                }
+                if err := tree.Add(key, value); err != nil {
+                    t.Fatal(err)
+                }
+                got, err := tree.Get(key)
+                if err != nil {
+                    t.Fatal(err)
+                }
+                if !bytes.Equal(got, value) {
+                    t.Fatalf("get %x, want the value as added %x", got, value)
+                }
+            }
+
+            // The trees hold the same field elements; only the root's
+            // bytes follow the byte order.
+            want := little.Root()
+            if order == binary.BigEndian {
+                want = reversed(want)
+            }
+            if !bytes.Equal(tree.Root(), want) {
+                t.Fatalf("root %x, want %x", tree.Root(), want)
+            }
+            proof, err := tree.GenProof(keys[2])
+            if err != nil {
+                t.Fatal(err)
+            }
+            if ok, err := VerifyProof(little.Root(), proof); err != nil || !ok {
+                t.Fatalf("proof against the in-memory root: %v, %v", ok, err)
+            }
+        })
+    }
+}
+
+// TestVerifyOnGenCatchesCorruptPath changes a leaf in the C tree behind the
+// tree's back, so the path read for it no longer matches the leaf kept in
+// memory.
+func TestVerifyOnGenCatchesCorruptPath(t *testing.T) {
+    for _, verify := range []bool{false, true} {
+        var opts []Option
+        if verify {
+            opts = append(opts, WithVerifyOnGen())
+        }
+        tree := newTestTree(t, opts...)
+        keys, values := testLeaves(4)
+        addAll(t, tree, keys, values)
+
+        field := make([]byte, 32)
+        copy(field, encodeUint64(1000))
+        corrupt, err := bytesToFp(field)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if err := tree.setLeaf(1, tree.values[1], corrupt); err != nil {
+            t.Fatal(err)
+        }
+
+        proof, err := tree.GenProof(keys[1])
+        if verify {
+            if !errors.Is(err, ErrProofMismatch) {
+                t.Fatalf("proof over a corrupt C tree = %v, want ErrProofMismatch", err)
+            }
+            continue
+        }
+        if err != nil {
+            t.Fatal(err)
+        }
+        if ok, _ := VerifyProof(tree.Root(), proof); ok {
+            t.Fatal("corrupting the C tree left the proof valid")
+        }
+    }
+}
+
+func TestAutoGrowKeepsOldProofs(t *testing.T) {
+    keys, values := testLeaves(10)
+
+    full := newTestTree(t, WithMaxDepth(2))
+    addAll(t, full, keys[:4], values[:4])
+    if err := full.Add(keys[4], values[4]); !errors.Is(err, ErrTreeFull) {
+        t.Fatalf("fifth add without AutoGrow = %v, want ErrTreeFull", err)
+    }
+
+    tree := newTestTree(t, WithMaxDepth(2), WithAutoGrow())
+    addAll(t, tree, keys[:4], values[:4])
+    if d := tree.Depth(); d != 2 {
+        t.Fatalf("depth %d with 4 leaves, want 2", d)
+    }
+    oldRoot := tree.Root()
+    oldProofs := make([]*MerkleProof, 4)
+    for i := range oldProofs {
+        proof, err := tree.GenProof(keys[i])
+        if err != nil {
+            t.Fatal(err)
+        }
+        oldProofs[i] = proof
+    }
+
+    addAll(t, tree, keys[4:5], values[4:5])
+    if d := tree.Depth(); d != 3 {
+        t.Fatalf("depth %d with 5 leaves, want 3", d)
+    }
+    if err := tree.AddBatch(keys[5:], values[5:]); err != nil {
+        t.Fatal(err)
+    }
+    if d := tree.Depth(); d != 4 {
+        t.Fatalf("depth %d with 10 leaves, want 4", d)
+    }
+
+    for i, proof := range oldProofs {
+        ok, err := VerifyProof(oldRoot, proof)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !ok {
+            t.Fatalf("proof of %s from before the grow no longer verifies against its root", keys[i])
+        }
+    }
+    for i, key := range keys {
+        if err := tree.CheckProof(key, values[i]); err != nil {
+            t.Fatal(err)
+        }
+    }
+}
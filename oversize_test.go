@@ -0,0 +1,51 @@
+package main
+
+import (
+    "bytes"
+    "errors"
+    "testing"
+
+    "github.com/Aquariumdevs/poseidontree/poseidontreetest"
+)
+
+func TestOversizeValue(t *testing.T) {
+    key := []byte("key")
+    value := bytes.Repeat([]byte("largeValue"), 5) // 50 bytes
+
+    t.Run("error", func(t *testing.T) {
+        tree := newTestTree(t)
+        if err := tree.Add(key, value); !errors.Is(err, ErrValueTooLarge) {
+            t.Fatalf("Add of %d bytes = %v, want ErrValueTooLarge", len(value), err)
+        }
+        if ok, err := tree.Has(key); err != nil || ok {
+            t.Errorf("Has after the failed Add = %v, %v", ok, err)
+        }
+    })
+
+    t.Run("AutoHashOversize", func(t *testing.T) {
+        tree := newTestTree(t, WithAutoHashOversize())
+        if err := tree.Add(key, value); err != nil {
+            t.Fatal(err)
+        }
+        got, err := tree.Get(key)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !bytes.Equal(got, value) {
+            t.Errorf("Get = %q, want the value as given", got)
+        }
+
+        want, err := HashBytes(value)
+        if err != nil {
+            t.Fatal(err)
+        }
+        leaf, err := tree.LeafHash(key)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !bytes.Equal(leaf, want) {
+            t.Errorf("leaf %x, want HashBytes(value) %x", leaf, want)
+        }
+        poseidontreetest.AssertProof(t, tree, key, value)
+    })
+}
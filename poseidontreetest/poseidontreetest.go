@@ -0,0 +1,25 @@
+// Package poseidontreetest holds helpers for tests that use a Poseidon
+// Merkle tree.
+//
+// The tree itself lives in a main package, which cannot be imported, so the
+// helpers take the small interface below rather than *MerkleTree. Tests
+// inside the main package pass their *MerkleTree directly.
+package poseidontreetest
+
+import "testing"
+
+// Tree is the part of *MerkleTree the helpers use.
+type Tree interface {
+    // CheckProof generates the proof of key and checks it against value
+    // and the current root.
+    CheckProof(key, value []byte) error
+}
+
+// AssertProof fails the test unless tree proves key holds value against its
+// current root. The failure message includes the key and root in hex.
+func AssertProof(t testing.TB, tree Tree, key, value []byte) {
+    t.Helper()
+    if err := tree.CheckProof(key, value); err != nil {
+        t.Fatal(err)
+    }
+}
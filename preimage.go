@@ -0,0 +1,64 @@
+package main
+
+import (
+    "encoding/hex"
+    "errors"
+
+    "go.vocdoni.io/dvote/db"
+)
+
+// prefixPreimage namespaces the preimage registry: pre/<hex leaf> -> value.
+var prefixPreimage = []byte("pre/")
+
+// PreimageRegistry maps a leaf field element back to the original value it
+// was derived from. It shares the tree's database.
+type PreimageRegistry struct {
+    db db.Database
+}
+
+// NewPreimageRegistry returns a registry stored in database.
+func NewPreimageRegistry(database db.Database) *PreimageRegistry {
+    return &PreimageRegistry{db: database}
+}
+
+func preimageKey(leaf []byte) []byte {
+    return dbKey(prefixPreimage, []byte(hex.EncodeToString(leaf)))
+}
+
+// put records value as the preimage of leaf within txn.
+func (r *PreimageRegistry) put(txn db.WriteTx, leaf, value []byte) error {
+    return txn.Set(preimageKey(leaf), value)
+}
+
+// Lookup returns the preimage recorded for leaf, if any.
+func (r *PreimageRegistry) Lookup(leaf []byte) ([]byte, bool, error) {
+    rtx := r.db.ReadTx()
+    defer rtx.Discard()
+
+    value, err := rtx.Get(preimageKey(leaf))
+    if errors.Is(err, db.ErrKeyNotFound) {
+        return nil, false, nil
+    }
+    if err != nil {
+        return nil, false, err
+    }
+    return value, true, nil
+}
+
+// LookupPreimage returns the original value of the leaf fp. It requires the
+// tree to have been built with StorePreimages.
+func (tree *MerkleTree) LookupPreimage(fp [4]uint64) ([]byte, bool, error) {
+    if tree.preimages == nil {
+        return nil, false, errors.New("tree does not store preimages")
+    }
+    return tree.preimages.Lookup(limbsToBytes(fp))
+}
+
+// putPreimage records value as the preimage of leaf if the tree stores
+// preimages.
+func (tree *MerkleTree) putPreimage(txn db.WriteTx, leaf, value []byte) error {
+    if tree.preimages == nil {
+        return nil
+    }
+    return tree.preimages.put(txn, leaf, value)
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "sort"
+)
+
+// MerkleProof proves that Leaf is the leaf at Index of a tree holding Size
+// leaves.
+//
+// Siblings runs from the leaf level up to the root. A node that is the odd
+// last node of its level has no sibling and is carried up unhashed, so that
+// level contributes no entry; Index and Size together determine which
+// levels those are.
+//
+// SortedChildren records that the tree was built WithSortedChildren, so each
+// parent hashes its smaller child first rather than its left child.
+type MerkleProof struct {
+    Index          uint
+    Size           uint
+    Leaf           []byte
+    Siblings       [][]byte
+    SortedChildren bool
+}
+
+// treeDepth returns the number of levels above the leaves in a tree of size
+// leaves.
+func treeDepth(size uint) int {
+    depth := 0
+    for width := size; width > 1; width = (width + 1) / 2 {
+        depth++
+    }
+    return depth
+}
+
+// PathIndexBits returns the direction of the proven leaf's path as one bit
+// per level, leaf level first, packed little-endian: bit l of the result is
+// bit l%8 of byte l/8, and is 1 when the path node at level l is a right
+// child. The bits are the low treeDepth(Size) bits of Index; a level where
+// the node is carried up without a sibling always has bit 0.
+func (p *MerkleProof) PathIndexBits() []byte {
+    depth := treeDepth(p.Size)
+    bits := make([]byte, (depth+7)/8)
+    for level := 0; level < depth; level++ {
+        if (p.Index>>uint(level))&1 == 1 {
+            bits[level/8] |= 1 << uint(level%8)
+        }
+    }
+    return bits
+}
+
+// ParsePathIndexBits is the inverse of PathIndexBits: it returns the leaf
+// index encoded by the first depth bits of bits.
+func ParsePathIndexBits(bits []byte, depth int) (uint, error) {
+    if depth < 0 || len(bits)*8 < depth {
+        return 0, fmt.Errorf("%d path bytes cannot hold %d levels", len(bits), depth)
+    }
+    var index uint
+    for level := 0; level < depth; level++ {
+        if bits[level/8]>>uint(level%8)&1 == 1 {
+            index |= 1 << uint(level)
+        }
+    }
+    return index, nil
+}
+
+// PathBits returns the path from the root down to the leaf at index in a
+// tree of the given depth: element 0 is the step taken at the root and the
+// last element the step into the leaf, with true meaning the right child.
+// It is the low depth bits of index, most significant first.
+func PathBits(index uint, depth int) []bool {
+    bits := make([]bool, depth)
+    for i := range bits {
+        bits[i] = (index>>uint(depth-1-i))&1 == 1
+    }
+    return bits
+}
+
+// PathIndex is the inverse of PathBits: it returns the leaf index reached by
+// following bits down from the root.
+func PathIndex(bits []bool) uint {
+    var index uint
+    for _, right := range bits {
+        index <<= 1
+        if right {
+            index |= 1
+        }
+    }
+    return index
+}
+
+// RootFromProof recomputes the root of the tree p was generated from by
+// hashing the leaf up through the siblings.
+func RootFromProof(p *MerkleProof) ([]byte, error) {
+    nodes, err := pathNodes(p)
+    if err != nil {
+        return nil, err
+    }
+    return nodes[len(nodes)-1], nil
+}
+
+// pathNodes returns the nodes on the path of p from its leaf, at index 0,
+// up to the root it implies, one per level.
+func pathNodes(p *MerkleProof) ([][]byte, error) {
+    if p.Index >= p.Size {
+        return nil, ErrIndexOutOfRange
+    }
+
+    node := p.Leaf
+    nodes := [][]byte{node}
+    siblings := p.Siblings
+    path := PathBits(p.Index, treeDepth(p.Size))
+    idx, width := p.Index, p.Size
+    for level := 0; width > 1; level++ {
+        right := path[len(path)-1-level]
+        if right || idx+1 < width {
+            if len(siblings) == 0 {
+                return nil, errors.New("merkle proof has too few siblings")
+            }
+            var err error
+            if right {
+                node, err = hashChildren(siblings[0], node, p.SortedChildren)
+            } else {
+                node, err = hashChildren(node, siblings[0], p.SortedChildren)
+            }
+            if err != nil {
+                return nil, err
+            }
+            siblings = siblings[1:]
+        }
+        nodes = append(nodes, node)
+        idx, width = idx/2, (width+1)/2
+    }
+
+    if len(siblings) != 0 {
+        return nil, errors.New("merkle proof has unused siblings")
+    }
+    return nodes, nil
+}
+
+// VerifyProof reports whether p proves its leaf against root.
+func VerifyProof(root []byte, p *MerkleProof) (bool, error) {
+    computed, err := RootFromProof(p)
+    if err != nil {
+        return false, err
+    }
+    return fpEqual(computed, root), nil
+}
+
+// VerifyKeyedProof reports whether p, from a tree built WithKeyedLeaves,
+// proves that key holds value against root. value is given as for
+// KeyedLeaf. A proof for another key, or for the same key with another
+// value, fails.
+func VerifyKeyedProof(root, key, value []byte, p *MerkleProof) (bool, error) {
+    leaf, err := KeyedLeaf(key, value)
+    if err != nil {
+        return false, err
+    }
+    if !fpEqual(leaf, p.Leaf) {
+        return false, nil
+    }
+    return VerifyProof(root, p)
+}
+
+// Verify reports whether p proves its leaf against root; it is VerifyProof
+// as a method.
+func (p *MerkleProof) Verify(root []byte) (bool, error) {
+    return VerifyProof(root, p)
+}
+
+// Serialized proofs start with a format version and the identifier of the
+// hash scheme the proof was built with, so a verifier can tell a proof it
+// cannot check from a proof that is invalid.
+const (
+    proofVersion1 = 1
+
+    // hashSchemePoseidonKimchi is Poseidon with the Kimchi parameters over
+    // the Pasta Fp field, as implemented by lib.rs.
+    hashSchemePoseidonKimchi = 1
+
+    // hashSchemePoseidonKimchiSorted is hashSchemePoseidonKimchi with each
+    // parent hashing its smaller child first (WithSortedChildren).
+    hashSchemePoseidonKimchiSorted = 2
+)
+
+// MarshalBinary encodes the proof as
+//
+//     version | hash scheme | uvarint index | uvarint size | leaf |
+//     uvarint sibling count | siblings
+//
+// with the leaf and every sibling fpSize bytes long.
+func (p *MerkleProof) MarshalBinary() ([]byte, error) {
+    if len(p.Leaf) != fpSize {
+        return nil, fmt.Errorf("leaf is %d bytes, want %d", len(p.Leaf), fpSize)
+    }
+
+    scheme := byte(hashSchemePoseidonKimchi)
+    if p.SortedChildren {
+        scheme = hashSchemePoseidonKimchiSorted
+    }
+    out := []byte{proofVersion1, scheme}
+    out = binary.AppendUvarint(out, uint64(p.Index))
+    out = binary.AppendUvarint(out, uint64(p.Size))
+    out = append(out, p.Leaf...)
+    out = binary.AppendUvarint(out, uint64(len(p.Siblings)))
+    for i, sibling := range p.Siblings {
+        if len(sibling) != fpSize {
+            return nil, fmt.Errorf("sibling %d is %d bytes, want %d", i, len(sibling), fpSize)
+        }
+        out = append(out, sibling...)
+    }
+    return out, nil
+}
+
+// UnmarshalBinary decodes a proof written by MarshalBinary. It rejects
+// unknown format versions and hash schemes.
+func (p *MerkleProof) UnmarshalBinary(data []byte) error {
+    errMalformed := errors.New("malformed merkle proof")
+
+    if len(data) < 2 {
+        return errMalformed
+    }
+    if data[0] != proofVersion1 {
+        return fmt.Errorf("unsupported merkle proof version %d", data[0])
+    }
+    if data[1] != hashSchemePoseidonKimchi && data[1] != hashSchemePoseidonKimchiSorted {
+        return fmt.Errorf("unsupported merkle proof hash scheme %d", data[1])
+    }
+    sorted := data[1] == hashSchemePoseidonKimchiSorted
+    data = data[2:]
+
+    readUvarint := func() (uint64, error) {
+        v, n := binary.Uvarint(data)
+        if n <= 0 {
+            return 0, errMalformed
+        }
+        data = data[n:]
+        return v, nil
+    }
+
+    index, err := readUvarint()
+    if err != nil {
+        return err
+    }
+    size, err := readUvarint()
+    if err != nil {
+        return err
+    }
+    if len(data) < fpSize {
+        return errMalformed
+    }
+    leaf := append([]byte(nil), data[:fpSize]...)
+    data = data[fpSize:]
+
+    count, err := readUvarint()
+    if err != nil {
+        return err
+    }
+    if count != uint64(len(data)/fpSize) || len(data)%fpSize != 0 {
+        return errMalformed
+    }
+    siblings := make([][]byte, count)
+    for i := range siblings {
+        siblings[i] = append([]byte(nil), data[i*fpSize:(i+1)*fpSize]...)
+    }
+
+    *p = MerkleProof{
+        Index:          uint(index),
+        Size:           uint(size),
+        Leaf:           leaf,
+        Siblings:       siblings,
+        SortedChildren: sorted,
+    }
+    return nil
+}
+
+// ExclusionProof proves that a key is absent by showing the leaves holding
+// its nearest neighbours in key order sit next to each other in the tree.
+// Left is nil when the key sorts before every key in the tree and Right is
+// nil when it sorts after every key.
+//
+// Absence follows from adjacency only if the tree's leaves are in key
+// order. Exclusion proofs therefore need a tree built WithKeyedLeaves, so
+// each neighbour's leaf commits to its key, and WithStorePreimages, which
+// keeps the value each keyed leaf was derived from, whose keys were
+// inserted in ascending order and never deleted. GenExclusionProof checks
+// the options, the order of the keys and that the neighbours are adjacent;
+// a verifier cannot see the order in the proof and must know it of the
+// tree.
+//
+// Two fields go beyond a bare pair of neighbours. LeftValue and RightValue
+// are the neighbours' values as given to VerifyKeyedProof; a keyed leaf
+// hashes key and value together, so the key cannot be checked without the
+// value. The neighbour proofs are *MerkleProof, the proof type of the rest
+// of the package, and are nil along with a missing neighbour.
+type ExclusionProof struct {
+    Left, Right           LeafKey
+    LeftValue, RightValue []byte
+    LeftProof, RightProof *MerkleProof
+    Root                  []byte
+}
+
+// GenExclusionProof returns a proof that key is not in the tree; see
+// ExclusionProof. It fails if the key exists or the tree lacks KeyedLeaves
+// or StorePreimages, and with ErrNotKeyOrdered if the leaves are not in key
+// order, in which case absence cannot be shown from neighbours alone.
+func (tree *MerkleTree) GenExclusionProof(key []byte) (ExclusionProof, error) {
+    if !tree.opts.KeyedLeaves || tree.preimages == nil {
+        return ExclusionProof{}, errors.New("exclusion proofs need WithKeyedLeaves and WithStorePreimages")
+    }
+    indexes := make(map[string]int)
+    err := tree.eachIndex(func(k []byte, idx int) bool {
+        indexes[string(k)] = idx
+        return true
+    })
+    if err != nil {
+        return ExclusionProof{}, err
+    }
+    if _, exists := indexes[string(key)]; exists {
+        return ExclusionProof{}, ErrKeyExists
+    }
+    if len(indexes) == 0 {
+        return ExclusionProof{}, ErrEmptyTree
+    }
+
+    sorted := make([]string, 0, len(indexes))
+    for k := range indexes {
+        sorted = append(sorted, k)
+    }
+    sort.Strings(sorted)
+    for i := 1; i < len(sorted); i++ {
+        if indexes[sorted[i]] < indexes[sorted[i-1]] {
+            return ExclusionProof{}, fmt.Errorf("key %x is at leaf %d, before %x at leaf %d: %w", sorted[i], indexes[sorted[i]], sorted[i-1], indexes[sorted[i-1]], ErrNotKeyOrdered)
+        }
+    }
+
+    pos := sort.Search(len(sorted), func(i int) bool {
+        return bytes.Compare([]byte(sorted[i]), key) > 0
+    })
+
+    proof := ExclusionProof{Root: tree.root()}
+    leftIdx, rightIdx := -1, len(tree.values)
+    if pos > 0 {
+        proof.Left = LeafKey(sorted[pos-1])
+        leftIdx = indexes[sorted[pos-1]]
+    }
+    if pos < len(sorted) {
+        proof.Right = LeafKey(sorted[pos])
+        rightIdx = indexes[sorted[pos]]
+    }
+    if rightIdx != leftIdx+1 {
+        return ExclusionProof{}, fmt.Errorf("neighbours of key %x are leaves %d and %d: %w", key, leftIdx, rightIdx, ErrNotKeyOrdered)
+    }
+
+    if proof.Left != nil {
+        if proof.LeftValue, proof.LeftProof, err = tree.neighbourProof(proof.Left); err != nil {
+            return ExclusionProof{}, err
+        }
+    }
+    if proof.Right != nil {
+        if proof.RightValue, proof.RightProof, err = tree.neighbourProof(proof.Right); err != nil {
+            return ExclusionProof{}, err
+        }
+    }
+
+    return proof, nil
+}
+
+// neighbourProof returns the proof of key for an ExclusionProof, with the
+// value its keyed leaf was derived from.
+func (tree *MerkleTree) neighbourProof(key []byte) ([]byte, *MerkleProof, error) {
+    p, err := tree.GenProof(key)
+    if err != nil {
+        return nil, nil, err
+    }
+    value, ok, err := tree.preimages.Lookup(p.Leaf)
+    if err != nil {
+        return nil, nil, err
+    }
+    if !ok {
+        return nil, nil, fmt.Errorf("no preimage recorded for the leaf of key %x", key)
+    }
+    return tree.fromAPI(value), p, nil
+}
+
+// VerifyExclusionProof reports whether p proves that key is absent from the
+// key-ordered tree with the given root: each neighbour present must hold a
+// keyed leaf for its key in the tree, sort on its side of key, and the two
+// must be adjacent leaves, or the first or last leaf when the other is
+// missing.
+func VerifyExclusionProof(root, key []byte, p ExclusionProof) (bool, error) {
+    if p.Left == nil && p.Right == nil {
+        return false, nil
+    }
+    if p.Left != nil {
+        if p.LeftProof == nil || bytes.Compare(p.Left, key) >= 0 {
+            return false, nil
+        }
+        if ok, err := VerifyKeyedProof(root, p.Left, p.LeftValue, p.LeftProof); !ok || err != nil {
+            return false, err
+        }
+    }
+    if p.Right != nil {
+        if p.RightProof == nil || bytes.Compare(key, p.Right) >= 0 {
+            return false, nil
+        }
+        if ok, err := VerifyKeyedProof(root, p.Right, p.RightValue, p.RightProof); !ok || err != nil {
+            return false, err
+        }
+    }
+
+    switch {
+    case p.Left == nil:
+        return p.RightProof.Index == 0, nil
+    case p.Right == nil:
+        return p.LeftProof.Index == p.LeftProof.Size-1, nil
+    default:
+        return p.LeftProof.Size == p.RightProof.Size && p.RightProof.Index == p.LeftProof.Index+1, nil
+    }
+}
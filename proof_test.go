@@ -0,0 +1,371 @@
+package main
+
+import (
+    "bytes"
+    "errors"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func TestGenProofBatchMatchesGenProof(t *testing.T) {
+    tree := newTestTree(t)
+    keys, values := testLeaves(33)
+    if err := tree.AddBatch(keys, values); err != nil {
+        t.Fatal(err)
+    }
+
+    proofs, err := tree.GenProofBatch(keys)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for i, key := range keys {
+        want, err := tree.GenProof(key)
+        if err != nil {
+            t.Fatal(err)
+        }
+        got, err := proofs[i].MarshalBinary()
+        if err != nil {
+            t.Fatal(err)
+        }
+        wantBytes, err := want.MarshalBinary()
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !bytes.Equal(got, wantBytes) {
+            t.Errorf("batch proof of %s differs from GenProof", key)
+        }
+    }
+}
+
+// BenchmarkGenProofBatch compares proving 256 keys one GenProof at a time,
+// which allocates a path buffer per key, with one GenProofBatch, which
+// allocates one for the batch. B/op differs by about a buffer per key.
+func BenchmarkGenProofBatch(b *testing.B) {
+    tree := newTestTree(b)
+    keys, values := testLeaves(256)
+    if err := tree.AddBatch(keys, values); err != nil {
+        b.Fatal(err)
+    }
+
+    b.Run("each", func(b *testing.B) {
+        b.ReportAllocs()
+        for i := 0; i < b.N; i++ {
+            for _, key := range keys {
+                if _, err := tree.GenProof(key); err != nil {
+                    b.Fatal(err)
+                }
+            }
+        }
+    })
+    b.Run("batch", func(b *testing.B) {
+        b.ReportAllocs()
+        for i := 0; i < b.N; i++ {
+            if _, err := tree.GenProofBatch(keys); err != nil {
+                b.Fatal(err)
+            }
+        }
+    })
+}
+
+func TestProofEncodingVersions(t *testing.T) {
+    // A version 1 blob written out by hand: index 5 of 8, the leaf and
+    // three siblings, each a field element of repeated bytes.
+    element := func(b byte) []byte { return bytes.Repeat([]byte{b}, fpSize) }
+    v1 := []byte{proofVersion1, hashSchemePoseidonKimchi, 5, 8}
+    v1 = append(v1, element(0x01)...)
+    v1 = append(v1, 3)
+    v1 = append(append(append(v1, element(0x02)...), element(0x03)...), element(0x04)...)
+
+    var p MerkleProof
+    if err := p.UnmarshalBinary(v1); err != nil {
+        t.Fatalf("decoding a v1 blob: %v", err)
+    }
+    want := MerkleProof{
+        Index:    5,
+        Size:     8,
+        Leaf:     element(0x01),
+        Siblings: [][]byte{element(0x02), element(0x03), element(0x04)},
+    }
+    if !reflect.DeepEqual(p, want) {
+        t.Fatalf("decoded %+v, want %+v", p, want)
+    }
+    again, err := p.MarshalBinary()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(again, v1) {
+        t.Fatalf("re-encoded %x, want the v1 blob %x", again, v1)
+    }
+
+    // A proof from a tree round-trips too.
+    keys, values := testLeaves(8)
+    tree := newTestTree(t)
+    addAll(t, tree, keys, values)
+    proof, err := tree.GenProof(keys[5])
+    if err != nil {
+        t.Fatal(err)
+    }
+    blob, err := proof.MarshalBinary()
+    if err != nil {
+        t.Fatal(err)
+    }
+    var decoded MerkleProof
+    if err := decoded.UnmarshalBinary(blob); err != nil {
+        t.Fatal(err)
+    }
+    if ok, err := decoded.Verify(tree.Root()); err != nil || !ok {
+        t.Fatalf("decoded proof: %v, %v", ok, err)
+    }
+
+    forged := append([]byte{99}, v1[1:]...)
+    err = new(MerkleProof).UnmarshalBinary(forged)
+    if err == nil || !strings.Contains(err.Error(), "version 99") {
+        t.Fatalf("decoding a v99 blob = %v, want an unsupported version error", err)
+    }
+}
+
+func TestPathIndexBitsRoundTrip(t *testing.T) {
+    keys, values := testLeaves(256)
+    tree := newTestTree(t)
+    if err := tree.AddBatch(keys, values); err != nil {
+        t.Fatal(err)
+    }
+
+    proofs, err := tree.GenProofBatch(keys)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for i, proof := range proofs {
+        bits := proof.PathIndexBits()
+        // Eight levels fit one byte, the index itself with level 0 in the
+        // lowest bit.
+        if !bytes.Equal(bits, []byte{byte(i)}) {
+            t.Fatalf("path bits of leaf %d = %08b", i, bits)
+        }
+        index, err := ParsePathIndexBits(bits, 8)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if index != uint(i) {
+            t.Fatalf("path bits of leaf %d parse to %d", i, index)
+        }
+        if back := PathIndex(PathBits(uint(i), 8)); back != uint(i) {
+            t.Fatalf("PathBits of leaf %d read back as %d", i, back)
+        }
+    }
+    if _, err := ParsePathIndexBits([]byte{0}, 9); err == nil {
+        t.Fatal("parsed 9 levels from one byte")
+    }
+}
+
+func TestSortedChildrenProofsIgnorePosition(t *testing.T) {
+    keys, values := testLeaves(8)
+    sorted := newTestTree(t, WithSortedChildren())
+    addAll(t, sorted, keys, values)
+    plain := newTestTree(t)
+    addAll(t, plain, keys, values)
+    if bytes.Equal(sorted.Root(), plain.Root()) {
+        t.Fatal("sorting children did not change the root")
+    }
+
+    for i, key := range keys {
+        proof, err := sorted.GenProof(key)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !proof.SortedChildren {
+            t.Fatal("proof of a sorted tree does not say so")
+        }
+        // The position only tells which side each sibling is on, which
+        // sorting makes irrelevant: every claimed index verifies.
+        for index := range keys {
+            proof.Index = uint(index)
+            if ok, err := VerifyProof(sorted.Root(), proof); err != nil || !ok {
+                t.Fatalf("proof of leaf %d claiming index %d: %v, %v", i, index, ok, err)
+            }
+        }
+    }
+
+    proof, err := plain.GenProof(keys[5])
+    if err != nil {
+        t.Fatal(err)
+    }
+    proof.Index = 2
+    if ok, _ := VerifyProof(plain.Root(), proof); ok {
+        t.Fatal("unsorted proof of leaf 5 verified at index 2")
+    }
+}
+
+// TestLeafHashIsFirstPathNode checks that LeafHash is the node a verifier
+// starts folding from, both for reduced and for hashed leaves.
+func TestLeafHashIsFirstPathNode(t *testing.T) {
+    for name, opts := range map[string][]Option{
+        "Reduced": nil,
+        "Hashed":  {WithStoreHashedValues()},
+    } {
+        t.Run(name, func(t *testing.T) {
+            tree := newTestTree(t, opts...)
+            keys, values := testLeaves(5)
+            addAll(t, tree, keys, values)
+
+            for _, key := range keys {
+                leaf, err := tree.LeafHash(key)
+                if err != nil {
+                    t.Fatal(err)
+                }
+                proof, err := tree.GenProof(key)
+                if err != nil {
+                    t.Fatal(err)
+                }
+                nodes, err := pathNodes(proof)
+                if err != nil {
+                    t.Fatal(err)
+                }
+                if !bytes.Equal(leaf, nodes[0]) {
+                    t.Fatalf("leaf hash of %s is %x, the verifier folds %x", key, leaf, nodes[0])
+                }
+                if !bytes.Equal(nodes[len(nodes)-1], tree.Root()) {
+                    t.Fatalf("path of %s ends at %x, want the root %x", key, nodes[len(nodes)-1], tree.Root())
+                }
+            }
+        })
+    }
+}
+
+// TestMerklePathErrors checks that the C tree's error codes for a path
+// surface as distinct errors, bypassing the checks GenProof makes first.
+func TestMerklePathErrors(t *testing.T) {
+    empty := newTestTree(t)
+    if err := empty.useCTree(); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := empty.getMerklePathInto(0, newPathBuffer()); !errors.Is(err, ErrEmptyTree) {
+        t.Fatalf("path in an empty tree = %v, want ErrEmptyTree", err)
+    }
+    if _, err := empty.ProofAt(0); !errors.Is(err, ErrIndexOutOfRange) {
+        t.Fatalf("ProofAt(0) of an empty tree = %v, want ErrIndexOutOfRange", err)
+    }
+
+    tree := newTestTree(t)
+    keys, values := testLeaves(3)
+    addAll(t, tree, keys, values)
+    if _, err := tree.getMerklePathInto(3, newPathBuffer()); !errors.Is(err, ErrIndexOutOfRange) {
+        t.Fatalf("path of leaf 3 of 3 = %v, want ErrIndexOutOfRange", err)
+    }
+    if _, err := tree.ProofAt(3); !errors.Is(err, ErrIndexOutOfRange) {
+        t.Fatalf("ProofAt(3) of 3 leaves = %v, want ErrIndexOutOfRange", err)
+    }
+    if _, err := tree.getMerklePathInto(2, newPathBuffer()); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestMerkleProofVerify(t *testing.T) {
+    tree := newTestTree(t)
+    keys, values := testLeaves(6)
+    addAll(t, tree, keys, values)
+    root := tree.Root()
+
+    proof, err := tree.GenProof(keys[2])
+    if err != nil {
+        t.Fatal(err)
+    }
+    if ok, err := proof.Verify(root); err != nil || !ok {
+        t.Fatalf("valid proof: Verify = %v, %v", ok, err)
+    }
+
+    tampered := func(change func(p *MerkleProof)) *MerkleProof {
+        p := *proof
+        p.Siblings = append([][]byte(nil), proof.Siblings...)
+        change(&p)
+        return &p
+    }
+    other, err := tree.LeafHash(keys[4])
+    if err != nil {
+        t.Fatal(err)
+    }
+    for name, p := range map[string]*MerkleProof{
+        "Leaf":    tampered(func(p *MerkleProof) { p.Leaf = other }),
+        "Sibling": tampered(func(p *MerkleProof) { p.Siblings[1] = other }),
+        "Index":   tampered(func(p *MerkleProof) { p.Index = 3 }),
+    } {
+        if ok, err := p.Verify(root); err != nil || ok {
+            t.Errorf("tampered %s: Verify = %v, %v; want false, nil", name, ok, err)
+        }
+    }
+    if ok, _ := proof.Verify(other); ok {
+        t.Error("proof verifies against another root")
+    }
+    extra := tampered(func(p *MerkleProof) { p.Siblings = append(p.Siblings, other) })
+    if _, err := extra.Verify(root); err == nil {
+        t.Error("proof with an extra sibling verifies without error")
+    }
+}
+
+func TestPathBitsDepthThree(t *testing.T) {
+    const l, r = false, true
+    for index, want := range [][]bool{
+        {l, l, l},
+        {l, l, r},
+        {l, r, l},
+        {l, r, r},
+        {r, l, l},
+        {r, l, r},
+        {r, r, l},
+        {r, r, r},
+    } {
+        got := PathBits(uint(index), 3)
+        if !reflect.DeepEqual(got, want) {
+            t.Errorf("PathBits(%d, 3) = %v, want %v", index, got, want)
+        }
+        if back := PathIndex(want); back != uint(index) {
+            t.Errorf("PathIndex(%v) = %d, want %d", want, back, index)
+        }
+    }
+}
+
+func TestExclusionProof(t *testing.T) {
+    keys := [][]byte{[]byte("k10"), []byte("k20"), []byte("k30"), []byte("k40"), []byte("k50")}
+    _, values := testLeaves(len(keys))
+    tree := newTestTree(t, WithKeyedLeaves(), WithStorePreimages())
+    addAll(t, tree, keys, values)
+    root := tree.Root()
+
+    for _, absent := range []string{"k05", "k25", "k45", "k60"} {
+        proof, err := tree.GenExclusionProof([]byte(absent))
+        if err != nil {
+            t.Fatalf("%s: %v", absent, err)
+        }
+        ok, err := VerifyExclusionProof(root, []byte(absent), proof)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !ok {
+            t.Fatalf("exclusion proof of %s does not verify", absent)
+        }
+        // The neighbours of k25 do not surround k35 or k20.
+        if absent == "k25" {
+            for _, other := range []string{"k35", "k20"} {
+                if ok, _ := VerifyExclusionProof(root, []byte(other), proof); ok {
+                    t.Fatalf("exclusion proof of %s verifies for %s", absent, other)
+                }
+            }
+        }
+    }
+    if _, err := tree.GenExclusionProof(keys[2]); !errors.Is(err, ErrKeyExists) {
+        t.Fatalf("exclusion proof of a present key = %v, want ErrKeyExists", err)
+    }
+
+    unordered := newTestTree(t, WithKeyedLeaves(), WithStorePreimages())
+    addAll(t, unordered, [][]byte{keys[0], keys[2], keys[1], keys[3]}, values[:4])
+    if _, err := unordered.GenExclusionProof([]byte("k45")); !errors.Is(err, ErrNotKeyOrdered) {
+        t.Fatalf("exclusion proof in an unordered tree = %v, want ErrNotKeyOrdered", err)
+    }
+
+    unkeyed := newTestTree(t, WithStorePreimages())
+    addAll(t, unkeyed, keys, values)
+    if _, err := unkeyed.GenExclusionProof([]byte("k25")); err == nil {
+        t.Fatal("exclusion proof in a tree without keyed leaves")
+    }
+}
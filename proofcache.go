@@ -0,0 +1,84 @@
+package main
+
+import (
+    "container/list"
+    "sync"
+)
+
+// proofCache is an LRU cache of proofs keyed by root and leaf index. A
+// proof only hits for the root it was generated under, and the whole cache
+// is dropped the first time it is consulted under a new root, so entries
+// never outlive the tree state they describe.
+type proofCache struct {
+    mu       sync.Mutex
+    capacity int
+    root     string
+    order    *list.List // front is most recently used; values are *proofCacheEntry
+    entries  map[uint]*list.Element
+}
+
+type proofCacheEntry struct {
+    index uint
+    proof *MerkleProof
+}
+
+func newProofCache(capacity int) *proofCache {
+    return &proofCache{
+        capacity: capacity,
+        order:    list.New(),
+        entries:  make(map[uint]*list.Element),
+    }
+}
+
+// resetIfStale drops every entry if root differs from the root they were
+// cached under. The caller holds c.mu.
+func (c *proofCache) resetIfStale(root []byte) {
+    if c.root == string(root) {
+        return
+    }
+    c.root = string(root)
+    c.order.Init()
+    c.entries = make(map[uint]*list.Element)
+}
+
+func (c *proofCache) get(root []byte, index uint) (*MerkleProof, bool) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    c.resetIfStale(root)
+    elem, ok := c.entries[index]
+    if !ok {
+        return nil, false
+    }
+    c.order.MoveToFront(elem)
+    return cloneProof(elem.Value.(*proofCacheEntry).proof), true
+}
+
+func (c *proofCache) put(root []byte, index uint, proof *MerkleProof) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    c.resetIfStale(root)
+    if elem, ok := c.entries[index]; ok {
+        elem.Value.(*proofCacheEntry).proof = cloneProof(proof)
+        c.order.MoveToFront(elem)
+        return
+    }
+    c.entries[index] = c.order.PushFront(&proofCacheEntry{index: index, proof: cloneProof(proof)})
+    if c.order.Len() > c.capacity {
+        oldest := c.order.Back()
+        c.order.Remove(oldest)
+        delete(c.entries, oldest.Value.(*proofCacheEntry).index)
+    }
+}
+
+// cloneProof copies p so callers of the cache cannot alter cached entries.
+func cloneProof(p *MerkleProof) *MerkleProof {
+    out := *p
+    out.Leaf = append([]byte(nil), p.Leaf...)
+    out.Siblings = make([][]byte, len(p.Siblings))
+    for i, s := range p.Siblings {
+        out.Siblings[i] = append([]byte(nil), s...)
+    }
+    return &out
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+    "bytes"
+    "testing"
+)
+
+func TestProofLRUHitSkipsPathQuery(t *testing.T) {
+    keys, values := testLeaves(8)
+    tree := newTestTree(t, WithProofLRU(4))
+    addAll(t, tree, keys[:7], values[:7])
+
+    pathQueries := func() uint64 {
+        _, paths, _ := CgoCallStats()
+        return paths
+    }
+
+    before := pathQueries()
+    first, err := tree.GenProof(keys[3])
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got := pathQueries() - before; got != 1 {
+        t.Fatalf("%d path queries for a cold proof, want 1", got)
+    }
+
+    before = pathQueries()
+    second, err := tree.GenProof(keys[3])
+    if err != nil {
+        t.Fatal(err)
+    }
+    if got := pathQueries() - before; got != 0 {
+        t.Fatalf("%d path queries for a cached proof, want 0", got)
+    }
+    firstBytes, _ := first.MarshalBinary()
+    secondBytes, _ := second.MarshalBinary()
+    if !bytes.Equal(firstBytes, secondBytes) {
+        t.Fatal("cached proof differs from the one generated")
+    }
+
+    // A new root invalidates the cache.
+    addAll(t, tree, keys[7:], values[7:])
+    before = pathQueries()
+    if _, err := tree.GenProof(keys[3]); err != nil {
+        t.Fatal(err)
+    }
+    if got := pathQueries() - before; got != 1 {
+        t.Fatalf("%d path queries for a proof at a new root, want 1", got)
+    }
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+)
+
+// RangeProof proves that Leaves are the leaves at indexes [Start, End) of a
+// tree holding Size leaves.
+//
+// Only the boundary siblings are included: at every level the known nodes
+// form a contiguous run, and the proof supplies the node just left of the
+// run when the run starts on a right child and the node just right of it
+// when it ends on a left child. Siblings lists them level by level from the
+// leaves up, left before right. SortedChildren is as for MerkleProof.
+type RangeProof struct {
+    Start, End     uint
+    Size           uint
+    Leaves         [][]byte
+    Siblings       [][]byte
+    SortedChildren bool
+}
+
+// GenRangeProof returns a proof for the contiguous leaves [start, end).
+func (tree *MerkleTree) GenRangeProof(start, end uint) (*RangeProof, error) {
+    size := uint(len(tree.values))
+    if start >= end || end > size {
+        return nil, fmt.Errorf("range [%d, %d) of %d leaves: %w", start, end, size, ErrIndexOutOfRange)
+    }
+
+    levels, err := tree.levels()
+    if err != nil {
+        return nil, err
+    }
+
+    proof := &RangeProof{
+        Start:  start,
+        End:    end,
+        Size:   size,
+        Leaves: levels[0][start:end],
+
+        SortedChildren: tree.opts.SortedChildren,
+    }
+
+    lo, hi := start, end
+    for _, nodes := range levels[:len(levels)-1] {
+        width := uint(len(nodes))
+        if lo%2 == 1 {
+            proof.Siblings = append(proof.Siblings, nodes[lo-1])
+            lo--
+        }
+        if hi%2 == 1 && hi < width {
+            proof.Siblings = append(proof.Siblings, nodes[hi])
+            hi++
+        }
+        lo, hi = lo/2, (hi+1)/2
+    }
+
+    return proof, nil
+}
+
+// VerifyRangeProof reports whether p proves its leaves against root.
+func VerifyRangeProof(root []byte, p *RangeProof) (bool, error) {
+    if p.Start >= p.End || p.End > p.Size || uint(len(p.Leaves)) != p.End-p.Start {
+        return false, errors.New("malformed range proof")
+    }
+
+    nodes := append([][]byte(nil), p.Leaves...)
+    siblings := p.Siblings
+    next := func() ([]byte, error) {
+        if len(siblings) == 0 {
+            return nil, errors.New("range proof has too few siblings")
+        }
+        s := siblings[0]
+        siblings = siblings[1:]
+        return s, nil
+    }
+
+    lo, hi, width := p.Start, p.End, p.Size
+    for width > 1 {
+        if lo%2 == 1 {
+            s, err := next()
+            if err != nil {
+                return false, err
+            }
+            nodes = append([][]byte{s}, nodes...)
+            lo--
+        }
+        if hi%2 == 1 && hi < width {
+            s, err := next()
+            if err != nil {
+                return false, err
+            }
+            nodes = append(nodes, s)
+            hi++
+        }
+
+        parents := make([][]byte, 0, (len(nodes)+1)/2)
+        for i := 0; i < len(nodes); i += 2 {
+            if i+1 == len(nodes) {
+                // Odd last node of the level, carried up unhashed.
+                parents = append(parents, nodes[i])
+                continue
+            }
+            h, err := hashChildren(nodes[i], nodes[i+1], p.SortedChildren)
+            if err != nil {
+                return false, err
+            }
+            parents = append(parents, h)
+        }
+
+        nodes = parents
+        lo, hi, width = lo/2, (hi+1)/2, (width+1)/2
+    }
+
+    if len(siblings) != 0 {
+        return false, errors.New("range proof has unused siblings")
+    }
+    return fpEqual(nodes[0], root), nil
+}
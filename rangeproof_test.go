@@ -0,0 +1,55 @@
+package main
+
+import (
+    "bytes"
+    "errors"
+    "testing"
+)
+
+func TestRangeProofTenToTwenty(t *testing.T) {
+    keys, values := testLeaves(100)
+    tree := newTestTree(t)
+    if err := tree.AddBatch(keys, values); err != nil {
+        t.Fatal(err)
+    }
+    root := tree.Root()
+
+    proof, err := tree.GenRangeProof(10, 20)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(proof.Leaves) != 10 {
+        t.Fatalf("proof of [10, 20) holds %d leaves", len(proof.Leaves))
+    }
+    for i, leaf := range proof.Leaves {
+        want, err := tree.LeafHash(keys[10+i])
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !bytes.Equal(leaf, want) {
+            t.Fatalf("leaf %d of the proof is %x, want %x", 10+i, leaf, want)
+        }
+    }
+    ok, err := VerifyRangeProof(root, proof)
+    if err != nil || !ok {
+        t.Fatalf("range proof of [10, 20): %v, %v", ok, err)
+    }
+
+    tampered := *proof
+    tampered.Leaves = append([][]byte(nil), proof.Leaves...)
+    tampered.Leaves[4] = proof.Leaves[5]
+    if ok, _ := VerifyRangeProof(root, &tampered); ok {
+        t.Fatal("range proof verified with a leaf replaced")
+    }
+    shifted := *proof
+    shifted.Start, shifted.End = 11, 21
+    if ok, _ := VerifyRangeProof(root, &shifted); ok {
+        t.Fatal("range proof verified for [11, 21)")
+    }
+
+    for _, r := range [][2]uint{{20, 10}, {10, 10}, {90, 101}} {
+        if _, err := tree.GenRangeProof(r[0], r[1]); !errors.Is(err, ErrIndexOutOfRange) {
+            t.Errorf("range [%d, %d) = %v, want ErrIndexOutOfRange", r[0], r[1], err)
+        }
+    }
+}
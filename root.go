@@ -0,0 +1,52 @@
+package main
+
+import (
+    "encoding/binary"
+    "fmt"
+)
+
+// Root128 folds the root into a 16-byte digest for systems that only have
+// room for 128 bits, such as UUID columns. Limbs 0 and 1 are XORed with
+// limbs 2 and 3, each limb written little-endian:
+//
+//     digest[0:8]  = limb0 ^ limb2
+//     digest[8:16] = limb1 ^ limb3
+//
+// Folding keeps roughly 128 bits of the root's entropy, so two distinct
+// roots collide with probability about 2^-128, and a collision among n
+// roots becomes likely only around n = 2^64. That is fine for identifying
+// roots but not as a substitute for the full root in proofs.
+func (tree *MerkleTree) Root128() ([16]byte, error) {
+    var digest [16]byte
+    root := tree.root()
+    if len(root) != fpSize {
+        return digest, fmt.Errorf("root is %d bytes, want %d", len(root), fpSize)
+    }
+
+    for i := 0; i < 2; i++ {
+        lo := binary.LittleEndian.Uint64(root[i*8:])
+        hi := binary.LittleEndian.Uint64(root[(i+2)*8:])
+        binary.LittleEndian.PutUint64(digest[i*8:], lo^hi)
+    }
+
+    return digest, nil
+}
+
+// SubtreeRoot returns the hash of the node at position index of the given
+// level, counting levels from the root: level 0 is the root itself and the
+// deepest level holds the leaves.
+func (tree *MerkleTree) SubtreeRoot(level int, index uint) ([]byte, error) {
+    levels, err := tree.levels()
+    if err != nil {
+        return nil, err
+    }
+    if level < 0 || level >= len(levels) {
+        return nil, fmt.Errorf("level %d of %d: %w", level, len(levels), ErrIndexOutOfRange)
+    }
+
+    nodes := levels[len(levels)-1-level]
+    if index >= uint(len(nodes)) {
+        return nil, fmt.Errorf("node %d of %d at level %d: %w", index, len(nodes), level, ErrIndexOutOfRange)
+    }
+    return tree.toAPI(nodes[index]), nil
+}
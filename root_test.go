@@ -0,0 +1,46 @@
+package main
+
+import (
+    "bytes"
+    "errors"
+    "testing"
+)
+
+func TestSubtreeRoot(t *testing.T) {
+    keys, values := testLeaves(6)
+    tree := newTestTree(t)
+    addAll(t, tree, keys, values)
+
+    root, err := tree.SubtreeRoot(0, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(root, tree.Root()) {
+        t.Fatalf("level 0 subtree root %x, want the root %x", root, tree.Root())
+    }
+
+    // Six leaves need three levels of internal nodes above them.
+    const leafLevel = 3
+    for i, key := range keys {
+        got, err := tree.SubtreeRoot(leafLevel, uint(i))
+        if err != nil {
+            t.Fatal(err)
+        }
+        want, err := tree.LeafHash(key)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !bytes.Equal(got, want) {
+            t.Fatalf("leaf level node %d = %x, want the leaf hash %x", i, got, want)
+        }
+    }
+
+    for _, q := range []struct {
+        level int
+        index uint
+    }{{-1, 0}, {leafLevel + 1, 0}, {0, 1}, {leafLevel, 6}} {
+        if _, err := tree.SubtreeRoot(q.level, q.index); !errors.Is(err, ErrIndexOutOfRange) {
+            t.Errorf("node %d at level %d = %v, want ErrIndexOutOfRange", q.index, q.level, err)
+        }
+    }
+}
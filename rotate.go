@@ -0,0 +1,88 @@
+package main
+
+import (
+    "encoding/binary"
+    "errors"
+
+    "go.vocdoni.io/dvote/db"
+)
+
+// prefixRotation holds one record per RotateRoot call:
+// rot/<seq, uint64 big-endian> -> root | salt | salted root.
+var prefixRotation = []byte("rot/")
+
+// RootRotation is one RotateRoot call: the tree root at the time, the salt,
+// and the salted root Poseidon(Root, Salt) it produced.
+type RootRotation struct {
+    Root       []byte
+    Salt       [4]uint64
+    SaltedRoot []byte
+}
+
+// RotateRoot derives a fresh commitment key from the tree as
+// Poseidon(root, salt), leaving every leaf untouched, and appends it to the
+// rotation history. The salt is given as four little-endian limbs, like
+// LookupPreimage's leaf. With NoPersistence nothing is recorded.
+func (tree *MerkleTree) RotateRoot(salt [4]uint64) ([]byte, error) {
+    root := tree.root()
+    salted, err := HashTwo(root, limbsToBytes(salt))
+    if err != nil {
+        return nil, err
+    }
+
+    history, err := tree.RootHistory()
+    if err != nil {
+        return nil, err
+    }
+    seq := make([]byte, 8)
+    binary.BigEndian.PutUint64(seq, uint64(len(history)))
+
+    record := make([]byte, 0, 3*fpSize)
+    record = append(record, root...)
+    record = append(record, limbsToBytes(salt)...)
+    record = append(record, salted...)
+    err = tree.writeTx(func(txn db.WriteTx) error {
+        return txn.Set(dbKey(prefixRotation, seq), record)
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    return tree.toAPI(salted), nil
+}
+
+// RootHistory returns every RotateRoot call recorded in the database,
+// oldest first. The last entry holds the current salted root and the ones
+// before it the roots it replaced.
+func (tree *MerkleTree) RootHistory() ([]RootRotation, error) {
+    if tree.opts.NoPersistence {
+        return nil, nil
+    }
+
+    rtx := tree.db.ReadTx()
+    defer rtx.Discard()
+
+    var history []RootRotation
+    var malformed bool
+    err := rtx.Iterate(prefixRotation, func(_, value []byte) bool {
+        if len(value) != 3*fpSize {
+            malformed = true
+            return false
+        }
+        var r RootRotation
+        r.Root = tree.toAPI(append([]byte(nil), value[:fpSize]...))
+        for i := range r.Salt {
+            r.Salt[i] = binary.LittleEndian.Uint64(value[fpSize+8*i:])
+        }
+        r.SaltedRoot = tree.toAPI(append([]byte(nil), value[2*fpSize:]...))
+        history = append(history, r)
+        return true
+    })
+    if err != nil {
+        return nil, err
+    }
+    if malformed {
+        return nil, errors.New("malformed root rotation record")
+    }
+    return history, nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "fmt"
+
+    "go.vocdoni.io/dvote/db"
+)
+
+// Database schema versions, as reported by DetectSchemaVersion.
+const (
+    // SchemaV0 is the layout of the first releases: each key is stored
+    // bare, mapped to its leaf index, and values are not persisted.
+    SchemaV0 = 0
+
+    // SchemaV1 keeps the leaf index under idx/ but still has no val/
+    // records.
+    SchemaV1 = 1
+
+    // SchemaV2 is the current layout described in main.go.
+    SchemaV2 = 2
+)
+
+// schemaPrefixes lists the prefixes of every record the current layout
+// writes. A record outside all of them is a bare v0 key.
+var schemaPrefixes = [][]byte{
+    prefixIndex, prefixValue, prefixWAL, prefixPreimage, prefixRotation,
+    prefixTxLog, prefixCommitment, prefixProofCache, prefixLeafKey,
+    prefixForest, prefixMeta, prefixNode, []byte("tree/"),
+}
+
+func hasSchemaPrefix(key []byte) bool {
+    for _, prefix := range schemaPrefixes {
+        if bytes.HasPrefix(key, prefix) {
+            return true
+        }
+    }
+    return false
+}
+
+// DetectSchemaVersion reports which layout database was written with: the
+// oldest layout any of its leaf records still uses, so a migration that was
+// cut short is detected as unfinished. An empty database is reported as
+// SchemaV2, since a new tree writes it.
+func DetectSchemaVersion(database db.Database) (int, error) {
+    rtx := database.ReadTx()
+    defer rtx.Discard()
+
+    var indexes, values int
+    hasBare := false
+    err := rtx.Iterate(nil, func(key, value []byte) bool {
+        switch {
+        case bytes.HasPrefix(key, prefixIndex):
+            indexes++
+        case bytes.HasPrefix(key, prefixValue):
+            values++
+        case !hasSchemaPrefix(key) && len(value) == 8:
+            hasBare = true
+        }
+        return !hasBare
+    })
+    if err != nil {
+        return 0, err
+    }
+
+    switch {
+    case hasBare:
+        return SchemaV0, nil
+    case indexes > values:
+        return SchemaV1, nil
+    default:
+        return SchemaV2, nil
+    }
+}
+
+// MigrateDB upgrades database in place to SchemaV2 and returns the version
+// it started from. Earlier layouts did not persist values, so valueOf must
+// return the value each key was added with, from wherever the application
+// keeps it; it is not called for a database that is already current. The
+// root is not persisted, so LoadMerkleTreeStrict accepts the migrated tree
+// and the first mutation records it.
+//
+// Records are written in chunks of batchWriteChunk keys. A migration cut
+// short can be run again; it picks up the keys still in the old layout.
+func MigrateDB(database db.Database, valueOf func(key []byte) ([]byte, error)) (int, error) {
+    version, err := DetectSchemaVersion(database)
+    if err != nil {
+        return 0, err
+    }
+
+    var keys [][]byte
+    var indexes []uint64
+    rtx := database.ReadTx()
+    switch version {
+    case SchemaV0:
+        err = rtx.Iterate(nil, func(key, value []byte) bool {
+            if !hasSchemaPrefix(key) && len(value) == 8 {
+                keys = append(keys, append([]byte(nil), key...))
+                indexes = append(indexes, binary.LittleEndian.Uint64(value))
+            }
+            return true
+        })
+    case SchemaV1:
+        err = rtx.Iterate(prefixIndex, func(key, value []byte) bool {
+            keys = append(keys, append([]byte(nil), key...))
+            indexes = append(indexes, binary.LittleEndian.Uint64(value))
+            return true
+        })
+    }
+    rtx.Discard()
+    if err != nil {
+        return 0, err
+    }
+    if version == SchemaV2 {
+        return version, nil
+    }
+    if valueOf == nil {
+        return 0, errors.New("schema migration needs the value of every key")
+    }
+
+    for lo := 0; lo < len(keys); lo += batchWriteChunk {
+        hi := lo + batchWriteChunk
+        if hi > len(keys) {
+            hi = len(keys)
+        }
+        if err := migrateChunk(database, version, keys[lo:hi], indexes[lo:hi], valueOf); err != nil {
+            return 0, err
+        }
+    }
+
+    // Size the tree from every index record, including those moved by an
+    // earlier run that was cut short.
+    var size uint64
+    rtx = database.ReadTx()
+    err = rtx.Iterate(prefixIndex, func(_, value []byte) bool {
+        if idx := binary.LittleEndian.Uint64(value); idx+1 > size {
+            size = idx + 1
+        }
+        return true
+    })
+    rtx.Discard()
+    if err != nil {
+        return 0, err
+    }
+
+    wtx := database.WriteTx()
+    defer wtx.Discard()
+    if err := wtx.Set(keySize, encodeUint64(size)); err != nil {
+        return 0, err
+    }
+    if err := wtx.Commit(); err != nil {
+        return 0, err
+    }
+    return version, nil
+}
+
+// migrateChunk rewrites the records of keys in the current layout.
+func migrateChunk(database db.Database, version int, keys [][]byte, indexes []uint64, valueOf func(key []byte) ([]byte, error)) error {
+    wtx := database.WriteTx()
+    defer wtx.Discard()
+
+    for i, key := range keys {
+        value, err := valueOf(key)
+        if err != nil {
+            return fmt.Errorf("value of key %x: %w", key, err)
+        }
+        if version == SchemaV0 {
+            if err := wtx.Delete(key); err != nil {
+                return err
+            }
+            if err := wtx.Set(dbKey(prefixIndex, key), encodeUint64(indexes[i])); err != nil {
+                return err
+            }
+        }
+        if err := wtx.Set(dbKey(prefixValue, key), value); err != nil {
+            return err
+        }
+    }
+    return wtx.Commit()
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "testing"
+
+    "go.vocdoni.io/dvote/db"
+)
+
+// writeLegacy writes keys in a layout older than SchemaV2: bare
+// key -> index records for SchemaV0, idx/ records without values for
+// SchemaV1.
+func writeLegacy(t *testing.T, database db.Database, version int, keys [][]byte) {
+    t.Helper()
+    wtx := database.WriteTx()
+    defer wtx.Discard()
+    for i, key := range keys {
+        k := key
+        if version == SchemaV1 {
+            k = dbKey(prefixIndex, key)
+        }
+        if err := wtx.Set(k, encodeUint64(uint64(i))); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if err := wtx.Commit(); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestMigrateDB(t *testing.T) {
+    keys, values := testLeaves(2*batchWriteChunk + 5)
+    valueOf := func(key []byte) ([]byte, error) {
+        for i := range keys {
+            if bytes.Equal(keys[i], key) {
+                return values[i], nil
+            }
+        }
+        return nil, ErrKeyNotFound
+    }
+    want := newTestTree(t, WithNoPersistence())
+    if err := want.AddBatch(keys, values); err != nil {
+        t.Fatal(err)
+    }
+
+    for _, version := range []int{SchemaV0, SchemaV1, SchemaV2} {
+        version := version
+        t.Run(fmt.Sprintf("v%d", version), func(t *testing.T) {
+            database := newTestDB(t)
+            if version == SchemaV2 {
+                tree := NewMerkleTree(database)
+                if err := tree.AddBatch(keys, values); err != nil {
+                    t.Fatal(err)
+                }
+                tree.Close()
+            } else {
+                writeLegacy(t, database, version, keys)
+            }
+
+            detected, err := DetectSchemaVersion(database)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if detected != version {
+                t.Fatalf("DetectSchemaVersion = %d, want %d", detected, version)
+            }
+
+            if version != SchemaV2 {
+                if _, err := MigrateDB(database, nil); err == nil {
+                    t.Fatal("MigrateDB without values succeeded")
+                }
+            }
+            from, err := MigrateDB(database, valueOf)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if from != version {
+                t.Errorf("MigrateDB reported v%d, want v%d", from, version)
+            }
+            if detected, err := DetectSchemaVersion(database); err != nil || detected != SchemaV2 {
+                t.Fatalf("version after migration %d, %v", detected, err)
+            }
+
+            tree, err := LoadMerkleTreeStrict(database)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if !bytes.Equal(tree.Root(), want.Root()) {
+                t.Errorf("migrated root %x, want %x", tree.Root(), want.Root())
+            }
+            for i := range keys {
+                got, err := tree.Get(keys[i])
+                if err != nil || !bytes.Equal(got, values[i]) {
+                    t.Fatalf("Get(%s) = %x, %v; want %x", keys[i], got, err, values[i])
+                }
+            }
+            tree.Close()
+        })
+    }
+}
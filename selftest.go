@@ -0,0 +1,81 @@
+package main
+
+import (
+    "bytes"
+    "encoding/hex"
+    "errors"
+    "fmt"
+)
+
+// selfTestHashTwo is the known answer for HashTwo(1, 2): the hash, in its
+// in-memory form as hex, returned by the libsimple_example release this
+// package was written against (mina-poseidon with the Kimchi parameters
+// over Pasta Fp).
+//
+// It has not been recorded yet: no reference build of the library was
+// available when the check was written. Until it is, SelfTest only checks
+// the properties in runSelfTest, which catch a missing or stubbed library
+// but not one with different Poseidon parameters. Record it with
+//
+//     go test -run TestSelfTestVector -v
+//
+// against a reference build.
+const selfTestHashTwo = ""
+
+// selfTestErr is the result of the self-test run when the package is
+// initialized.
+var selfTestErr error
+
+func init() {
+    selfTestErr = runSelfTest()
+}
+
+// SelfTest reports whether the linked libsimple_example behaves like the
+// Poseidon implementation this package was written against. The check
+// runs once, when the package is initialized; SelfTest returns its result.
+func SelfTest() error {
+    return selfTestErr
+}
+
+func runSelfTest() error {
+    if err := RequireLibraryVersion(minLibraryVersion); err != nil {
+        return fmt.Errorf("selftest: %w", err)
+    }
+
+    one := []byte{1}
+    two := []byte{2}
+
+    h12, err := HashTwo(one, two)
+    if err != nil {
+        return fmt.Errorf("selftest: %w", err)
+    }
+    again, err := HashTwo(one, two)
+    if err != nil {
+        return fmt.Errorf("selftest: %w", err)
+    }
+    h21, err := HashTwo(two, one)
+    if err != nil {
+        return fmt.Errorf("selftest: %w", err)
+    }
+    h1, err := HashOne(one)
+    if err != nil {
+        return fmt.Errorf("selftest: %w", err)
+    }
+
+    switch {
+    case len(h12) != fpSize:
+        return fmt.Errorf("selftest: hashpd returned %d bytes, want %d", len(h12), fpSize)
+    case !bytes.Equal(h12, again):
+        return errors.New("selftest: hashpd is not deterministic; wrong libsimple_example linked?")
+    case bytes.Equal(h12, make([]byte, fpSize)):
+        return errors.New("selftest: hashpd returned zero; wrong libsimple_example linked?")
+    case bytes.Equal(h12, h21):
+        return errors.New("selftest: hashpd ignores argument order; wrong libsimple_example linked?")
+    case bytes.Equal(h1, h12):
+        return errors.New("selftest: hashp and hashpd agree on different inputs; wrong libsimple_example linked?")
+    case selfTestHashTwo != "" && hex.EncodeToString(h12) != selfTestHashTwo:
+        return fmt.Errorf("selftest: HashTwo(1, 2) = %x, want %s; wrong libsimple_example linked?", h12, selfTestHashTwo)
+    }
+
+    return nil
+}
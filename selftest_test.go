@@ -0,0 +1,19 @@
+package main
+
+import "testing"
+
+func TestSelfTest(t *testing.T) {
+    if err := SelfTest(); err != nil {
+        t.Fatal(err)
+    }
+}
+
+// TestSelfTestVector prints HashTwo(1, 2) in the form selfTestHashTwo
+// records it, so the vector can be taken from a reference build.
+func TestSelfTestVector(t *testing.T) {
+    h, err := HashTwo([]byte{1}, []byte{2})
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Logf("HashTwo(1, 2) = %x", h)
+}
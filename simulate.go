@@ -0,0 +1,68 @@
+package main
+
+import "fmt"
+
+// SimulatedAdd returns the root the tree would have after Add(key, value),
+// without changing the tree or the database. lib.rs cannot copy a tree, and
+// rebuilding one costs a hash per leaf, so instead of inserting into a copy
+// the new root is folded from the new leaf and the left siblings on its
+// path. Those are complete subtrees of the current leaves, found on the path
+// of the current last leaf, so the cost is one proof and one hash per level.
+func (tree *MerkleTree) SimulatedAdd(key, value []byte) (simulatedRoot []byte, err error) {
+    _, exists, err := tree.indexOf(key)
+    if err != nil {
+        return nil, err
+    }
+    if exists {
+        return nil, ErrKeyExists
+    }
+    n := uint(len(tree.values))
+    if !tree.opts.AutoGrow && int(n)+1 > tree.MaxCapacity() {
+        return nil, fmt.Errorf("adding 1 leaf to %d: %w", n, ErrTreeFull)
+    }
+
+    leaf, _, err := tree.leaf(key, value)
+    if err != nil {
+        return nil, err
+    }
+    node := append([]byte(nil), fpToBytes(&leaf)...)
+    if n == 0 {
+        return tree.toAPI(node), nil
+    }
+
+    // The path of the last leaf, n-1: its node at each level, and the left
+    // sibling of that node at the levels where it has one.
+    proof, err := tree.proofAt(n - 1)
+    if err != nil {
+        return nil, err
+    }
+    path, err := pathNodes(proof)
+    if err != nil {
+        return nil, err
+    }
+    lastSiblings := make(map[int][]byte, len(proof.Siblings))
+    siblings := proof.Siblings
+    for level, pos, width := 0, n-1, n; width > 1; level++ {
+        if pos%2 == 1 {
+            lastSiblings[level] = siblings[0]
+            siblings = siblings[1:]
+        }
+        pos, width = pos/2, (width+1)/2
+    }
+
+    // The new leaf is the last of every level, so it is only ever a right
+    // child; without a left sibling it is carried up unchanged.
+    for level, pos, width := 0, n, n+1; width > 1; level++ {
+        if pos%2 == 1 {
+            left := path[level]
+            if (n-1)>>uint(level) == pos {
+                left = lastSiblings[level]
+            }
+            if node, err = hashChildren(left, node, tree.opts.SortedChildren); err != nil {
+                return nil, err
+            }
+        }
+        pos, width = pos/2, (width+1)/2
+    }
+    return tree.toAPI(node), nil
+}
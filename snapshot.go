@@ -0,0 +1,84 @@
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "os"
+    "path/filepath"
+)
+
+// WriteSnapshot writes the full tree state to path in the Checkpoint
+// layout, for OpenSnapshotReadOnly. The file is written next to path and
+// renamed into place, so readers never see a partial snapshot.
+func (tree *MerkleTree) WriteSnapshot(path string) error {
+    blob, err := tree.checkpointBlob()
+    if err != nil {
+        return err
+    }
+
+    tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmp.Name())
+    if _, err := tmp.Write(blob); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Sync(); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Close(); err != nil {
+        return err
+    }
+    return os.Rename(tmp.Name(), path)
+}
+
+// OpenSnapshotReadOnly serves the tree saved by WriteSnapshot at path,
+// without a database. The file is mapped read-only and its keys and values
+// are used in place, so many verifiers can open the same snapshot cheaply
+// and share its pages. opts must match those the tree was built with.
+//
+// Proofs and lookups work as on any tree. Every mutation fails with
+// ErrReadOnly, and reads only change state that is safe to share: the
+// statistics and the cached empty root are atomic and the proof LRU is
+// locked. With its C tree its own, the tree may then be read from any
+// number of goroutines at once, alongside other trees in use elsewhere,
+// until it is closed. The mapping lives as long as the
+// process. Where files cannot be mapped the snapshot is read into memory
+// instead; see mapFile.
+func OpenSnapshotReadOnly(path string, opts ...Option) (*MerkleTree, error) {
+    data, err := mapFile(path)
+    if err != nil {
+        return nil, err
+    }
+
+    keys, values, root, err := decodeCheckpoint(data, true)
+    if err != nil {
+        unmapFile(data)
+        return nil, fmt.Errorf("snapshot %s: %w", path, err)
+    }
+
+    tree := NewMerkleTree(nil, append(opts, WithNoPersistence())...)
+    for i, key := range keys {
+        if key != nil {
+            tree.setIndex(key, i)
+        }
+    }
+    tree.values = values
+    tree.currentIdx = len(values)
+    tree.readOnly = true
+
+    if err := tree.rebuild(); err != nil {
+        unmapFile(data)
+        return nil, err
+    }
+    if !bytes.Equal(tree.root(), root) {
+        unmapFile(data)
+        return nil, fmt.Errorf("snapshot %s: root does not match its leaves", path)
+    }
+    tree.publishHead()
+    return tree, nil
+}
+
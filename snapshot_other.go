@@ -0,0 +1,26 @@
+//go:build !unix
+
+package main
+
+import (
+    "errors"
+    "os"
+)
+
+// mapFile reads the whole file at path. Without mmap the snapshot is
+// copied into memory, so readers of the same file no longer share pages,
+// but the tree behaves the same.
+func mapFile(path string) ([]byte, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    if len(data) == 0 {
+        return nil, errors.New("snapshot file is empty")
+    }
+    return data, nil
+}
+
+// unmapFile releases what mapFile returned, which the garbage collector
+// does here.
+func unmapFile(data []byte) {}
@@ -0,0 +1,61 @@
+package main
+
+import (
+    "bytes"
+    "path/filepath"
+    "sync"
+    "testing"
+)
+
+// TestSnapshotConcurrentReaders opens one snapshot twice at once and reads
+// each tree from several goroutines. Run it with -race.
+func TestSnapshotConcurrentReaders(t *testing.T) {
+    keys, values := testLeaves(16)
+    tree := newTestTree(t)
+    addAll(t, tree, keys, values)
+    path := filepath.Join(t.TempDir(), "tree.snap")
+    if err := tree.WriteSnapshot(path); err != nil {
+        t.Fatal(err)
+    }
+    want := tree.Root()
+
+    const readers = 4
+    var wg sync.WaitGroup
+    errs := make(chan error, 2*readers*len(keys))
+    for open := 0; open < 2; open++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            snap, err := OpenSnapshotReadOnly(path, WithProofLRU(4))
+            if err != nil {
+                errs <- err
+                return
+            }
+            var readersWG sync.WaitGroup
+            for r := 0; r < readers; r++ {
+                readersWG.Add(1)
+                go func() {
+                    defer readersWG.Done()
+                    if !bytes.Equal(snap.Root(), want) {
+                        t.Errorf("snapshot root %x, want %x", snap.Root(), want)
+                    }
+                    for i := range keys {
+                        if err := snap.CheckProof(keys[i], values[i]); err != nil {
+                            errs <- err
+                        }
+                        if _, err := snap.Get(keys[i]); err != nil {
+                            errs <- err
+                        }
+                    }
+                }()
+            }
+            readersWG.Wait()
+            snap.Close()
+        }()
+    }
+    wg.Wait()
+    close(errs)
+    for err := range errs {
+        t.Error(err)
+    }
+}
@@ -0,0 +1,32 @@
+//go:build unix
+
+package main
+
+import (
+    "errors"
+    "os"
+    "syscall"
+)
+
+// mapFile maps the whole file at path read-only.
+func mapFile(path string) ([]byte, error) {
+    f, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+
+    info, err := f.Stat()
+    if err != nil {
+        return nil, err
+    }
+    if info.Size() == 0 {
+        return nil, errors.New("snapshot file is empty")
+    }
+    return syscall.Mmap(int(f.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+// unmapFile releases a mapping made by mapFile.
+func unmapFile(data []byte) {
+    syscall.Munmap(data)
+}
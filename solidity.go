@@ -0,0 +1,90 @@
+package main
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+)
+
+// EncodeForSolidity merges proofs of several leaves of one tree into the
+// packed multiproof that OpenZeppelin's MerkleProof.verifyMultiProof takes:
+// proofBytes is the bytes32[] proof, one 32-byte word per sibling, and
+// flags the proofFlags array. leaves must be the proven leaves, in the same
+// order as proofs, and that order must be ascending leaf index, which is
+// the order to pass them to the contract in.
+//
+// Words are the canonical field elements, big-endian, as a contract sees
+// them; pass the leaves and root to the contract in the same form. The contract's hashPair must compute this tree's parent rule:
+// Poseidon over Pasta Fp, with the children ordered as in the tree (or
+// ordered as WithSortedChildren does). OpenZeppelin's keccak-based hashPair
+// does not, so the library's verifier needs that function replaced.
+//
+// verifyMultiProof hashes exactly two nodes per step, so a leaf whose path
+// passes through the odd last node of a level, which this tree carries up
+// unhashed, cannot be encoded and is reported as an error.
+func EncodeForSolidity(proofs []*MerkleProof, leaves [][]byte) (proofBytes []byte, flags []bool, err error) {
+    if len(proofs) == 0 || len(proofs) != len(leaves) {
+        return nil, nil, errors.New("need one leaf per proof, and at least one proof")
+    }
+
+    size := proofs[0].Size
+    siblings := make(map[[2]uint][]byte) // (level, position) -> node
+    known := make([]uint, len(proofs))
+    for i, p := range proofs {
+        if p.Size != size {
+            return nil, nil, errors.New("proofs are for trees of different sizes")
+        }
+        if i > 0 && p.Index <= proofs[i-1].Index {
+            return nil, nil, errors.New("proofs must be in ascending leaf index order")
+        }
+        if !bytes.Equal(p.Leaf, leaves[i]) {
+            return nil, nil, fmt.Errorf("leaf %d does not match its proof", i)
+        }
+        known[i] = p.Index
+
+        rest := p.Siblings
+        for level, idx, width := uint(0), p.Index, size; width > 1; level, idx, width = level+1, idx/2, (width+1)/2 {
+            if idx == width-1 && width%2 == 1 {
+                continue
+            }
+            if len(rest) == 0 {
+                return nil, nil, fmt.Errorf("proof %d has too few siblings", i)
+            }
+            siblings[[2]uint{level, idx ^ 1}] = rest[0]
+            rest = rest[1:]
+        }
+    }
+
+    // Walk the known nodes level by level, left to right, which is the
+    // order verifyMultiProof's queue yields them in.
+    for level, width := uint(0), size; width > 1; level, width = level+1, (width+1)/2 {
+        var next []uint
+        for j := 0; j < len(known); j++ {
+            idx := known[j]
+            if idx == width-1 && width%2 == 1 {
+                return nil, nil, fmt.Errorf("node %d at level %d is carried up unhashed, which verifyMultiProof cannot express", idx, level)
+            }
+            if idx%2 == 0 && j+1 < len(known) && known[j+1] == idx+1 {
+                flags = append(flags, true)
+                j++
+            } else {
+                sibling, ok := siblings[[2]uint{level, idx ^ 1}]
+                if !ok {
+                    return nil, nil, fmt.Errorf("missing sibling of node %d at level %d", idx, level)
+                }
+                proofBytes = append(proofBytes, solidityWord(sibling)...)
+                flags = append(flags, false)
+            }
+            next = append(next, idx/2)
+        }
+        known = next
+    }
+
+    return proofBytes, flags, nil
+}
+
+// solidityWord returns a field element in its in-memory form as the
+// big-endian bytes32 of its canonical value.
+func solidityWord(fp []byte) []byte {
+    return fpToBigInt(fp).FillBytes(make([]byte, 32))
+}
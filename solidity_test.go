@@ -0,0 +1,126 @@
+package main
+
+import (
+    "bytes"
+    "errors"
+    "math/big"
+    "reflect"
+    "testing"
+)
+
+// TestEncodeForSolidity checks the encoding of leaves 1, 2 and 5 of eight
+// against the layout OpenZeppelin's verifyMultiProof expects, then runs it
+// through a port of that function.
+func TestEncodeForSolidity(t *testing.T) {
+    tree := newTestTree(t, WithSortedChildren())
+    keys, values := testLeaves(8)
+    addAll(t, tree, keys, values)
+
+    leaf := func(i int) []byte {
+        l, err := tree.LeafHash(keys[i])
+        if err != nil {
+            t.Fatal(err)
+        }
+        return l
+    }
+    proven := []int{1, 2, 5}
+    var proofs []*MerkleProof
+    var leaves [][]byte
+    for _, i := range proven {
+        proof, err := tree.GenProof(keys[i])
+        if err != nil {
+            t.Fatal(err)
+        }
+        proofs = append(proofs, proof)
+        leaves = append(leaves, leaf(i))
+    }
+
+    proofBytes, flags, err := EncodeForSolidity(proofs, leaves)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // Level 0 hashes 1, 2 and 5 with the proof leaves 0, 3 and 4; level 1
+    // hashes the nodes 0 and 1 together and node 2 with the proof node 3,
+    // the parent of leaves 6 and 7; level 2 hashes its two nodes together.
+    node67, err := hashChildren(leaf(6), leaf(7), true)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var want []byte
+    for _, node := range [][]byte{leaf(0), leaf(3), leaf(4), node67} {
+        want = append(want, solidityWord(node)...)
+    }
+    if !bytes.Equal(proofBytes, want) {
+        t.Fatalf("proof words\n%x\nwant\n%x", proofBytes, want)
+    }
+    if wantFlags := []bool{false, false, false, true, false, true}; !reflect.DeepEqual(flags, wantFlags) {
+        t.Fatalf("flags %v, want %v", flags, wantFlags)
+    }
+
+    var words [][]byte
+    for i := 0; i < len(proofBytes); i += 32 {
+        words = append(words, bigIntToFp(new(big.Int).SetBytes(proofBytes[i:i+32])))
+    }
+    root, err := processMultiProof(words, flags, leaves)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(root, tree.Root()) {
+        t.Fatalf("multiproof gives root %x, want %x", root, tree.Root())
+    }
+}
+
+func TestEncodeForSolidityRejectsCarriedNode(t *testing.T) {
+    tree := newTestTree(t, WithSortedChildren())
+    keys, values := testLeaves(5)
+    addAll(t, tree, keys, values)
+
+    proof, err := tree.GenProof(keys[4])
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, _, err := EncodeForSolidity([]*MerkleProof{proof}, [][]byte{proof.Leaf}); err == nil {
+        t.Fatal("encoded the proof of a leaf carried up unhashed")
+    }
+}
+
+// processMultiProof is OpenZeppelin's MerkleProof.processMultiProof with
+// the tree's sorted parent rule as hashPair.
+func processMultiProof(proof [][]byte, flags []bool, leaves [][]byte) ([]byte, error) {
+    if len(leaves)+len(proof) != len(flags)+1 {
+        return nil, errors.New("MerkleProofInvalidMultiproof")
+    }
+    hashes := make([][]byte, len(flags))
+    leafPos, hashPos, proofPos := 0, 0, 0
+    next := func() []byte {
+        if leafPos < len(leaves) {
+            leafPos++
+            return leaves[leafPos-1]
+        }
+        hashPos++
+        return hashes[hashPos-1]
+    }
+    for i, flag := range flags {
+        a := next()
+        var b []byte
+        if flag {
+            b = next()
+        } else {
+            b = proof[proofPos]
+            proofPos++
+        }
+        var err error
+        if hashes[i], err = hashChildren(a, b, true); err != nil {
+            return nil, err
+        }
+    }
+    switch {
+    case len(flags) > 0:
+        return hashes[len(flags)-1], nil
+    case len(leaves) > 0:
+        return leaves[0], nil
+    default:
+        return proof[0], nil
+    }
+}
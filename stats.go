@@ -0,0 +1,76 @@
+package main
+
+import "sync/atomic"
+
+// Stats is a snapshot of the operation counters of a MerkleTree. Errors
+// counts failed calls of any kind; failed calls are not counted under their
+// own operation. Verifications counts the proofs the tree checks itself,
+// with CheckProof and VerifyAtVersion; VerifyProof and the other
+// package-level verifiers have no tree to count against.
+type Stats struct {
+    Adds, AddBatches, Gets, Proofs, Verifications, Errors uint64
+}
+
+type treeStats struct {
+    adds, addBatches, gets, proofs, verifications, errors atomic.Uint64
+}
+
+// record increments counter if *err is nil and the error counter otherwise.
+// It is meant to be deferred with a pointer to a named error result.
+func (s *treeStats) record(counter *atomic.Uint64, err *error) {
+    if *err != nil {
+        s.errors.Add(1)
+        return
+    }
+    counter.Add(1)
+}
+
+// Stats returns the current operation counters.
+func (tree *MerkleTree) Stats() Stats {
+    return Stats{
+        Adds:          tree.stats.adds.Load(),
+        AddBatches:    tree.stats.addBatches.Load(),
+        Gets:          tree.stats.gets.Load(),
+        Proofs:        tree.stats.proofs.Load(),
+        Verifications: tree.stats.verifications.Load(),
+        Errors:        tree.stats.errors.Load(),
+    }
+}
+
+// ResetStats sets every counter back to zero.
+func (tree *MerkleTree) ResetStats() {
+    tree.stats.adds.Store(0)
+    tree.stats.addBatches.Store(0)
+    tree.stats.gets.Store(0)
+    tree.stats.proofs.Store(0)
+    tree.stats.verifications.Store(0)
+    tree.stats.errors.Store(0)
+}
+
+// cgoCalls counts calls into the C library by kind, across every tree in
+// the process.
+var cgoCalls struct {
+    // hashes counts hashp and hashpd calls.
+    hashes atomic.Uint64
+    // pathQueries counts tree_get_path calls.
+    pathQueries atomic.Uint64
+    // inserts counts calls that write leaves into a C tree:
+    // tree_add_leaf, tree_update_leaf and tree_build.
+    inserts atomic.Uint64
+    // rootQueries counts tree_root calls.
+    rootQueries atomic.Uint64
+}
+
+// CgoCallStats returns the number of calls made into the C library so far.
+// The counters are process-wide, as the package-level hash functions call
+// into the library for no particular tree: they include the calls made for
+// every MerkleTree, and ResetStats does not clear them.
+func CgoCallStats() (hashes, pathQueries, inserts uint64) {
+    return cgoCalls.hashes.Load(), cgoCalls.pathQueries.Load(), cgoCalls.inserts.Load()
+}
+
+// CgoRootQueries returns the number of tree_root calls made so far,
+// counted like CgoCallStats.
+func CgoRootQueries() uint64 {
+    return cgoCalls.rootQueries.Load()
+}
@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+// TestCgoCallStats counts the calls of 10 adds and 3 proofs. The counters
+// are process-wide, so the test compares them before and after, and must
+// not run in parallel with other tests.
+func TestCgoCallStats(t *testing.T) {
+    keys, values := testLeaves(10)
+    tree := newTestTree(t)
+
+    hashes0, paths0, inserts0 := CgoCallStats()
+    addAll(t, tree, keys, values)
+    for _, key := range keys[:3] {
+        if _, err := tree.GenProof(key); err != nil {
+            t.Fatal(err)
+        }
+    }
+    hashes, paths, inserts := CgoCallStats()
+
+    // Values of up to a field element are reduced in Go, not hashed. The
+    // first add builds the empty C tree with tree_build, and every add
+    // then appends its leaf with tree_add_leaf.
+    if got := hashes - hashes0; got != 0 {
+        t.Errorf("%d hash calls, want 0", got)
+    }
+    if got := paths - paths0; got != 3 {
+        t.Errorf("%d path queries, want 3", got)
+    }
+    if got := inserts - inserts0; got != 1+10 {
+        t.Errorf("%d inserts, want 11", got)
+    }
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+    "bytes"
+    "fmt"
+)
+
+// TreeStatus is the health of a tree as last observed; see Status.
+type TreeStatus int32
+
+const (
+    // StatusHealthy is a tree with no known problem.
+    StatusHealthy TreeStatus = iota
+    // StatusRebuilding is a tree that Repair is reloading from the
+    // database.
+    StatusRebuilding
+    // StatusCorrupted is a tree whose last CheckConsistency or Repair
+    // failed. It stays so until one of them succeeds.
+    StatusCorrupted
+)
+
+func (s TreeStatus) String() string {
+    switch s {
+    case StatusHealthy:
+        return "healthy"
+    case StatusRebuilding:
+        return "rebuilding"
+    case StatusCorrupted:
+        return "corrupted"
+    default:
+        return fmt.Sprintf("TreeStatus(%d)", int32(s))
+    }
+}
+
+// Status returns the current status of the tree. Unlike the rest of the
+// tree it is safe to call from other goroutines, so a monitor can poll it
+// while the tree is in use.
+func (tree *MerkleTree) Status() TreeStatus {
+    return TreeStatus(tree.status.Load())
+}
+
+func (tree *MerkleTree) setStatus(s TreeStatus) {
+    tree.status.Store(int32(s))
+}
+
+// CheckConsistency recomputes the root from the leaves and compares it with
+// the root of the C tree and, unless NoPersistence is set, with the root
+// persisted by the last mutation. On a mismatch it marks the tree
+// StatusCorrupted and returns ErrRootMismatch; Repair rebuilds it from the
+// database. A successful check marks it StatusHealthy.
+func (tree *MerkleTree) CheckConsistency() error {
+    err := tree.checkConsistency()
+    if err != nil {
+        tree.setStatus(StatusCorrupted)
+        return err
+    }
+    tree.setStatus(StatusHealthy)
+    return nil
+}
+
+func (tree *MerkleTree) checkConsistency() error {
+    levels, err := tree.levels()
+    if err != nil {
+        return err
+    }
+    root := tree.root()
+    if len(levels) > 0 && !bytes.Equal(levels[len(levels)-1][0], root) {
+        return fmt.Errorf("C tree root differs from the root of its leaves: %w", ErrRootMismatch)
+    }
+
+    if tree.opts.NoPersistence {
+        return nil
+    }
+    persisted, ok, err := tree.persistedRoot()
+    if err != nil {
+        return err
+    }
+    if ok && !bytes.Equal(persisted, root) {
+        return fmt.Errorf("persisted root differs from the root of the leaves: %w", ErrRootMismatch)
+    }
+    return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+    "errors"
+    "time"
+
+    "go.vocdoni.io/dvote/db"
+)
+
+// maxCommitRetries bounds how many times a write transaction is retried
+// after a commit conflict before the conflict is returned to the caller.
+const maxCommitRetries = 5
+
+// commitRetryBackoff is the delay before the first retry; it doubles on
+// every further attempt.
+const commitRetryBackoff = 2 * time.Millisecond
+
+// isConflict reports whether err is a commit conflict, which the db
+// wrapper reports as db.ErrConflict.
+func isConflict(err error) bool {
+    return errors.Is(err, db.ErrConflict)
+}
+
+// writeTx runs fn in a fresh write transaction and commits it. On a commit
+// conflict the transaction is discarded and fn is run again in a new one, so
+// fn must derive everything it writes from the transaction and the tree
+// rather than from state captured on a previous attempt.
+//
+// With WithNoPersistence fn is never run and writeTx always succeeds. A
+// read-only tree fails with ErrReadOnly.
+func (tree *MerkleTree) writeTx(fn func(txn db.WriteTx) error) error {
+    if tree.readOnly {
+        return ErrReadOnly
+    }
+    if tree.opts.NoPersistence {
+        return nil
+    }
+
+    backoff := commitRetryBackoff
+    for attempt := 0; ; attempt++ {
+        txn := tree.db.WriteTx()
+        err := fn(txn)
+        if err == nil {
+            err = txn.Commit()
+        }
+        txn.Discard()
+
+        if !isConflict(err) || attempt == maxCommitRetries {
+            return err
+        }
+        time.Sleep(backoff)
+        backoff *= 2
+    }
+}
+
+// checkWritable returns the error a mutation of the leaves must fail with,
+// if any. Mutations call it before touching the C tree, so a refused write
+// costs nothing. writeTx also refuses every write to a read-only tree.
+func (tree *MerkleTree) checkWritable() error {
+    if tree.readOnly {
+        return ErrReadOnly
+    }
+    if tree.finalized {
+        return ErrFinalized
+    }
+    return nil
+}
+
+// keyFinalized is set by Finalize, so a finalized tree stays finalized when
+// loaded again.
+var keyFinalized = []byte("tree/finalized")
+
+// Finalize freezes the leaves of the tree, as protocols do once a root is
+// published: Add, Update, Delete and every other change to the leaves then
+// fail with ErrFinalized, while lookups and proofs keep working. The flag
+// is persisted, so LoadMerkleTree of the same database returns a finalized
+// tree too.
+func (tree *MerkleTree) Finalize() error {
+    err := tree.writeTx(func(txn db.WriteTx) error {
+        return txn.Set(keyFinalized, []byte{1})
+    })
+    if err != nil {
+        return err
+    }
+    tree.finalized = true
+    return nil
+}
+
+// loadFinalized restores the flag set by Finalize.
+func (tree *MerkleTree) loadFinalized(rtx db.ReadTx) error {
+    _, err := rtx.Get(keyFinalized)
+    if errors.Is(err, db.ErrKeyNotFound) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    tree.finalized = true
+    return nil
+}
+
+// Finalized reports whether Finalize has been called.
+func (tree *MerkleTree) Finalized() bool {
+    return tree.finalized
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+    "testing"
+
+    "go.vocdoni.io/dvote/db"
+)
+
+// conflictDB fails the next conflicts commits with db.ErrConflict, as if
+// another writer had committed over the same keys first.
+type conflictDB struct {
+    db.Database
+    conflicts int
+}
+
+func (d *conflictDB) WriteTx() db.WriteTx {
+    return &conflictTx{WriteTx: d.Database.WriteTx(), db: d}
+}
+
+type conflictTx struct {
+    db.WriteTx
+    db *conflictDB
+}
+
+func (tx *conflictTx) Commit() error {
+    if tx.db.conflicts > 0 {
+        tx.db.conflicts--
+        return fmt.Errorf("commit: %w", db.ErrConflict)
+    }
+    return tx.WriteTx.Commit()
+}
+
+func TestWriteTxRetriesConflicts(t *testing.T) {
+    database := &conflictDB{Database: newTestDB(t)}
+    tree := NewMerkleTree(database)
+    defer tree.Close()
+
+    database.conflicts = maxCommitRetries
+    if err := tree.Add([]byte("key"), []byte{1}); err != nil {
+        t.Fatalf("add after %d conflicts: %v", maxCommitRetries, err)
+    }
+    if database.conflicts != 0 {
+        t.Fatalf("%d conflicts left; the add did not retry", database.conflicts)
+    }
+
+    loaded, err := LoadMerkleTreeStrict(database)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer loaded.Close()
+    if _, err := loaded.Get([]byte("key")); err != nil {
+        t.Fatalf("key added after conflicts: %v", err)
+    }
+}
+
+func TestWriteTxGivesUpAfterMaxRetries(t *testing.T) {
+    database := &conflictDB{Database: newTestDB(t)}
+    tree := NewMerkleTree(database)
+    defer tree.Close()
+
+    database.conflicts = maxCommitRetries + 1
+    err := tree.writeTx(func(txn db.WriteTx) error {
+        return txn.Set([]byte("k"), []byte("v"))
+    })
+    if !errors.Is(err, db.ErrConflict) {
+        t.Fatalf("writeTx after %d conflicts = %v, want db.ErrConflict", maxCommitRetries+1, err)
+    }
+}
+
+func TestFinalizeFreezesLeaves(t *testing.T) {
+    database := newTestDB(t)
+    keys, values := testLeaves(5)
+    tree := NewMerkleTree(database)
+    defer tree.Close()
+    addAll(t, tree, keys[:4], values[:4])
+    if err := tree.Finalize(); err != nil {
+        t.Fatal(err)
+    }
+    root := tree.Root()
+
+    for name, mutate := range map[string]func(*MerkleTree) error{
+        "Add":      func(tree *MerkleTree) error { return tree.Add(keys[4], values[4]) },
+        "AddBatch": func(tree *MerkleTree) error { return tree.AddBatch(keys[4:], values[4:]) },
+        "Update":   func(tree *MerkleTree) error { return tree.Update(keys[0], values[4]) },
+        "Delete":   func(tree *MerkleTree) error { return tree.Delete(keys[0]) },
+    } {
+        if err := mutate(tree); !errors.Is(err, ErrFinalized) {
+            t.Errorf("%s after Finalize = %v, want ErrFinalized", name, err)
+        }
+    }
+
+    // The flag survives a reload, and proofs keep working on both trees.
+    loaded, err := LoadMerkleTree(database)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer loaded.Close()
+    if err := loaded.Add(keys[4], values[4]); !errors.Is(err, ErrFinalized) {
+        t.Errorf("Add after reloading a finalized tree = %v, want ErrFinalized", err)
+    }
+    for _, tree := range []*MerkleTree{tree, loaded} {
+        if !bytes.Equal(tree.Root(), root) {
+            t.Fatalf("root changed after Finalize: %x, want %x", tree.Root(), root)
+        }
+        for i, key := range keys[:4] {
+            if err := tree.CheckProof(key, values[i]); err != nil {
+                t.Fatal(err)
+            }
+        }
+    }
+}
@@ -0,0 +1,113 @@
+package main
+
+// RootStream computes the root of a tree one leaf at a time, without
+// holding the leaves.
+//
+// Pairing leaves level by level and carrying an odd last node up unhashed,
+// as lib.rs does, makes the root of n leaves a right fold over the perfect
+// subtrees n decomposes into, largest first:
+//
+//     root = H(P_a, H(P_b, ... H(P_y, P_z)))    for n = 2^a + 2^b + ... + 2^z
+//
+// RootStream keeps one perfect subtree root per set bit of the leaf count,
+// so Push and Root each cost O(log n) hashes.
+//
+// Set SortedChildren to compute the root of a tree built
+// WithSortedChildren.
+type RootStream struct {
+    SortedChildren bool
+
+    count    uint64
+    subtrees [][]byte // subtrees[h] is the root of a perfect subtree of 2^h leaves, or nil
+}
+
+// Push appends a leaf, given as a field element in its in-memory form.
+func (s *RootStream) Push(leaf []byte) error {
+    node := leaf
+    h := 0
+    for ; h < len(s.subtrees) && s.subtrees[h] != nil; h++ {
+        merged, err := hashChildren(s.subtrees[h], node, s.SortedChildren)
+        if err != nil {
+            return err
+        }
+        s.subtrees[h] = nil
+        node = merged
+    }
+    if h == len(s.subtrees) {
+        s.subtrees = append(s.subtrees, nil)
+    }
+    s.subtrees[h] = node
+    s.count++
+    return nil
+}
+
+// Count returns the number of leaves pushed so far.
+func (s *RootStream) Count() uint64 {
+    return s.count
+}
+
+// Root returns the root of the leaves pushed so far; zero if there are none.
+func (s *RootStream) Root() ([]byte, error) {
+    var acc []byte
+    for _, subtree := range s.subtrees {
+        if subtree == nil {
+            continue
+        }
+        if acc == nil {
+            acc = subtree
+            continue
+        }
+        var err error
+        if acc, err = hashChildren(subtree, acc, s.SortedChildren); err != nil {
+            return nil, err
+        }
+    }
+    if acc == nil {
+        return make([]byte, fpSize), nil
+    }
+    return acc, nil
+}
+
+// reportProgress calls the WithRootProgress callback if the tree size has
+// just reached a multiple of the reporting interval.
+func (tree *MerkleTree) reportProgress() {
+    every := tree.opts.ProgressEvery
+    if tree.opts.Progress == nil || every <= 0 || tree.currentIdx%every != 0 {
+        return
+    }
+    tree.opts.Progress(tree.currentIdx, tree.Root())
+}
+
+// reportBatchProgress calls the WithRootProgress callback for every
+// multiple of the reporting interval crossed by a batch that grew the tree
+// from start leaves to its current size. The C tree only holds the final
+// state, so intermediate roots are streamed from the leaves.
+func (tree *MerkleTree) reportBatchProgress(start int) error {
+    every := tree.opts.ProgressEvery
+    if tree.opts.Progress == nil || every <= 0 || start/every == tree.currentIdx/every {
+        return nil
+    }
+
+    stream := RootStream{SortedChildren: tree.opts.SortedChildren}
+    for i, stored := range tree.values {
+        leaf, err := tree.storedLeafBytes(stored)
+        if err != nil {
+            return err
+        }
+        if err := stream.Push(leaf); err != nil {
+            return err
+        }
+
+        count := i + 1
+        if count <= start || count%every != 0 {
+            continue
+        }
+        root, err := stream.Root()
+        if err != nil {
+            return err
+        }
+        tree.opts.Progress(count, tree.toAPI(root))
+    }
+
+    return nil
+}
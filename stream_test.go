@@ -0,0 +1,36 @@
+package main
+
+import (
+    "bytes"
+    "reflect"
+    "testing"
+)
+
+func TestRootProgressCallbacks(t *testing.T) {
+    keys, values := testLeaves(40)
+    var counts []int
+    var roots [][]byte
+    tree := newTestTree(t, WithRootProgress(10, func(count int, root []byte) {
+        counts = append(counts, count)
+        roots = append(roots, root)
+    }))
+
+    if err := tree.AddBatch(keys[:35], values[:35]); err != nil {
+        t.Fatal(err)
+    }
+    addAll(t, tree, keys[35:], values[35:])
+
+    if want := []int{10, 20, 30, 40}; !reflect.DeepEqual(counts, want) {
+        t.Fatalf("progress reported at %v leaves, want %v", counts, want)
+    }
+    for i, count := range counts {
+        prefix := newTestTree(t, WithNoPersistence())
+        addAll(t, prefix, keys[:count], values[:count])
+        if !bytes.Equal(roots[i], prefix.Root()) {
+            t.Fatalf("root reported at %d leaves %x, want %x", count, roots[i], prefix.Root())
+        }
+        if i > 0 && bytes.Equal(roots[i], roots[i-1]) {
+            t.Fatalf("root unchanged from %d to %d leaves", counts[i-1], count)
+        }
+    }
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+    "bytes"
+    "fmt"
+)
+
+// SubTree is a view of the leaves of a tree whose keys share a prefix, as
+// owned by one shard of a sharded key space. Add, Get and GenProof accept
+// only keys starting with the prefix; proofs are ordinary proofs against
+// the whole tree.
+//
+// Leaves are placed in insertion order, not key order, so the leaves of a
+// prefix form a contiguous run only if they were added one after the other.
+// The root of a SubTree is the lowest node whose subtree covers every
+// matching leaf; it covers no other leaves when the run is aligned to a
+// power of two, which is the case for shards filled in fixed-size blocks.
+type SubTree struct {
+    tree   *MerkleTree
+    prefix []byte
+}
+
+// SubTree returns the view of the keys of tree that start with prefix.
+func (tree *MerkleTree) SubTree(prefix []byte) (*SubTree, error) {
+    if len(prefix) == 0 {
+        return nil, fmt.Errorf("subtree prefix is empty")
+    }
+    return &SubTree{tree: tree, prefix: append([]byte(nil), prefix...)}, nil
+}
+
+// Prefix returns the key prefix of the view.
+func (s *SubTree) Prefix() []byte {
+    return s.prefix
+}
+
+func (s *SubTree) checkKey(key []byte) error {
+    if !bytes.HasPrefix(key, s.prefix) {
+        return fmt.Errorf("key %x, prefix %x: %w", key, s.prefix, ErrKeyOutsidePrefix)
+    }
+    return nil
+}
+
+// Add adds key to the underlying tree.
+func (s *SubTree) Add(key, value []byte) error {
+    if err := s.checkKey(key); err != nil {
+        return err
+    }
+    return s.tree.Add(key, value)
+}
+
+// Get returns the value stored for key.
+func (s *SubTree) Get(key []byte) ([]byte, error) {
+    if err := s.checkKey(key); err != nil {
+        return nil, err
+    }
+    return s.tree.Get(key)
+}
+
+// GenProof returns the proof of key against the root of the whole tree.
+func (s *SubTree) GenProof(key []byte) (*MerkleProof, error) {
+    if err := s.checkKey(key); err != nil {
+        return nil, err
+    }
+    return s.tree.GenProof(key)
+}
+
+// span returns the smallest index range [lo, hi) holding every leaf whose
+// key starts with the prefix.
+func (s *SubTree) span() (lo, hi uint, err error) {
+    found := false
+    err = s.tree.eachIndex(func(key []byte, idx int) bool {
+        if !bytes.HasPrefix(key, s.prefix) {
+            return true
+        }
+        i := uint(idx)
+        if !found || i < lo {
+            lo = i
+        }
+        if !found || i+1 > hi {
+            hi = i + 1
+        }
+        found = true
+        return true
+    })
+    if err != nil {
+        return 0, 0, err
+    }
+    if !found {
+        return 0, 0, fmt.Errorf("no keys with prefix %x: %w", s.prefix, ErrEmptyTree)
+    }
+    return lo, hi, nil
+}
+
+// Root returns the root of the view: the lowest node of the tree above
+// every leaf whose key starts with the prefix.
+func (s *SubTree) Root() ([]byte, error) {
+    proof, err := s.RootProof()
+    if err != nil {
+        return nil, err
+    }
+    return s.tree.toAPI(proof.Leaf), nil
+}
+
+// RootProof returns a proof that the root of the view is a node of the
+// whole tree. Leaf is the subtree root in its in-memory form, and Index and
+// Size are its position and the width of its level, so the proof checks
+// with VerifyProof against the root of the whole tree like any leaf proof:
+// above that level the tree is shaped exactly like a tree of Size leaves.
+func (s *SubTree) RootProof() (*MerkleProof, error) {
+    lo, hi, err := s.span()
+    if err != nil {
+        return nil, err
+    }
+    levels, err := s.tree.levels()
+    if err != nil {
+        return nil, err
+    }
+
+    level := 0
+    for lo>>uint(level) != (hi-1)>>uint(level) {
+        level++
+    }
+
+    idx := lo >> uint(level)
+    proof := &MerkleProof{
+        Index:          idx,
+        Size:           uint(len(levels[level])),
+        Leaf:           levels[level][idx],
+        SortedChildren: s.tree.opts.SortedChildren,
+    }
+    for _, nodes := range levels[level : len(levels)-1] {
+        width := uint(len(nodes))
+        if idx%2 == 1 {
+            proof.Siblings = append(proof.Siblings, nodes[idx-1])
+        } else if idx+1 < width {
+            proof.Siblings = append(proof.Siblings, nodes[idx+1])
+        }
+        idx /= 2
+    }
+
+    return proof, nil
+}
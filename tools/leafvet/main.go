@@ -0,0 +1,102 @@
+// Command leafvet reports []byte literals passed directly as a LeafKey or
+// LeafValue argument.
+//
+// LeafKey and LeafValue share []byte as their underlying type, so the
+// compiler happily accepts tree.Add([]byte("v"), []byte("k")) with the
+// arguments swapped. Requiring an explicit LeafKey(...) / LeafValue(...)
+// conversion at the call site makes such swaps visible in review.
+//
+// Usage:
+//
+//     go run ./tools/leafvet ./...
+//     go vet -vettool=$(which leafvet) ./...
+package main
+
+import (
+    "go/ast"
+    "go/types"
+
+    "golang.org/x/tools/go/analysis"
+    "golang.org/x/tools/go/analysis/passes/inspect"
+    "golang.org/x/tools/go/analysis/singlechecker"
+    "golang.org/x/tools/go/ast/inspector"
+)
+
+var Analyzer = &analysis.Analyzer{
+    Name:     "leafvet",
+    Doc:      "report []byte literals passed directly as LeafKey or LeafValue arguments",
+    Requires: []*analysis.Analyzer{inspect.Analyzer},
+    Run:      run,
+}
+
+func main() {
+    singlechecker.Main(Analyzer)
+}
+
+func run(pass *analysis.Pass) (interface{}, error) {
+    insp := pass.ResultOf[inspect.Analyzer].(*inspector.Inspector)
+
+    insp.Preorder([]ast.Node{(*ast.CallExpr)(nil)}, func(n ast.Node) {
+        call := n.(*ast.CallExpr)
+        sig, ok := pass.TypesInfo.TypeOf(call.Fun).(*types.Signature)
+        if !ok {
+            return
+        }
+
+        params := sig.Params()
+        for i, arg := range call.Args {
+            if i >= params.Len() {
+                break
+            }
+            name := leafTypeName(params.At(i).Type())
+            if name == "" || !isByteSliceLiteral(pass, arg) {
+                continue
+            }
+            pass.Reportf(arg.Pos(), "[]byte literal passed as %s; convert it explicitly with %s(...)", name, name)
+        }
+    })
+
+    return nil, nil
+}
+
+// leafTypeName returns "LeafKey" or "LeafValue" if t is one of those named
+// types, and "" otherwise.
+func leafTypeName(t types.Type) string {
+    named, ok := t.(*types.Named)
+    if !ok {
+        return ""
+    }
+    switch name := named.Obj().Name(); name {
+    case "LeafKey", "LeafValue":
+        return name
+    }
+    return ""
+}
+
+// isByteSliceLiteral reports whether expr is a []byte("...") conversion or a
+// []byte{...} composite literal.
+func isByteSliceLiteral(pass *analysis.Pass, expr ast.Expr) bool {
+    var typExpr ast.Expr
+    switch e := expr.(type) {
+    case *ast.CallExpr:
+        if len(e.Args) != 1 {
+            return false
+        }
+        typExpr = e.Fun
+    case *ast.CompositeLit:
+        typExpr = e.Type
+    default:
+        return false
+    }
+
+    tv, ok := pass.TypesInfo.Types[typExpr]
+    if !ok || !tv.IsType() {
+        return false
+    }
+    slice, ok := tv.Type.(*types.Slice)
+    if !ok {
+        return false
+    }
+    basic, ok := slice.Elem().(*types.Basic)
+    return ok && basic.Kind() == types.Byte
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+    "bytes"
+    "errors"
+
+    "go.vocdoni.io/dvote/db"
+)
+
+type txOpKind int
+
+const (
+    txAdd txOpKind = iota
+    txUpdate
+    txDelete
+)
+
+type txOp struct {
+    kind       txOpKind
+    key, value []byte
+}
+
+// TreeTx stages insertions, updates and deletions against a MerkleTree
+// without touching the live tree or the database until Commit.
+//
+// The live tree must not be modified while a TreeTx is open; Commit fails if
+// it was.
+type TreeTx struct {
+    tree     *MerkleTree
+    baseRoot []byte
+    baseSize int
+    keyIndex map[string]int
+    values   [][]byte
+    ops      []txOp
+    done     bool
+}
+
+// Begin opens a staging transaction on the tree. It copies the key index,
+// so opening one costs O(leaves).
+func (tree *MerkleTree) Begin() (*TreeTx, error) {
+    keyIndex := make(map[string]int)
+    err := tree.eachIndex(func(key []byte, idx int) bool {
+        keyIndex[string(key)] = idx
+        return true
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    return &TreeTx{
+        tree:     tree,
+        baseRoot: append([]byte(nil), tree.root()...),
+        baseSize: len(tree.values),
+        keyIndex: keyIndex,
+        values:   append([][]byte(nil), tree.values...),
+    }, nil
+}
+
+func (tx *TreeTx) stage(kind txOpKind, key, value []byte) error {
+    if tx.done {
+        return errors.New("transaction already finished")
+    }
+
+    keyStr := string(key)
+    idx, exists := tx.keyIndex[keyStr]
+    switch {
+    case kind == txAdd && exists:
+        return ErrKeyExists
+    case kind != txAdd && !exists:
+        return ErrKeyNotFound
+    }
+
+    var stored []byte
+    if kind != txDelete {
+        var err error
+        if _, stored, err = tx.tree.leaf(key, value); err != nil {
+            return err
+        }
+    }
+
+    switch kind {
+    case txAdd:
+        tx.keyIndex[keyStr] = len(tx.values)
+        tx.values = append(tx.values, stored)
+    case txUpdate:
+        tx.values[idx] = stored
+    case txDelete:
+        delete(tx.keyIndex, keyStr)
+        tx.values[idx] = nil
+    }
+
+    tx.ops = append(tx.ops, txOp{kind: kind, key: key, value: value})
+    return nil
+}
+
+// Add stages the insertion of a new key.
+func (tx *TreeTx) Add(key, value []byte) error {
+    return tx.stage(txAdd, key, value)
+}
+
+// Update stages a new value for an existing key.
+func (tx *TreeTx) Update(key, value []byte) error {
+    return tx.stage(txUpdate, key, value)
+}
+
+// Delete stages the removal of an existing key.
+func (tx *TreeTx) Delete(key []byte) error {
+    return tx.stage(txDelete, key, nil)
+}
+
+// TentativeRoot returns the root the tree would have if the transaction
+// were committed now.
+func (tx *TreeTx) TentativeRoot() ([]byte, error) {
+    levels, err := tx.tree.levelsOf(tx.values)
+    if err != nil {
+        return nil, err
+    }
+    if len(levels) == 0 {
+        return make([]byte, fpSize), nil
+    }
+    return tx.tree.toAPI(levels[len(levels)-1][0]), nil
+}
+
+// Commit applies the staged operations to the live tree as one mutation.
+// Every staged leaf is checked before anything changes; the records of all
+// the keys touched are then written in a single database transaction,
+// logged as one TxOpCommit entry, and the C tree is rebuilt from the staged
+// leaves. If the database write fails the live tree is left as it was.
+func (tx *TreeTx) Commit() error {
+    if tx.done {
+        return errors.New("transaction already finished")
+    }
+    tree := tx.tree
+    if err := tree.checkMutable(); err != nil {
+        return err
+    }
+    if !bytes.Equal(tree.root(), tx.baseRoot) || len(tree.values) != tx.baseSize {
+        return errors.New("tree was modified while the transaction was open")
+    }
+    if err := tree.checkCapacity(len(tx.values) - tx.baseSize); err != nil {
+        return err
+    }
+    levels, err := tree.levelsOf(tx.values)
+    if err != nil {
+        return err
+    }
+    tx.done = true
+
+    // The keys touched, in the order first staged, with their index in
+    // the live tree and the value last staged for them.
+    type touchedKey struct {
+        key       []byte
+        baseIdx   int
+        baseFound bool
+        value     []byte
+    }
+    var touched []*touchedKey
+    byKey := make(map[string]*touchedKey)
+    lo := tx.baseSize
+    for _, op := range tx.ops {
+        t, ok := byKey[string(op.key)]
+        if !ok {
+            idx, exists, err := tree.indexOf(op.key)
+            if err != nil {
+                return err
+            }
+            t = &touchedKey{key: op.key, baseIdx: idx, baseFound: exists}
+            byKey[string(op.key)] = t
+            touched = append(touched, t)
+            if exists && idx < lo {
+                lo = idx
+            }
+        }
+        t.value = op.value
+        if idx, ok := tx.keyIndex[string(op.key)]; ok && idx < lo {
+            lo = idx
+        }
+    }
+    if len(touched) == 0 {
+        return nil
+    }
+
+    entry := tree.newTxLog(TxOpCommit, nil, tree.root())
+    prevValues, prevIdx := tree.values, tree.currentIdx
+    apply := func(values [][]byte, currentIdx int, staged bool) error {
+        tree.values, tree.currentIdx = values, currentIdx
+        for _, t := range touched {
+            idx, ok := tx.keyIndex[string(t.key)]
+            if !staged {
+                idx, ok = t.baseIdx, t.baseFound
+            }
+            if ok {
+                tree.setIndex(t.key, idx)
+            } else {
+                tree.dropIndex(t.key)
+            }
+        }
+        return tree.rebuild()
+    }
+    if err := apply(append([][]byte(nil), tx.values...), len(tx.values), true); err != nil {
+        apply(prevValues, prevIdx, false)
+        return err
+    }
+
+    err = tree.writeTx(func(txn db.WriteTx) error {
+        for _, t := range touched {
+            if t.baseFound {
+                prevLeaf, err := tree.storedLeafBytes(prevValues[t.baseIdx])
+                if err != nil {
+                    return err
+                }
+                if err := dropLeafKey(txn, prevLeaf, t.key); err != nil {
+                    return err
+                }
+            }
+            idx, ok := tx.keyIndex[string(t.key)]
+            if !ok {
+                if err := txn.Delete(dbKey(prefixIndex, t.key)); err != nil {
+                    return err
+                }
+                if err := txn.Delete(dbKey(prefixValue, t.key)); err != nil {
+                    return err
+                }
+                if err := txn.Delete(dbKey(prefixMeta, t.key)); err != nil {
+                    return err
+                }
+                continue
+            }
+            if err := putLeafRecord(txn, t.key, tx.values[idx], idx); err != nil {
+                return err
+            }
+            if err := putLeafKey(txn, levels[0][idx], t.key); err != nil {
+                return err
+            }
+            if err := tree.putPreimage(txn, levels[0][idx], t.value); err != nil {
+                return err
+            }
+        }
+        if err := txn.Set(keySize, encodeUint64(uint64(len(tx.values)))); err != nil {
+            return err
+        }
+        if err := tree.putTxLog(txn, entry); err != nil {
+            return err
+        }
+        if tree.opts.PersistNodes {
+            if err := putLevelNodes(txn, levels, lo, len(tx.values)); err != nil {
+                return err
+            }
+        }
+        return tree.putRoot(txn)
+    })
+    if err != nil {
+        apply(prevValues, prevIdx, false)
+        return err
+    }
+    tree.advanceTxLog(entry)
+    return nil
+}
+
+// Rollback discards the staged operations.
+func (tx *TreeTx) Rollback() error {
+    if tx.done {
+        return errors.New("transaction already finished")
+    }
+    tx.done = true
+    tx.ops = nil
+    return nil
+}
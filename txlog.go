@@ -0,0 +1,173 @@
+package main
+
+import (
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "time"
+
+    "go.vocdoni.io/dvote/db"
+)
+
+// The transaction log records every committed mutation, in the same
+// database transaction as the mutation itself:
+//
+//     log/<seq, uint64 big-endian> -> uvarint(len(op)) | op | uvarint(len(key)) | key |
+//                                     prev root | next root | unix nanoseconds (uint64 big-endian)
+//     tree/txlog                   -> next sequence number, uint64 little-endian
+var (
+    prefixTxLog = []byte("log/")
+    keyTxLogSeq = []byte("tree/txlog")
+)
+
+// Operations recorded in TxLog.Op.
+const (
+    TxOpAdd      = "add"
+    TxOpUpdate   = "update"
+    TxOpDelete   = "delete"
+    TxOpAddBatch = "addbatch" // Key is nil; the keys are those at the new indexes
+    TxOpCommit   = "commit"   // Key is nil; a TreeTx committed as one mutation
+)
+
+// TxLog is one committed mutation and the roots before and after it.
+type TxLog struct {
+    Seq                uint64
+    Op                 string
+    Key                []byte
+    PrevRoot, NextRoot []byte
+    Timestamp          time.Time
+}
+
+// newTxLog starts the log entry for a mutation of key, given the root
+// before it. The next root is filled in by putTxLog.
+func (tree *MerkleTree) newTxLog(op string, key, prevRoot []byte) TxLog {
+    return TxLog{
+        Seq:      tree.txLogSeq,
+        Op:       op,
+        Key:      key,
+        PrevRoot: prevRoot,
+    }
+}
+
+// putTxLog writes entry with the current root as its next root. Once the
+// transaction commits, the caller advances tree.txLogSeq past entry.Seq.
+func (tree *MerkleTree) putTxLog(txn db.WriteTx, entry TxLog) error {
+    out := binary.AppendUvarint(nil, uint64(len(entry.Op)))
+    out = append(out, entry.Op...)
+    out = binary.AppendUvarint(out, uint64(len(entry.Key)))
+    out = append(out, entry.Key...)
+    out = append(out, entry.PrevRoot...)
+    out = append(out, tree.root()...)
+    out = binary.BigEndian.AppendUint64(out, uint64(time.Now().UnixNano()))
+
+    if err := txn.Set(txLogKey(entry.Seq), out); err != nil {
+        return err
+    }
+    return txn.Set(keyTxLogSeq, encodeUint64(entry.Seq+1))
+}
+
+// advanceTxLog moves past entry once its mutation has committed, retains
+// the new root as the version entry.Seq+1 (see RootAt) and publishes it for
+// RootAndSize.
+func (tree *MerkleTree) advanceTxLog(entry TxLog) {
+    tree.txLogSeq = entry.Seq + 1
+    tree.versions.push(tree.txLogSeq, tree.root(), uint(len(tree.values)), tree.retainedVersions())
+    tree.publishHead()
+}
+
+func txLogKey(seq uint64) []byte {
+    seqBytes := make([]byte, 8)
+    binary.BigEndian.PutUint64(seqBytes, seq)
+    return dbKey(prefixTxLog, seqBytes)
+}
+
+func decodeTxLog(seq uint64, value []byte) (TxLog, error) {
+    errMalformed := fmt.Errorf("malformed tx log entry %d", seq)
+
+    readBytes := func() ([]byte, error) {
+        n, size := binary.Uvarint(value)
+        if size <= 0 || uint64(len(value)-size) < n {
+            return nil, errMalformed
+        }
+        out := append([]byte(nil), value[size:size+int(n)]...)
+        value = value[size+int(n):]
+        return out, nil
+    }
+
+    op, err := readBytes()
+    if err != nil {
+        return TxLog{}, err
+    }
+    key, err := readBytes()
+    if err != nil {
+        return TxLog{}, err
+    }
+    if len(value) != 2*fpSize+8 {
+        return TxLog{}, errMalformed
+    }
+    if len(key) == 0 {
+        key = nil
+    }
+
+    return TxLog{
+        Seq:       seq,
+        Op:        string(op),
+        Key:       key,
+        PrevRoot:  append([]byte(nil), value[:fpSize]...),
+        NextRoot:  append([]byte(nil), value[fpSize:2*fpSize]...),
+        Timestamp: time.Unix(0, int64(binary.BigEndian.Uint64(value[2*fpSize:]))),
+    }, nil
+}
+
+// TxLogs returns the logged mutations with fromSeq <= Seq < toSeq, in
+// order. Roots are in the API byte order, like Root. Nothing is logged with
+// NoPersistence.
+func (tree *MerkleTree) TxLogs(fromSeq, toSeq uint64) ([]TxLog, error) {
+    if tree.opts.NoPersistence || fromSeq >= toSeq {
+        return nil, nil
+    }
+
+    rtx := tree.db.ReadTx()
+    defer rtx.Discard()
+
+    var logs []TxLog
+    var decodeErr error
+    err := rtx.Iterate(prefixTxLog, func(k, v []byte) bool {
+        seq := binary.BigEndian.Uint64(k)
+        if seq < fromSeq {
+            return true
+        }
+        if seq >= toSeq {
+            return false
+        }
+        entry, err := decodeTxLog(seq, v)
+        if err != nil {
+            decodeErr = err
+            return false
+        }
+        entry.PrevRoot = tree.toAPI(entry.PrevRoot)
+        entry.NextRoot = tree.toAPI(entry.NextRoot)
+        logs = append(logs, entry)
+        return true
+    })
+    if err != nil {
+        return nil, err
+    }
+    if decodeErr != nil {
+        return nil, decodeErr
+    }
+    return logs, nil
+}
+
+// loadTxLogSeq restores the next transaction log sequence number.
+func (tree *MerkleTree) loadTxLogSeq(rtx db.ReadTx) error {
+    seq, err := rtx.Get(keyTxLogSeq)
+    if errors.Is(err, db.ErrKeyNotFound) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    tree.txLogSeq = binary.LittleEndian.Uint64(seq)
+    return nil
+}
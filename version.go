@@ -0,0 +1,69 @@
+package main
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+)
+
+// Version is the semantic version of this package. It is unrelated to
+// LibraryVersion, the version of the linked Rust library.
+const Version = "0.1.0"
+
+// protocolVersion identifies the proof encoding and database schema this
+// package reads and writes: currently proofVersion1 proofs and SchemaV2.
+// It is bumped whenever either changes, and never goes down.
+const protocolVersion = 1
+
+// ProtocolVersion returns the protocolVersion: peers and stored trees with
+// the same value can exchange proofs and databases.
+func ProtocolVersion() int {
+    return protocolVersion
+}
+
+// minLibraryVersion is the oldest libsimple_example this package works
+// with: 0.3.0 added the tree_* functions that address trees by handle.
+const minLibraryVersion = "0.3.0"
+
+// RequireLibraryVersion returns an error if the linked libsimple_example is
+// older than min, or if either version is not of the form major.minor.patch.
+func RequireLibraryVersion(min string) error {
+    want, err := parseLibraryVersion(min)
+    if err != nil {
+        return err
+    }
+    have, err := parseLibraryVersion(LibraryVersion())
+    if err != nil {
+        return err
+    }
+
+    for i := range want {
+        if have[i] != want[i] {
+            if have[i] < want[i] {
+                return fmt.Errorf("libsimple_example %s is linked, %s or newer is required", LibraryVersion(), min)
+            }
+            return nil
+        }
+    }
+    return nil
+}
+
+// parseLibraryVersion parses major.minor.patch, ignoring any pre-release or
+// build suffix after the patch number.
+func parseLibraryVersion(v string) ([3]int, error) {
+    var out [3]int
+    core, _, _ := strings.Cut(v, "-")
+    core, _, _ = strings.Cut(core, "+")
+    parts := strings.Split(core, ".")
+    if len(parts) != 3 {
+        return out, fmt.Errorf("malformed library version %q", v)
+    }
+    for i, p := range parts {
+        n, err := strconv.Atoi(p)
+        if err != nil || n < 0 {
+            return out, fmt.Errorf("malformed library version %q", v)
+        }
+        out[i] = n
+    }
+    return out, nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestLibraryVersion(t *testing.T) {
+    v := LibraryVersion()
+    if v == "" {
+        t.Fatal("linked library reports no version")
+    }
+    if _, err := parseLibraryVersion(v); err != nil {
+        t.Fatal(err)
+    }
+    if err := RequireLibraryVersion(minLibraryVersion); err != nil {
+        t.Fatal(err)
+    }
+    if err := RequireLibraryVersion("999.0.0"); err == nil {
+        t.Fatalf("library %s satisfies a requirement of 999.0.0", v)
+    }
+
+    for _, bad := range []string{"", "1.2", "1.2.x", "1.-2.3"} {
+        if _, err := parseLibraryVersion(bad); err == nil {
+            t.Errorf("parsed malformed version %q", bad)
+        }
+    }
+    if got, err := parseLibraryVersion("1.2.3-rc.1+build"); err != nil || got != [3]int{1, 2, 3} {
+        t.Errorf("parse of 1.2.3-rc.1+build = %v, %v; want [1 2 3]", got, err)
+    }
+}
+
+func TestVersion(t *testing.T) {
+    if _, err := parseLibraryVersion(Version); err != nil {
+        t.Fatalf("package version: %v", err)
+    }
+    if ProtocolVersion() < 1 {
+        t.Fatalf("protocol version %d, want at least 1", ProtocolVersion())
+    }
+}
@@ -0,0 +1,99 @@
+package main
+
+import "fmt"
+
+// defaultRetainedVersions is the number of roots RootAt keeps by default.
+const defaultRetainedVersions = 256
+
+// A version of the tree is the number of mutations committed to it, the
+// sequence number of the next transaction log entry: every Add, Update,
+// Delete and AddBatch creates one. rootRing holds the roots of the most
+// recent versions in memory, oldest first, with the number of leaf slots
+// the tree had at each.
+type rootRing struct {
+    first uint64 // version of roots[0]
+    roots [][]byte
+    sizes []uint
+}
+
+// push records root and size as those of version, dropping the oldest
+// entries beyond limit.
+func (r *rootRing) push(version uint64, root []byte, size uint, limit int) {
+    if len(r.roots) == 0 || version != r.first+uint64(len(r.roots)) {
+        r.first, r.roots, r.sizes = version, r.roots[:0], r.sizes[:0]
+    }
+    r.roots = append(r.roots, root)
+    r.sizes = append(r.sizes, size)
+    if drop := len(r.roots) - limit; drop > 0 {
+        r.roots = append(r.roots[:0], r.roots[drop:]...)
+        r.sizes = append(r.sizes[:0], r.sizes[drop:]...)
+        r.first += uint64(drop)
+    }
+}
+
+func (r *rootRing) get(version uint64) (root []byte, size uint, ok bool) {
+    if version < r.first || version-r.first >= uint64(len(r.roots)) {
+        return nil, 0, false
+    }
+    return r.roots[version-r.first], r.sizes[version-r.first], true
+}
+
+func (tree *MerkleTree) retainedVersions() int {
+    if tree.opts.RetainedVersions > 0 {
+        return tree.opts.RetainedVersions
+    }
+    return defaultRetainedVersions
+}
+
+// Version returns the current version of the tree; see RootAt.
+func (tree *MerkleTree) Version() uint64 {
+    return tree.txLogSeq
+}
+
+// RootAt returns the root the tree had at version, the number of mutations
+// committed so far when it was current. The current version is always
+// available; older ones only while they are among the last
+// WithRetainedVersions versions committed by this MerkleTree, and
+// ErrVersionNotRetained is returned otherwise. The root is in its in-memory
+// form, as proofs are.
+func (tree *MerkleTree) RootAt(version uint64) ([]byte, error) {
+    root, _, err := tree.rootAndSizeAt(version)
+    return root, err
+}
+
+// rootAndSizeAt is RootAt also returning the number of leaf slots the tree
+// had at version.
+func (tree *MerkleTree) rootAndSizeAt(version uint64) ([]byte, uint, error) {
+    if version == tree.txLogSeq {
+        return tree.root(), uint(len(tree.values)), nil
+    }
+    root, size, ok := tree.versions.get(version)
+    if !ok {
+        return nil, 0, fmt.Errorf("version %d (current %d): %w", version, tree.txLogSeq, ErrVersionNotRetained)
+    }
+    return root, size, nil
+}
+
+// VerifyAtVersion reports whether p proves the leaf of key against the root
+// the tree had at version. Keys keep their leaf index for as long as they
+// are in the tree, so p must be for the index key holds now; a key deleted
+// since fails with ErrKeyNotFound.
+func (tree *MerkleTree) VerifyAtVersion(key []byte, p *MerkleProof, version uint64) (_ bool, err error) {
+    defer tree.stats.record(&tree.stats.verifications, &err)
+
+    root, err := tree.RootAt(version)
+    if err != nil {
+        return false, err
+    }
+    idx, exists, err := tree.indexOf(key)
+    if err != nil {
+        return false, err
+    }
+    if !exists {
+        return false, ErrKeyNotFound
+    }
+    if uint(idx) != p.Index {
+        return false, nil
+    }
+    return VerifyProof(root, p)
+}
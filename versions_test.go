@@ -0,0 +1,66 @@
+package main
+
+import (
+    "bytes"
+    "errors"
+    "testing"
+)
+
+func TestVerifyAtVersion(t *testing.T) {
+    const retained = 3
+    tree := newTestTree(t, WithRetainedVersions(retained))
+    keys, values := testLeaves(6)
+
+    var versions []uint64
+    var roots [][]byte
+    var proofs []*MerkleProof
+    for i, key := range keys {
+        if err := tree.Add(key, values[i]); err != nil {
+            t.Fatal(err)
+        }
+        proof, err := tree.GenProof(keys[0])
+        if err != nil {
+            t.Fatal(err)
+        }
+        versions = append(versions, tree.Version())
+        roots = append(roots, tree.Root())
+        proofs = append(proofs, proof)
+    }
+
+    // The current version counts towards the retained ones.
+    current := len(versions) - 1
+    for i, version := range versions {
+        if i <= current-retained {
+            if _, err := tree.RootAt(version); !errors.Is(err, ErrVersionNotRetained) {
+                t.Fatalf("root at aged out version %d = %v, want ErrVersionNotRetained", version, err)
+            }
+            if _, err := tree.VerifyAtVersion(keys[0], proofs[i], version); !errors.Is(err, ErrVersionNotRetained) {
+                t.Fatalf("verify at aged out version %d = %v, want ErrVersionNotRetained", version, err)
+            }
+            continue
+        }
+
+        root, err := tree.RootAt(version)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !bytes.Equal(root, roots[i]) {
+            t.Fatalf("root at version %d = %x, want %x", version, root, roots[i])
+        }
+        ok, err := tree.VerifyAtVersion(keys[0], proofs[i], version)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !ok {
+            t.Fatalf("proof from version %d does not verify at it", version)
+        }
+        if i > 0 {
+            if ok, _ := tree.VerifyAtVersion(keys[0], proofs[i-1], version); ok {
+                t.Fatalf("proof from version %d verifies at version %d", versions[i-1], version)
+            }
+        }
+        if ok, _ := tree.VerifyAtVersion(keys[1], proofs[i], version); ok {
+            t.Fatalf("proof of %s verifies for %s", keys[0], keys[1])
+        }
+    }
+}
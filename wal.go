@@ -0,0 +1,149 @@
+package main
+
+import (
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "sort"
+
+    "go.vocdoni.io/dvote/db"
+)
+
+// The write-ahead log records every insertion before the C tree is mutated.
+// Each entry is deleted in the same transaction that persists the leaf, so
+// any entry still present on load belongs to an insertion that was
+// interrupted between the C mutation and the DB commit. LoadMerkleTree
+// replays those entries in sequence order; see load.go.
+//
+// Entry layout: uvarint(len(key)) | key | stored value.
+
+func walKey(seq uint64) []byte {
+    seqBytes := make([]byte, 8)
+    binary.BigEndian.PutUint64(seqBytes, seq)
+    return dbKey(prefixWAL, seqBytes)
+}
+
+func encodeWALEntry(key, stored []byte) []byte {
+    out := binary.AppendUvarint(nil, uint64(len(key)))
+    out = append(out, key...)
+    return append(out, stored...)
+}
+
+func decodeWALEntry(entry []byte) (key, stored []byte, err error) {
+    keyLen, n := binary.Uvarint(entry)
+    if n <= 0 || uint64(len(entry)-n) < keyLen {
+        return nil, nil, errors.New("malformed wal entry")
+    }
+    key = entry[n : n+int(keyLen)]
+    stored = entry[n+int(keyLen):]
+    return key, stored, nil
+}
+
+// logPending durably records the pending insertions and returns their
+// sequence numbers.
+func (tree *MerkleTree) logPending(keys, stored [][]byte) ([]uint64, error) {
+    seqs := make([]uint64, len(keys))
+    for i := range keys {
+        seqs[i] = tree.walSeq + uint64(i)
+    }
+    err := tree.writeTx(func(txn db.WriteTx) error {
+        for i := range keys {
+            if err := txn.Set(walKey(seqs[i]), encodeWALEntry(keys[i], stored[i])); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    tree.walSeq += uint64(len(keys))
+    return seqs, nil
+}
+
+func clearPending(txn db.WriteTx, seqs []uint64) error {
+    for _, seq := range seqs {
+        if err := txn.Delete(walKey(seq)); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (tree *MerkleTree) replayWAL(rtx db.ReadTx) error {
+    type pending struct {
+        seq   uint64
+        entry []byte
+    }
+
+    var entries []pending
+    err := rtx.Iterate(prefixWAL, func(k, v []byte) bool {
+        entries = append(entries, pending{
+            seq:   binary.BigEndian.Uint64(k),
+            entry: append([]byte(nil), v...),
+        })
+        return true
+    })
+    if err != nil {
+        return err
+    }
+    sort.Slice(entries, func(i, j int) bool { return entries[i].seq < entries[j].seq })
+
+    for _, e := range entries {
+        key, stored, err := decodeWALEntry(e.entry)
+        if err != nil {
+            return fmt.Errorf("wal entry %d: %w", e.seq, err)
+        }
+
+        _, exists, err := tree.indexOf(key)
+        if err != nil {
+            return err
+        }
+        idx := -1
+        var leafBytes []byte
+        entry := tree.newTxLog(TxOpAdd, key, tree.root())
+        if !exists {
+            leaf, err := tree.storedLeaf(stored)
+            if err != nil {
+                return fmt.Errorf("wal entry %d: %w", e.seq, err)
+            }
+            leafBytes = fpToBytes(&leaf)
+            if idx, err = tree.insertLeaf(key, leaf, stored); err != nil {
+                return err
+            }
+        }
+        err = tree.writeTx(func(txn db.WriteTx) error {
+            if idx >= 0 {
+                if err := putLeafRecord(txn, key, stored, idx); err != nil {
+                    return err
+                }
+                if err := putLeafKey(txn, leafBytes, key); err != nil {
+                    return err
+                }
+                if err := tree.putTxLog(txn, entry); err != nil {
+                    return err
+                }
+                if err := tree.putNodes(txn, idx, idx+1); err != nil {
+                    return err
+                }
+                if err := tree.putRoot(txn); err != nil {
+                    return err
+                }
+            }
+            return clearPending(txn, []uint64{e.seq})
+        })
+        if err != nil {
+            return err
+        }
+        if idx >= 0 {
+            tree.advanceTxLog(entry)
+        }
+
+        if e.seq >= tree.walSeq {
+            tree.walSeq = e.seq + 1
+        }
+    }
+
+    return nil
+}
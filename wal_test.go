@@ -0,0 +1,153 @@
+package main
+
+import (
+    "bytes"
+    "errors"
+    "testing"
+
+    "go.vocdoni.io/dvote/db"
+)
+
+var errInjected = errors.New("injected commit failure")
+
+// failDB lets the next skip commits through and fails the fail commits
+// after them with errInjected.
+type failDB struct {
+    db.Database
+    skip, fail int
+}
+
+func (d *failDB) WriteTx() db.WriteTx {
+    return &failTx{WriteTx: d.Database.WriteTx(), db: d}
+}
+
+type failTx struct {
+    db.WriteTx
+    db *failDB
+}
+
+func (tx *failTx) Commit() error {
+    if tx.db.skip > 0 {
+        tx.db.skip--
+    } else if tx.db.fail > 0 {
+        tx.db.fail--
+        return errInjected
+    }
+    return tx.WriteTx.Commit()
+}
+
+// TestWALReplayAfterCrash simulates a crash between the C tree insertion
+// and the database commit of an Add: the WAL entry is written and the leaf
+// inserted in memory, but the leaf records never are. Loading the database
+// again must complete the insertion.
+func TestWALReplayAfterCrash(t *testing.T) {
+    database := newTestDB(t)
+    keys, values := testLeaves(4)
+
+    tree := NewMerkleTree(database)
+    addAll(t, tree, keys[:3], values[:3])
+
+    leaf, stored, err := tree.leaf(keys[3], values[3])
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := tree.logPending(keys[3:], [][]byte{stored}); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := tree.insertLeaf(keys[3], leaf, stored); err != nil {
+        t.Fatal(err)
+    }
+    tree.Close() // the crash: nothing else reaches the database
+
+    want := newTestTree(t, WithNoPersistence())
+    addAll(t, want, keys, values)
+
+    loaded, err := LoadMerkleTreeStrict(database)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer loaded.Close()
+
+    if !bytes.Equal(loaded.Root(), want.Root()) {
+        t.Fatalf("root after replay %x, want %x", loaded.Root(), want.Root())
+    }
+    got, err := loaded.Get(keys[3])
+    if err != nil {
+        t.Fatalf("replayed key: %v", err)
+    }
+    if !bytes.Equal(got, values[3]) {
+        t.Errorf("replayed value %x, want %x", got, values[3])
+    }
+    if err := loaded.CheckConsistency(); err != nil {
+        t.Error(err)
+    }
+
+    rtx := database.ReadTx()
+    defer rtx.Discard()
+    err = rtx.Iterate(prefixWAL, func(k, _ []byte) bool {
+        t.Errorf("wal entry %x left after replay", k)
+        return true
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // A second load finds nothing left to replay.
+    again, err := LoadMerkleTreeStrict(database)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer again.Close()
+    if !bytes.Equal(again.Root(), want.Root()) {
+        t.Errorf("root after reloading %x, want %x", again.Root(), want.Root())
+    }
+}
+
+
+// TestAddUndoneOnCommitFailure fails the commit of an Add after its WAL
+// entry is written. The tree must be left as it was, so the add can be
+// retried and the database loads to the same tree.
+func TestAddUndoneOnCommitFailure(t *testing.T) {
+    database := &failDB{Database: newTestDB(t)}
+    keys, values := testLeaves(3)
+
+    tree := NewMerkleTree(database)
+    defer tree.Close()
+    addAll(t, tree, keys[:2], values[:2])
+    before := tree.Root()
+
+    database.skip, database.fail = 1, 1
+    if err := tree.Add(keys[2], values[2]); !errors.Is(err, errInjected) {
+        t.Fatalf("add with a failing commit = %v, want the injected error", err)
+    }
+    if !bytes.Equal(tree.Root(), before) {
+        t.Fatalf("root %x after a failed add, want %x", tree.Root(), before)
+    }
+    if _, err := tree.Get(keys[2]); !errors.Is(err, ErrKeyNotFound) {
+        t.Fatalf("get after a failed add = %v, want ErrKeyNotFound", err)
+    }
+    if err := tree.CheckConsistency(); err != nil {
+        t.Fatal(err)
+    }
+    rtx := database.ReadTx()
+    err := rtx.Iterate(prefixWAL, func(k, _ []byte) bool {
+        t.Errorf("wal entry %x left after a failed add", k)
+        return true
+    })
+    rtx.Discard()
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if err := tree.Add(keys[2], values[2]); err != nil {
+        t.Fatalf("retrying the add: %v", err)
+    }
+    loaded, err := LoadMerkleTreeStrict(database)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer loaded.Close()
+    if !bytes.Equal(loaded.Root(), tree.Root()) {
+        t.Fatalf("loaded root %x, want %x", loaded.Root(), tree.Root())
+    }
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+    "encoding/binary"
+    "fmt"
+)
+
+// wideLeafBytes returns the leaf of a value spread over WideLeaves field
+// elements. The value is cut into fpSize-byte chunks e_0..e_{n-1}, missing
+// trailing chunks being zero, each read like a single-element value, and
+// folded onto its length in bytes, len, as
+//
+//     leaf = H(...H(H(len, e_0), e_1)..., e_{n-1})
+//
+// Folding in the length keeps a value apart from the same value with zero
+// bytes appended, which would otherwise give the same chunks.
+func (tree *MerkleTree) wideLeafBytes(value []byte) ([]byte, error) {
+    n := tree.opts.WideLeaves
+    if len(value) > n*fpSize {
+        return nil, fmt.Errorf("value is %d bytes, at most %d fit in %d field elements", len(value), n*fpSize, n)
+    }
+
+    chunk := func(i int) []byte {
+        lo, hi := i*fpSize, (i+1)*fpSize
+        if lo >= len(value) {
+            return nil
+        }
+        if hi > len(value) {
+            hi = len(value)
+        }
+        return tree.fromAPI(value[lo:hi])
+    }
+
+    acc := make([]byte, fpSize)
+    binary.LittleEndian.PutUint64(acc, uint64(len(value)))
+    for i := 0; i < n; i++ {
+        var err error
+        if acc, err = HashTwo(acc, chunk(i)); err != nil {
+            return nil, err
+        }
+    }
+    return acc, nil
+}
+
+// A tree with WideLeaves but without StoreHashedValues stores values as
+// given, and any of them may be up to WideLeaves*fpSize bytes, so a leaf
+// added as is, by AddRawLeaf or RevealCommitment, cannot be stored as its
+// bare hash: it would be read back as a value and hashed again. It is
+// stored instead as the hash, in its API form, padded with zeros to one
+// byte more than any value can have.
+
+// wideRawStored returns the stored form of the raw leaf h, given in its API
+// form, in a wide tree.
+func (tree *MerkleTree) wideRawStored(h []byte) []byte {
+    stored := make([]byte, tree.opts.WideLeaves*fpSize+1)
+    copy(stored, h)
+    return stored
+}
+
+// isWideRaw reports whether stored is a raw leaf in a wide tree.
+func (tree *MerkleTree) isWideRaw(stored []byte) bool {
+    return tree.opts.WideLeaves > 1 && !tree.opts.StoreHashedValues && len(stored) == tree.opts.WideLeaves*fpSize+1
+}
+
+// rawStored returns what a tree stores for the raw leaf h, given in its API
+// form: its in-memory form with StoreHashedValues, h padded as above in a
+// wide tree, and h itself otherwise.
+func (tree *MerkleTree) rawStored(h []byte) []byte {
+    if tree.opts.StoreHashedValues {
+        return tree.fromAPI(h)
+    }
+    if tree.opts.WideLeaves > 1 {
+        return tree.wideRawStored(h)
+    }
+    return append([]byte(nil), h...)
+}
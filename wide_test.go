@@ -0,0 +1,41 @@
+package main
+
+import (
+    "bytes"
+    "testing"
+)
+
+func TestWideLeavesSixtyFourByteValue(t *testing.T) {
+    tree := newTestTree(t, WithWideLeaves(2))
+    value := make([]byte, 64)
+    for i := range value {
+        value[i] = byte(i)
+    }
+    keys, values := testLeaves(3)
+    addAll(t, tree, keys, values)
+    if err := tree.Add([]byte("wide"), value); err != nil {
+        t.Fatal(err)
+    }
+
+    got, err := tree.Get([]byte("wide"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(got, value) {
+        t.Fatalf("read back %x, want %x", got, value)
+    }
+    if err := tree.CheckProof([]byte("wide"), value); err != nil {
+        t.Fatal(err)
+    }
+
+    // The second field element is part of the leaf.
+    changed := append([]byte(nil), value...)
+    changed[63]++
+    if err := tree.CheckProof([]byte("wide"), changed); err == nil {
+        t.Fatal("proof commits to a value differing in its second field element")
+    }
+
+    if err := tree.Add([]byte("too-wide"), make([]byte, 65)); err == nil {
+        t.Fatal("added a 65-byte value to a tree of two-element leaves")
+    }
+}
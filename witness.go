@@ -0,0 +1,77 @@
+package main
+
+import (
+    "crypto/rand"
+    "fmt"
+    "io"
+    "math/big"
+    "sort"
+)
+
+// WitnessProof is a MerkleProof bundled with the root of the tree it was
+// generated from, so a verifier needs nothing from the tree itself.
+type WitnessProof struct {
+    MerkleProof
+    Root []byte
+}
+
+// GenWitnessProof returns the proof for key together with the current root.
+func (tree *MerkleTree) GenWitnessProof(key []byte) (*WitnessProof, error) {
+    proof, err := tree.GenProof(key)
+    if err != nil {
+        return nil, err
+    }
+    return &WitnessProof{MerkleProof: *proof, Root: tree.root()}, nil
+}
+
+// VerifyWitness reports whether the root recomputed from w's leaf and
+// siblings matches w.Root.
+func VerifyWitness(w *WitnessProof) (bool, error) {
+    return VerifyProof(w.Root, &w.MerkleProof)
+}
+
+// GenKofNProof picks k distinct keys uniformly at random and returns their
+// witness proofs, so a holder can show k memberships without choosing
+// which. Randomness is drawn from rng, or crypto/rand if rng is nil; the
+// same rng stream over the same tree yields the same selection.
+func (tree *MerkleTree) GenKofNProof(k int, rng io.Reader) ([]WitnessProof, error) {
+    if rng == nil {
+        rng = rand.Reader
+    }
+
+    // Candidates are ordered by leaf index so the selection depends only on
+    // the tree and the rng, not on map iteration order.
+    var indexes []int
+    err := tree.eachIndex(func(_ []byte, idx int) bool {
+        indexes = append(indexes, idx)
+        return true
+    })
+    if err != nil {
+        return nil, err
+    }
+    if k < 0 || k > len(indexes) {
+        return nil, fmt.Errorf("cannot pick %d of %d keys", k, len(indexes))
+    }
+    sort.Ints(indexes)
+
+    // Partial Fisher-Yates shuffle: the first k entries end up a uniform
+    // sample.
+    root := tree.root()
+    proofs := make([]WitnessProof, k)
+    for i := 0; i < k; i++ {
+        j, err := rand.Int(rng, big.NewInt(int64(len(indexes)-i)))
+        if err != nil {
+            return nil, err
+        }
+        pick := i + int(j.Int64())
+        indexes[i], indexes[pick] = indexes[pick], indexes[i]
+
+        proof, err := tree.proofAt(uint(indexes[i]))
+        if err != nil {
+            return nil, err
+        }
+        proofs[i] = WitnessProof{MerkleProof: *proof, Root: root}
+    }
+
+    return proofs, nil
+}